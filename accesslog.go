@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Access log formats
+const (
+	// Text lines of the gin logger
+	accessLogText = "text"
+	// One JSON object per request
+	accessLogJSON = "json"
+	// Apache combined log format
+	accessLogCombined = "combined"
+)
+
+// Context key of the error code of a failed request, set by writeError
+const ctxErrorCode = "errorCode"
+
+// Access log config type
+type accessLogConfig struct {
+	Format string `json:"format" yaml:"format" toml:"format"`
+	// Log file, default is the standard output
+	File string `json:"file" yaml:"file" toml:"file"`
+	// Size (in megabytes) of the log file before it is rotated
+	MaxSize int `json:"maxSize" yaml:"maxSize" toml:"maxSize"`
+	// Number of rotated files kept, all by default
+	MaxBackups int  `json:"maxBackups" yaml:"maxBackups" toml:"maxBackups"`
+	Compress   bool `json:"compress" yaml:"compress" toml:"compress"`
+	// Upload of the access logs to a bucket, besides the file or the standard output
+	S3 accessLogS3Config `json:"s3" yaml:"s3" toml:"s3"`
+}
+
+// Set the access log defaults and check the format
+func (cfg *accessLogConfig) validate() error {
+	if cfg.Format == "" {
+		cfg.Format = accessLogText
+	}
+	switch cfg.Format {
+	case accessLogText, accessLogJSON, accessLogCombined:
+	default:
+		return fmt.Errorf("invalid accessLog format %s, must be text, json or combined", cfg.Format)
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 100
+	}
+	return cfg.S3.validate()
+}
+
+// Writer of the access log, the file is rotated once larger than maxSize. The lines are also
+// shipped to S3 if enabled.
+func (cfg accessLogConfig) writer() io.Writer {
+	var out io.Writer = os.Stdout
+	if cfg.File != "" {
+		out = &lumberjack.Logger{Filename: cfg.File, MaxSize: cfg.MaxSize, MaxBackups: cfg.MaxBackups, Compress: cfg.Compress}
+	}
+	if cfg.S3.enabled() {
+		logs = newLogShipper(cfg.S3)
+		out = io.MultiWriter(out, logs)
+	}
+	return out
+}
+
+// Access log entry type of the JSON format
+type accessLogEntry struct {
+	Time        string  `json:"time"`
+	Method      string  `json:"method"`
+	Path        string  `json:"path"`
+	Query       string  `json:"query,omitempty"`
+	Status      int     `json:"status"`
+	Bytes       int     `json:"bytes"`
+	DurationMs  float64 `json:"durationMs"`
+	ClientIP    string  `json:"clientIp"`
+	User        string  `json:"user,omitempty"`
+	RequestID   string  `json:"requestId,omitempty"`
+	ErrorCode   string  `json:"errorCode,omitempty"`
+	S3RequestID string  `json:"s3RequestId,omitempty"`
+	S3HostID    string  `json:"s3HostId,omitempty"`
+	UserAgent   string  `json:"userAgent,omitempty"`
+	Referer     string  `json:"referer,omitempty"`
+}
+
+// Access log middleware writing a line per request in the configured format
+func accessLogMiddleware(cfg accessLogConfig) gin.HandlerFunc {
+	out := cfg.writer()
+	if cfg.Format == accessLogText {
+		return gin.LoggerWithWriter(out)
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := accessLogEntry{
+			Time:       start.Format(time.RFC3339),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Query:      c.Request.URL.RawQuery,
+			Status:     c.Writer.Status(),
+			Bytes:      c.Writer.Size(),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			ClientIP:   c.ClientIP(),
+			User:       c.GetString(gin.AuthUserKey),
+			RequestID:  c.GetString(ctxRequestID),
+			ErrorCode:  c.GetString(ctxErrorCode),
+			UserAgent:  c.Request.UserAgent(),
+			Referer:    c.Request.Referer(),
+		}
+		if trace := requestTraceOf(c); trace != nil {
+			entry.S3RequestID, entry.S3HostID = trace.last()
+		}
+		if entry.Bytes < 0 {
+			entry.Bytes = 0
+		}
+		if cfg.Format == accessLogJSON {
+			line, _ := json.Marshal(entry)
+			out.Write(append(line, '\n'))
+			return
+		}
+		fmt.Fprintf(out, "%s - %s [%s] \"%s %s %s\" %d %d \"%s\" \"%s\"\n",
+			entry.ClientIP, orDash(entry.User), start.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.Method, c.Request.URL.RequestURI(), c.Request.Proto, entry.Status, entry.Bytes,
+			orDash(entry.Referer), orDash(entry.UserAgent))
+	}
+}
+
+// Value of a combined log field, "-" when empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// Check a bucket name from the configuration.
+// Besides plain bucket names and access point aliases, an S3 Access Point ARN
+// (arn:aws:s3:region:account:accesspoint/name) is accepted and handled by the SDK.
+func checkBucketName(name string) error {
+	if !arn.IsARN(name) {
+		return nil
+	}
+	a, err := arn.Parse(name)
+	if err != nil {
+		return fmt.Errorf("invalid bucket ARN %s: %v", name, err)
+	}
+	if a.Service != "s3" || !(strings.HasPrefix(a.Resource, "accesspoint/") || strings.HasPrefix(a.Resource, "accesspoint:")) {
+		return fmt.Errorf("bucket ARN %s is not an S3 access point ARN", name)
+	}
+	if a.Region == "" || strings.HasSuffix(a.Resource, ".mrap") {
+		// Multi-Region Access Points are signed with SigV4A which is not supported by the AWS SDK v1
+		return fmt.Errorf("bucket ARN %s is a Multi-Region Access Point, which is not supported: use one of its regional access points instead", name)
+	}
+	return nil
+}
+
+// Get the region of an access point ARN, or an empty string for a plain bucket name
+func bucketARNRegion(name string) string {
+	if !arn.IsARN(name) {
+		return ""
+	}
+	a, err := arn.Parse(name)
+	if err != nil {
+		return ""
+	}
+	return a.Region
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Access control rule config type, the first rule whose pattern matches the path applies.
+// The paths matching no rule keep the access given by the auth section.
+type aclRule struct {
+	// Glob pattern of the path, as the headers patterns ("public/**")
+	Pattern string `json:"pattern" yaml:"pattern" toml:"pattern"`
+	// Allowed methods, GET allows HEAD too, default is all
+	Methods []string `json:"methods" yaml:"methods" toml:"methods"`
+	// Allow the clients without credentials
+	Anonymous bool `json:"anonymous" yaml:"anonymous" toml:"anonymous"`
+	// Names of the allowed clients, default is any authenticated client
+	Users []string `json:"users" yaml:"users" toml:"users"`
+	// Allowed OIDC groups
+	Groups []string `json:"groups" yaml:"groups" toml:"groups"`
+}
+
+// Check the access control rules, put the methods in upper case and remove the leading / of the patterns
+func validateACL(rules []aclRule) error {
+	for i := range rules {
+		rule := &rules[i]
+		rule.Pattern = strings.TrimPrefix(rule.Pattern, "/")
+		if rule.Pattern == "" {
+			return fmt.Errorf("missing acl pattern of rule %d", i+1)
+		}
+		if err := validatePathPattern(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid acl pattern %s: %v", rule.Pattern, err)
+		}
+		for j, method := range rule.Methods {
+			rule.Methods[j] = strings.ToUpper(method)
+		}
+	}
+	return nil
+}
+
+// Find the access control rule of a path (without leading /), nil if none matches
+func (config *webConfig) findACLRule(path string) *aclRule {
+	for i, rule := range config.ACL {
+		if matchPathPattern(rule.Pattern, path) {
+			return &config.ACL[i]
+		}
+	}
+	return nil
+}
+
+// Check if a rule allows a method
+func (rule aclRule) allowsMethod(method string) bool {
+	if len(rule.Methods) == 0 || containsString(rule.Methods, method) {
+		return true
+	}
+	return method == http.MethodHead && containsString(rule.Methods, http.MethodGet)
+}
+
+// Check if a rule allows an authenticated client
+func (rule aclRule) allowsIdentity(identity *authIdentity) bool {
+	if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+	if containsString(rule.Users, identity.Name) {
+		return true
+	}
+	for _, group := range identity.Groups {
+		if containsString(rule.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the rule of a path lets the clients without credentials use a method
+func (config *webConfig) aclAllowsAnonymous(method, path string) bool {
+	rule := config.findACLRule(path)
+	return rule != nil && rule.Anonymous && rule.allowsMethod(method)
+}
+
+// Check the access of the client to the object path of the request, once the path is the one of
+// the stored key (homepage added, key normalized). Returns false if the access is denied and the
+// response has been written.
+func checkACL(c *gin.Context, path string) bool {
+	return checkKeyAccess(c, c.Request.Method, path)
+}
+
+// Check the access control rule of an object path for a method, when the request uses the
+// object with another method than its own (e.g. the source of a copy is read).
+// Returns false if the access is denied and the response has been written.
+func checkACLMethod(c *gin.Context, method, path string) bool {
+	rule := configOf(c).findACLRule(path)
+	if rule == nil {
+		return true
+	}
+	if !rule.allowsMethod(method) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Method "+method+" not allowed on '"+path+"'", "")
+		return false
+	}
+	if rule.Anonymous {
+		return true
+	}
+	identity, ok := c.Get(ctxAuthIdentity)
+	if !ok {
+		writeUnauthorized(c)
+		return false
+	}
+	if !rule.allowsIdentity(identity.(*authIdentity)) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Access denied to '"+path+"'", "")
+		return false
+	}
+	return true
+}
+
+// Check if the OIDC permissions and the access control rule of an object path let the client
+// use a method, without writing a response, e.g. for each key of a batch
+func aclAllows(c *gin.Context, method, path string) bool {
+	identity, _ := c.Get(ctxAuthIdentity)
+	id, _ := identity.(*authIdentity)
+	return configOf(c).aclAllowsIdentity(id, method, path)
+}
+
+// Check if the OIDC permissions and the access control rule of an object path let a client
+// (nil if anonymous) use a method
+func (config *webConfig) aclAllowsIdentity(identity *authIdentity, method, path string) bool {
+	if identity != nil && identity.OIDC && !config.Auth.OIDC.allows(identity.Groups, method, path) {
+		return false
+	}
+	rule := config.findACLRule(path)
+	if rule == nil || (rule.allowsMethod(method) && rule.Anonymous) {
+		return true
+	}
+	return identity != nil && rule.allowsMethod(method) && rule.allowsIdentity(identity)
+}
+
+// Check the access of the client to an object path: the OIDC permissions, checked on the request
+// path only by the auth middleware, and the access control rules. The path must have no '.', '..'
+// or empty segment, so that the rules are matched on the exact key sent to S3.
+// Returns false if the access is denied and the response has been written.
+func checkKeyAccess(c *gin.Context, method, path string) bool {
+	if err := checkPathSegments(path); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidPath", "Invalid path: "+err.Error(), "")
+		return false
+	}
+	if identity, ok := c.Get(ctxAuthIdentity); ok {
+		if id := identity.(*authIdentity); id.OIDC && !configOf(c).Auth.OIDC.allows(id.Groups, method, path) {
+			writeError(c, http.StatusForbidden, "AccessDenied", "Access denied to '"+path+"'", "")
+			return false
+		}
+	}
+	return checkACLMethod(c, method, path)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestACLAccess(t *testing.T) {
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket",
+		Auth: authConfig{Tokens: []authToken{{Name: "alice", Token: "alice-token"}, {Name: "bob", Token: "bob-token"}}},
+		ACL: []aclRule{
+			{Pattern: "public/**", Methods: []string{"GET"}, Anonymous: true},
+			{Pattern: "private/**", Users: []string{"alice"}},
+			{Pattern: "readonly/**", Methods: []string{"GET"}},
+		},
+	})
+	for _, key := range []string{"public/a.txt", "private/a.txt", "readonly/a.txt"} {
+		fake.put("bucket/"+key, testContent)
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		status int
+	}{
+		{"anonymous read of a public key", http.MethodGet, "/public/a.txt", nil, http.StatusOK},
+		{"anonymous head of a public key", http.MethodHead, "/public/a.txt", nil, http.StatusOK},
+		{"anonymous write of a public key", http.MethodDelete, "/public/a.txt", nil, http.StatusUnauthorized},
+		{"anonymous read of a private key", http.MethodGet, "/private/a.txt", nil, http.StatusUnauthorized},
+		{"other user", http.MethodGet, "/private/a.txt", bearer("bob-token"), http.StatusForbidden},
+		{"allowed user", http.MethodGet, "/private/a.txt", bearer("alice-token"), http.StatusOK},
+		{"method denied", http.MethodDelete, "/readonly/a.txt", bearer("alice-token"), http.StatusForbidden},
+		{"dot segment", http.MethodGet, "/public/../private/a.txt", bearer("bob-token"), http.StatusBadRequest},
+		{"empty segment", http.MethodGet, "/private//a.txt", bearer("bob-token"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(router, tt.method, tt.path, tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+	if _, ok := fake.get("bucket/readonly/a.txt"); !ok {
+		t.Errorf("readonly/a.txt deleted, want the delete denied")
+	}
+}
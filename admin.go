@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Admin listener config type. With a port, the admin endpoints leave the public port for their own
+// listener, protected by its own credentials.
+type adminConfig struct {
+	// Port of the admin listener, e.g. reachable from the private network only
+	Port string `json:"port" yaml:"port" toml:"port"`
+	// Basic authentication users of the admin endpoints
+	Users []authUser `json:"users" yaml:"users" toml:"users"`
+	// Bearer tokens of the admin endpoints
+	Tokens []authToken `json:"tokens" yaml:"tokens" toml:"tokens"`
+}
+
+// Check if the admin listener is enabled
+func (cfg adminConfig) enabled() bool {
+	return cfg.Port != ""
+}
+
+// Authentication of the admin endpoints, the users and tokens of the public port are not accepted
+func (cfg adminConfig) auth() authConfig {
+	return authConfig{Realm: "S3WebServer admin", Users: cfg.Users, Tokens: cfg.Tokens}
+}
+
+// Check the admin listener values
+func (cfg *adminConfig) validate(config *webConfig) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	if cfg.Port == config.Port {
+		return fmt.Errorf("admin port must differ from port")
+	}
+	if len(cfg.Users) == 0 && len(cfg.Tokens) == 0 {
+		return fmt.Errorf("admin needs users or tokens")
+	}
+	auth := cfg.auth()
+	return auth.validate()
+}
+
+// Authenticate the requests of the admin listener with the admin credentials, the probes excepted
+func adminAuthMiddleware(c *gin.Context) {
+	cfg := configOf(c).Admin.auth()
+	if identity := cfg.authenticate(c.Request); identity != nil {
+		c.Set(gin.AuthUserKey, identity.Name)
+		c.Set(ctxAuthIdentity, identity)
+		return
+	}
+	if isHealthPath(strings.TrimPrefix(c.Request.URL.Path, "/")) {
+		return
+	}
+	writeAuthChallenge(c, cfg)
+	c.Abort()
+}
+
+// Routes of the admin listener, besides the admin routes
+func adminPortRoutes() []routeDef {
+	return []routeDef{
+		{Method: "GET", Path: "/" + livenessPath, Tag: "health", Summary: "Liveness probe", Handler: serveLiveness,
+			Responses: map[string]string{"200": "Server is alive"}},
+		{Method: "GET", Path: "/" + readinessPath, Tag: "health", Summary: "Readiness probe, checks that the buckets are reachable", Handler: serveReadiness,
+			Responses: map[string]string{"200": "Server is ready", "503": "S3 is not reachable"}},
+		{Method: "GET", Path: "/metrics", Tag: "admin", Summary: "Metrics in the Prometheus text format", Handler: serveMetrics,
+			Responses: map[string]string{"200": "Metrics"}},
+		{Method: "GET", Path: "/debug/pprof/*profile", Tag: "admin", Summary: "Go runtime profiles", Handler: servePprof,
+			Responses: map[string]string{"200": "Profile"}},
+		{Method: "POST", Path: "/debug/pprof/*profile", Tag: "admin", Summary: "Go symbol lookup", Handler: servePprof,
+			Responses: map[string]string{"200": "Symbols"}},
+		{Method: "GET", Path: "/debug/vars", Tag: "admin", Summary: "Expvar variables", Handler: serveExpvar,
+			Responses: map[string]string{"200": "Variables"}},
+	}
+}
+
+// Create the router of the admin listener
+func newAdminRouter(accessLog gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.ForwardedByClientIP = false
+	router.Use(configMiddleware, clientIPMiddleware, requestIDMiddleware, accessLog, gin.Recovery(), adminAuthMiddleware)
+	for _, route := range append(adminPortRoutes(), adminRoutes()...) {
+		router.Handle(route.Method, route.Path, route.Handler)
+	}
+	router.NoRoute(func(c *gin.Context) {
+		writeError(c, http.StatusNotFound, "NotFound", "No admin endpoint '"+c.Request.URL.Path+"'", "")
+	})
+	return router
+}
+
+// Start the admin listener. It has no TLS and no connection limit, only the header and idle timeouts
+// of the server so that the long profiles are not cut.
+func startAdmin(router http.Handler, cfg adminConfig, limits serverConfig) *http.Server {
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           router,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout.Duration,
+		IdleTimeout:       limits.IdleTimeout.Duration,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("admin listen: %s\n", err)
+		}
+	}()
+	log.Infof("Serving the admin endpoints on port %s", cfg.Port)
+	return srv
+}
+
+// Answer the admin paths of the public port while they are served by the admin listener, or not
+// served at all without authentication, so that they are never read from the bucket
+func serveAdminElsewhere(c *gin.Context) {
+	writeError(c, http.StatusNotFound, "NotFound", "No endpoint '"+c.Request.URL.Path+"'", "")
+}
+
+// Serve the net/http/pprof handlers, under /debug/pprof/ or /_admin/debug/pprof/
+func servePprof(c *gin.Context) {
+	switch profile := strings.TrimPrefix(c.Param("profile"), "/"); profile {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(profile).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Configuration sections set up at startup only, their changes need a restart
+var restartSections = []string{
+	"port", "tls", "protocols", "server", "admin", "sftp",
+	"awsRegion", "endpoint", "credentials", "forcePathStyle", "disableSSL", "useDualStack", "useFIPSEndpoint", "useArnRegion",
+	"requesterPays", "retry", "upload", "memoryCache", "diskCache", "rangeCache", "cacheEvents", "circuitBreaker", "replicas",
+	"tracing", "accessLog", "ipFilter", "rateLimit", "cors", "compression", "plugins", "shutdown", "chaos",
+}
+
+// Path of the configuration file, read again on reload
+var loadedConfigPath string
+
+// Serializes the reloads
+var reloadMu sync.Mutex
+
+// Reload report type
+type reloadReport struct {
+	// Changed sections now in use
+	Applied []string `json:"applied"`
+	// Changed sections left unchanged until the next restart
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Check if the change of a section needs a restart: the authentication and the header rules middlewares
+// are only added at startup, when enabled
+func needsRestart(section string, current, next *webConfig) bool {
+	switch section {
+	case "auth":
+		return current.Auth.enabled() != next.Auth.enabled()
+	case "headers":
+		return (len(current.Headers) > 0) != (len(next.Headers) > 0)
+	}
+	return containsString(restartSections, section)
+}
+
+// Read the configuration file, the environment and the flags again and use the new configuration.
+// The sections needing a restart keep their current values.
+func reloadConfig() (*reloadReport, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	next, err := readConfig(loadedConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	current := currentConfig.Load()
+	report := &reloadReport{Applied: []string{}, RestartRequired: []string{}}
+	currentValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < currentValue.NumField(); i++ {
+		section := strings.Split(currentValue.Type().Field(i).Tag.Get("json"), ",")[0]
+		if reflect.DeepEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+		if needsRestart(section, current, next) {
+			nextValue.Field(i).Set(currentValue.Field(i))
+			report.RestartRequired = append(report.RestartRequired, section)
+		} else {
+			report.Applied = append(report.Applied, section)
+		}
+	}
+	currentConfig.Store(next)
+	return report, nil
+}
+
+// Serve a configuration reload
+func serveReload(c *gin.Context) {
+	report, err := reloadConfig()
+	if err != nil {
+		requestLog(c).Warnf("Configuration reload failed: %v", err)
+		writeError(c, http.StatusBadRequest, "InvalidConfiguration", err.Error(), "")
+		return
+	}
+	requestLog(c).Infof("Configuration reloaded, applied %v, restart required for %v", report.Applied, report.RestartRequired)
+	c.JSON(http.StatusOK, report)
+}
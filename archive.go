@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Default most objects of an archive
+const defaultArchiveMaxObjects = 10000
+
+// Archives config type
+type archiveConfig struct {
+	// Serve the objects under a prefix as an archive on GET <prefix>/?format=zip or tar.gz
+	Download bool `json:"download" yaml:"download" toml:"download"`
+	// Unpack the .zip, .tar.gz and .tgz uploads under their directory on PUT or POST with ?extract=true
+	Extract bool `json:"extract" yaml:"extract" toml:"extract"`
+	// Most objects of an archive, downloaded or extracted
+	MaxObjects int `json:"maxObjects" yaml:"maxObjects" toml:"maxObjects"`
+	// Most bytes of the objects of an archive, uncompressed, default is no limit
+	MaxSize int64 `json:"maxSize" yaml:"maxSize" toml:"maxSize"`
+}
+
+// Set the archive defaults and check the limits
+func (cfg *archiveConfig) validate() error {
+	if cfg.MaxObjects == 0 {
+		cfg.MaxObjects = defaultArchiveMaxObjects
+	}
+	if cfg.MaxObjects < 0 || cfg.MaxSize < 0 {
+		return fmt.Errorf("invalid archives limits, maxObjects and maxSize cannot be negative")
+	}
+	return nil
+}
+
+// Archive formats of the prefix downloads
+var archiveFormats = map[string]string{"zip": "zip", "tar.gz": "tar.gz", "tgz": "tar.gz"}
+
+// Get the archive format asked by a GET of a prefix, "" if none
+func archiveFormat(c *gin.Context) string {
+	if !configOf(c).Archives.Download {
+		return ""
+	}
+	return archiveFormats[strings.ToLower(c.Query("format"))]
+}
+
+// Object of an archive
+type archiveEntry struct {
+	// Name in the archive, relative to the prefix
+	name         string
+	key          string
+	lastModified time.Time
+}
+
+// Serve the objects under a prefix as a zip or tar.gz archive. The archive is written while the
+// objects are read from S3, so nothing is buffered but the encrypted objects, decrypted as a whole.
+// The objects denied by the OIDC permissions or the access control rules are left out.
+func serveArchive(c *gin.Context, prefix, format string) {
+	cfg := configOf(c).Archives
+	bucket, keyPrefix := configOf(c).resolveObject(c.Request.Host, prefix)
+	var entries []archiveEntry
+	var size int64
+	tooLarge := false
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(keyPrefix)}
+	err := listObjectsPages(c.Request.Context(), input, func(page *s3.ListObjectsV2Output) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			name := strings.TrimPrefix(key, keyPrefix)
+			if name == "" || strings.HasSuffix(name, "/") || configOf(c).isHiddenKey(prefix+name) || !aclAllows(c, http.MethodGet, prefix+name) {
+				continue
+			}
+			entries = append(entries, archiveEntry{name: name, key: key, lastModified: aws.TimeValue(object.LastModified)})
+			size += aws.Int64Value(object.Size)
+			if len(entries) > cfg.MaxObjects || (cfg.MaxSize > 0 && size > cfg.MaxSize) {
+				tooLarge = true
+				return false
+			}
+		}
+		return true
+	})
+	if handleHTTPException(c, prefix, err) != nil {
+		return
+	}
+	if tooLarge {
+		writeError(c, http.StatusRequestEntityTooLarge, "ArchiveTooLarge", "Prefix '"+prefix+"' is over the archive limits", "")
+		return
+	}
+
+	name := path.Base(prefix)
+	if prefix == "" {
+		name = bucket
+	}
+	contentType := "application/zip"
+	if format == "tar.gz" {
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	c.Status(http.StatusOK)
+	if format == "zip" {
+		err = writeZipArchive(c.Request.Context(), c.Writer, bucket, entries)
+	} else {
+		err = writeTarGzArchive(c.Request.Context(), c.Writer, bucket, entries)
+	}
+	// The status is sent, the client gets a truncated archive
+	if err != nil {
+		requestLog(c).Warnf("Archive of %s interrupted: %v", prefix, err)
+		return
+	}
+	requestLog(c).Infof("Archived %d objects of %s as %s", len(entries), prefix, format)
+}
+
+// Open an object of an archive, returns its content and its size
+func openArchiveEntry(ctx context.Context, bucket, key string) (io.ReadCloser, int64, error) {
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, 0, err
+	}
+	if !isEncrypted(resp.Metadata) {
+		return resp.Body, aws.Int64Value(resp.ContentLength), nil
+	}
+	defer resp.Body.Close()
+	plaintext, err := decryptObject(ctx, resp.Metadata, resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decrypt %s: %v", key, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(plaintext)), int64(len(plaintext)), nil
+}
+
+// Copy an object into an archive entry
+func copyArchiveEntry(ctx context.Context, bucket, key string, create func(size int64) (io.Writer, error)) error {
+	getCtx, cancel := s3Context(ctx, configFrom(ctx).Timeouts.Get)
+	defer cancel()
+	body, size, err := openArchiveEntry(getCtx, bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	w, err := create(size)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, body)
+	usage.addBytesOut(n)
+	return err
+}
+
+// Write the objects as a zip archive
+func writeZipArchive(ctx context.Context, w io.Writer, bucket string, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		err := copyArchiveEntry(ctx, bucket, entry.key, func(int64) (io.Writer, error) {
+			return zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: entry.lastModified})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Write the objects as a gzipped tar archive
+func writeTarGzArchive(ctx context.Context, w io.Writer, bucket string, entries []archiveEntry) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		err := copyArchiveEntry(ctx, bucket, entry.key, func(size int64) (io.Writer, error) {
+			header := &tar.Header{Name: entry.name, Mode: 0644, Size: size, ModTime: entry.lastModified, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+			return tw, tw.WriteHeader(header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
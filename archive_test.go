@@ -0,0 +1,39 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"sort"
+	"testing"
+)
+
+func TestArchiveAccess(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket",
+		Archives: archiveConfig{Download: true},
+		Auth: authConfig{OIDC: issuer.config(
+			oidcPermission{Prefix: "docs/", Read: []string{"staff"}},
+			oidcPermission{Prefix: "docs/secret/", Read: []string{"admin"}},
+		)},
+	})
+	for _, key := range []string{"docs/a.txt", "docs/sub/b.txt", "docs/secret/c.txt"} {
+		fake.put("bucket/"+key, testContent)
+	}
+	w := serveTestRequest(router, http.MethodGet, "/docs/?format=zip", bearer(issuer.token(t, "staff")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "sub/b.txt" {
+		t.Errorf("archive entries = %v, want [a.txt sub/b.txt]", names)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Context key of the authenticated client
+const ctxAuthIdentity = "authIdentity"
+
+// Authentication config type, disabled without users and tokens
+type authConfig struct {
+	// Realm of the Basic authentication challenge
+	Realm string `json:"realm" yaml:"realm" toml:"realm"`
+	// Basic authentication users
+	Users []authUser `json:"users" yaml:"users" toml:"users"`
+	// Static bearer tokens
+	Tokens []authToken `json:"tokens" yaml:"tokens" toml:"tokens"`
+	// Anonymous access by method, the first rule matching the method applies
+	Rules []authRule `json:"rules" yaml:"rules" toml:"rules"`
+	// JWTs of an OIDC provider accepted as bearer tokens
+	OIDC oidcConfig `json:"oidc" yaml:"oidc" toml:"oidc"`
+}
+
+// Basic authentication user type
+type authUser struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// Bcrypt hash of the password (e.g. htpasswd -nbB)
+	PasswordHash string `json:"passwordHash" yaml:"passwordHash" toml:"passwordHash"`
+}
+
+// Bearer token type, the name identifies the client
+type authToken struct {
+	Name  string `json:"name" yaml:"name" toml:"name"`
+	Token string `json:"token" yaml:"token" toml:"token"`
+}
+
+// Authentication rule type
+type authRule struct {
+	Methods   []string `json:"methods" yaml:"methods" toml:"methods"`
+	Anonymous bool     `json:"anonymous" yaml:"anonymous" toml:"anonymous"`
+}
+
+// Check if the authentication is enabled
+func (cfg authConfig) enabled() bool {
+	return len(cfg.Users) > 0 || len(cfg.Tokens) > 0 || cfg.OIDC.enabled()
+}
+
+// Set the authentication defaults and check the values
+func (cfg *authConfig) validate() error {
+	if cfg.Realm == "" {
+		cfg.Realm = "S3WebServer"
+	}
+	for _, user := range cfg.Users {
+		if user.Name == "" || strings.Contains(user.Name, ":") {
+			return fmt.Errorf("auth user name %q is invalid", user.Name)
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return fmt.Errorf("auth user %s needs a bcrypt passwordHash: %v", user.Name, err)
+		}
+	}
+	for i, token := range cfg.Tokens {
+		if token.Token == "" {
+			return fmt.Errorf("auth token %d is empty", i+1)
+		}
+	}
+	for i := range cfg.Rules {
+		for j, method := range cfg.Rules[i].Methods {
+			cfg.Rules[i].Methods[j] = strings.ToUpper(method)
+		}
+	}
+	return cfg.OIDC.validate()
+}
+
+// Check if a method can be used anonymously, the admin endpoints always need authentication
+func (cfg authConfig) allowsAnonymous(method, path string) bool {
+	if strings.HasPrefix(path, "_admin/") {
+		return false
+	}
+	for _, rule := range cfg.Rules {
+		for _, m := range rule.Methods {
+			if m == method || m == "*" {
+				return rule.Anonymous
+			}
+		}
+	}
+	return false
+}
+
+// Authenticated client type
+type authIdentity struct {
+	Name string
+	// Groups of an OIDC client, checked against the OIDC permissions
+	Groups []string
+	OIDC   bool
+}
+
+// Find the client authenticated by the request, nil if none
+func (cfg authConfig) authenticate(r *http.Request) *authIdentity {
+	if name, password, ok := r.BasicAuth(); ok {
+		for _, user := range cfg.Users {
+			if user.Name == name && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil {
+				return &authIdentity{Name: name}
+			}
+		}
+		return nil
+	}
+	header := r.Header.Get("Authorization")
+	if len(header) <= 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil
+	}
+	for _, token := range cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(header[7:]), []byte(token.Token)) == 1 {
+			return &authIdentity{Name: token.Name}
+		}
+	}
+	if !cfg.OIDC.enabled() {
+		return nil
+	}
+	claims, err := cfg.OIDC.verify(header[7:])
+	if err != nil {
+		log.Debugf("Bearer token rejected: %v", err)
+		return nil
+	}
+	name, _ := claims[cfg.OIDC.NameClaim].(string)
+	return &authIdentity{Name: name, Groups: claimValues(claims[cfg.OIDC.GroupsClaim]), OIDC: true}
+}
+
+// Authenticate the requests. The authenticated client name is set as the gin user.
+// The reads of the well-known paths, the probes and the share links never need authentication.
+func authMiddleware(c *gin.Context) {
+	cfg := configOf(c).Auth
+	path := strings.TrimPrefix(c.Request.URL.Path, "/")
+	if identity := cfg.authenticate(c.Request); identity != nil {
+		if identity.OIDC && !cfg.OIDC.allows(identity.Groups, c.Request.Method, path) {
+			writeError(c, http.StatusForbidden, "AccessDenied", "Access denied to '"+path+"'", "")
+			c.Abort()
+			return
+		}
+		c.Set(gin.AuthUserKey, identity.Name)
+		c.Set(ctxAuthIdentity, identity)
+		return
+	}
+	// Rejected credentials are not downgraded to an anonymous access
+	anonymous := c.GetHeader("Authorization") == "" &&
+		(cfg.allowsAnonymous(c.Request.Method, path) || (!isReservedPath(path) && configOf(c).aclAllowsAnonymous(c.Request.Method, path)))
+	if (isWellKnownPath(path) && isWellKnownRead(c.Request.Method)) || isHealthPath(path) || c.FullPath() == "/s/:token" || anonymous {
+		return
+	}
+	writeUnauthorized(c)
+	c.Abort()
+}
+
+// Write the 401 error asking for the credentials
+func writeUnauthorized(c *gin.Context) {
+	writeAuthChallenge(c, configOf(c).Auth)
+}
+
+// Write the 401 error asking for the credentials of an authentication config
+func writeAuthChallenge(c *gin.Context, cfg authConfig) {
+	if len(cfg.Users) > 0 {
+		c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", cfg.Realm))
+	}
+	if len(cfg.Tokens) > 0 || cfg.OIDC.enabled() {
+		c.Writer.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", cfg.Realm))
+	}
+	writeError(c, http.StatusUnauthorized, "Unauthorized", "Authentication required", "")
+}
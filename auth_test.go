@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuthentication(t *testing.T) {
+	router, _ := newTestServer(t, &webConfig{S3bucket: "bucket", Auth: authConfig{
+		Tokens: []authToken{{Name: "ci", Token: "secret"}},
+		Rules:  []authRule{{Methods: []string{"GET", "HEAD"}, Anonymous: true}},
+	}})
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		status int
+	}{
+		{"anonymous read", http.MethodGet, nil, http.StatusOK},
+		{"anonymous write", http.MethodDelete, nil, http.StatusUnauthorized},
+		{"token write", http.MethodDelete, bearer("secret"), http.StatusNoContent},
+		{"rejected token not anonymous", http.MethodGet, bearer("wrong"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(router, tt.method, "/hello.txt", tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestOIDCPermissions(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket", Auth: authConfig{OIDC: issuer.config(
+		oidcPermission{Prefix: "", Read: []string{"*"}},
+		oidcPermission{Prefix: "docs/", Read: []string{"staff"}, Write: []string{"staff"}},
+	)}})
+	fake.put("bucket/docs/a.txt", testContent)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"read allowed to all", http.MethodGet, "/hello.txt", issuer.token(t), http.StatusOK},
+		{"write denied", http.MethodDelete, "/hello.txt", issuer.token(t), http.StatusForbidden},
+		{"read denied to other groups", http.MethodGet, "/docs/a.txt", issuer.token(t, "guest"), http.StatusForbidden},
+		{"read allowed to the group", http.MethodGet, "/docs/a.txt", issuer.token(t, "staff"), http.StatusOK},
+		{"write allowed to the group", http.MethodDelete, "/docs/a.txt", issuer.token(t, "staff"), http.StatusNoContent},
+		{"token of another issuer", http.MethodGet, "/hello.txt", newTestIssuer(t).token(t), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(router, tt.method, tt.path, bearer(tt.token))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWellKnownAuthentication(t *testing.T) {
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket", Auth: authConfig{Tokens: []authToken{{Name: "ci", Token: "secret"}}}})
+	fake.put("bucket/.well-known/security.txt", testContent)
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		status int
+	}{
+		{"anonymous get", http.MethodGet, nil, http.StatusOK},
+		{"anonymous head", http.MethodHead, nil, http.StatusOK},
+		{"anonymous put", http.MethodPut, nil, http.StatusUnauthorized},
+		{"anonymous delete", http.MethodDelete, nil, http.StatusUnauthorized},
+		{"token delete", http.MethodDelete, bearer("secret"), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestRequest(router, tt.method, "/.well-known/security.txt", tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+	if _, ok := fake.get("bucket/.well-known/security.txt"); ok {
+		t.Errorf("security.txt not deleted by the authenticated client")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Most keys deleted by a DeleteObjects call
+const maxDeleteObjects = 1000
+
+// Key which could not be deleted
+type deleteError struct {
+	Key     string `json:"key"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Prefix delete report type
+type deletePrefixReport struct {
+	Prefix string `json:"prefix"`
+	DryRun bool   `json:"dryRun"`
+	// Deleted keys, or keys which would be deleted in dry-run mode
+	Deleted []string      `json:"deleted"`
+	Errors  []deleteError `json:"errors"`
+}
+
+// Serve a DELETE request of a prefix (path ending with /): all the objects under the prefix are
+// deleted, by batches of 1000 keys. With ?dryRun=true the keys are only listed.
+// The keys denied by the access control rules are kept and reported in the errors.
+func serveDeletePrefix(c *gin.Context, path string) {
+	if path == "" {
+		writeError(c, http.StatusBadRequest, "BadRequest", "The root of the bucket cannot be deleted", "")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	bucket, prefix := configOf(c).resolveObject(c.Request.Host, path)
+	if !dryRun && !checkSoftDelete(c, bucket, prefix) {
+		return
+	}
+	report := deletePrefixReport{Prefix: path, DryRun: dryRun, Deleted: []string{}, Errors: []deleteError{}}
+	var deleteErr error
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix), MaxKeys: aws.Int64(maxDeleteObjects)}
+	err := listObjectsPages(c.Request.Context(), input, func(page *s3.ListObjectsV2Output) bool {
+		// Paths of the keys of the page, as seen by the client
+		paths := map[string]string{}
+		var objects []*s3.ObjectIdentifier
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			objectPath := path + strings.TrimPrefix(key, prefix)
+			if configOf(c).isHiddenKey(objectPath) {
+				continue
+			}
+			if !aclAllows(c, http.MethodDelete, objectPath) {
+				report.Errors = append(report.Errors, deleteError{Key: objectPath, Code: "AccessDenied", Message: "Access denied"})
+				continue
+			}
+			paths[key] = objectPath
+			objects = append(objects, &s3.ObjectIdentifier{Key: object.Key})
+		}
+		if dryRun {
+			for _, object := range objects {
+				report.Deleted = append(report.Deleted, paths[aws.StringValue(object.Key)])
+			}
+			return true
+		}
+		if len(objects) == 0 {
+			return true
+		}
+		ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Delete)
+		defer cancel()
+		resp, err := s3Session.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{Bucket: aws.String(bucket), Delete: &s3.Delete{Objects: objects}})
+		if err != nil {
+			deleteErr = err
+			return false
+		}
+		for _, deleted := range resp.Deleted {
+			key := aws.StringValue(deleted.Key)
+			caches.invalidate(bucket, key)
+			report.Deleted = append(report.Deleted, paths[key])
+		}
+		for _, e := range resp.Errors {
+			report.Errors = append(report.Errors, deleteError{Key: paths[aws.StringValue(e.Key)], Code: aws.StringValue(e.Code), Message: aws.StringValue(e.Message)})
+		}
+		return true
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	// The keys deleted before a failure are gone, the report is only lost if nothing was deleted
+	if err != nil && len(report.Deleted) == 0 {
+		handleHTTPException(c, path, err)
+		return
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, deleteError{Key: path, Code: errorCode(err), Message: err.Error()})
+	}
+	if dryRun {
+		requestLog(c).Infof("Dry-run delete of %s: %d objects", path, len(report.Deleted))
+	} else {
+		requestLog(c).Infof("Deleted %d objects under %s, %d errors", len(report.Deleted), path, len(report.Errors))
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDeletePrefixAccess(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket",
+		Auth: authConfig{OIDC: issuer.config(
+			oidcPermission{Prefix: "docs/", Read: []string{"staff"}, Write: []string{"staff"}},
+			oidcPermission{Prefix: "docs/secret/", Read: []string{"admin"}, Write: []string{"admin"}},
+		)},
+		ACL: []aclRule{{Pattern: "docs/locked/**", Methods: []string{"GET", "HEAD"}}},
+	})
+	for _, key := range []string{"docs/a.txt", "docs/secret/b.txt", "docs/locked/c.txt"} {
+		fake.put("bucket/"+key, testContent)
+	}
+	w := serveTestRequest(router, http.MethodDelete, "/docs/", bearer(issuer.token(t, "staff")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var report deletePrefixReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %s: %v", w.Body.String(), err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0] != "docs/a.txt" {
+		t.Errorf("deleted = %v, want [docs/a.txt]", report.Deleted)
+	}
+	for _, key := range []string{"docs/secret/b.txt", "docs/locked/c.txt"} {
+		if _, ok := fake.get("bucket/" + key); !ok {
+			t.Errorf("%s deleted, want it denied", key)
+		}
+	}
+	if len(report.Errors) != 2 {
+		t.Errorf("errors = %v, want the 2 denied keys", report.Errors)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Bucket mapping type, routes the requests of a host and/or a path prefix to a bucket
+type bucketMapping struct {
+	// Request host, "*.example.com" matches all the sub-domains
+	Host string `json:"host" yaml:"host" toml:"host"`
+	// Request path prefix (e.g. "/media/"), removed from the object key
+	PathPrefix string `json:"pathPrefix" yaml:"pathPrefix" toml:"pathPrefix"`
+	Bucket     string `json:"bucket" yaml:"bucket" toml:"bucket"`
+}
+
+// Check the bucket mappings
+func validateBucketMappings(mappings []bucketMapping) error {
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Bucket == "" || (m.Host == "" && m.PathPrefix == "") {
+			return fmt.Errorf("bucket mapping %d needs a bucket and a host or a pathPrefix", i+1)
+		}
+		m.Host = strings.ToLower(m.Host)
+		m.PathPrefix = strings.TrimPrefix(m.PathPrefix, "/")
+	}
+	return nil
+}
+
+// Check if a mapping host matches the request host
+func (m bucketMapping) matchesHost(host string) bool {
+	if m.Host == "" {
+		return true
+	}
+	if strings.HasPrefix(m.Host, "*.") {
+		return strings.HasSuffix(host, m.Host[1:])
+	}
+	return host == m.Host
+}
+
+// Resolve the bucket and the key of a mapped path
+func (m bucketMapping) resolve(path string) (bucket, key string) {
+	return m.Bucket, strings.TrimPrefix(path, m.PathPrefix)
+}
+
+// Find the bucket mapping of a request, the host mappings come first then the longest path prefix, nil if none
+func (config *webConfig) findBucketMapping(requestHost, path string) *bucketMapping {
+	host := strings.ToLower(requestHost)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var found *bucketMapping
+	mappings := config.Buckets
+	for i := range mappings {
+		m := &mappings[i]
+		if !m.matchesHost(host) || !strings.HasPrefix(path, m.PathPrefix) {
+			continue
+		}
+		if found == nil || (m.Host != "" && found.Host == "") ||
+			((m.Host != "") == (found.Host != "") && len(m.PathPrefix) > len(found.PathPrefix)) {
+			found = m
+		}
+	}
+	return found
+}
+
+// Get all the buckets of the configuration, without duplicates
+func configuredBuckets(config *webConfig) []string {
+	buckets := []string{config.S3bucket}
+	candidates := []string{config.WellKnown.Bucket}
+	for _, m := range config.Buckets {
+		candidates = append(candidates, m.Bucket)
+	}
+	for _, bucket := range candidates {
+		known := bucket == ""
+		for _, b := range buckets {
+			known = known || b == bucket
+		}
+		if !known {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
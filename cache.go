@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Extension of the cached object files
+const cacheFileExtension = ".cache"
+
+// Object cache config type
+type cacheConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Directory of the cached objects, disk cache only
+	Dir string `json:"dir" yaml:"dir" toml:"dir"`
+	// Maximum size (in bytes) of the cached objects, the least recently used are evicted first
+	MaxSize int64 `json:"maxSize" yaml:"maxSize" toml:"maxSize"`
+	// Maximum size (in bytes) of a cached object
+	MaxObjectSize int64 `json:"maxObjectSize" yaml:"maxObjectSize" toml:"maxObjectSize"`
+	// Delay during which a cached object is served without checking its ETag in S3
+	MaxAge duration `json:"maxAge" yaml:"maxAge" toml:"maxAge"`
+}
+
+// Set the cache defaults and check the values
+func (cfg *cacheConfig) validate(defaultMaxSize, defaultMaxObjectSize int64) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxSize
+	}
+	if cfg.MaxObjectSize <= 0 {
+		cfg.MaxObjectSize = defaultMaxObjectSize
+	}
+	if cfg.MaxObjectSize > cfg.MaxSize {
+		cfg.MaxObjectSize = cfg.MaxSize
+	}
+	cfg.MaxAge.Duration = cfg.MaxAge.orDefault(time.Minute)
+	return nil
+}
+
+// Cached object type, the content is in a file or in memory
+type cacheEntry struct {
+	id  string
+	key string
+	// Bucket and key of the object the content is read or derived from
+	bucket       string
+	source       string
+	file         string
+	data         []byte
+	etag         string
+	contentType  string
+	lastModified time.Time
+	size         int64
+	versionID    *string
+	checked      time.Time
+	element      *list.Element
+	// Stored content headers and user metadata
+	cacheControl       *string
+	contentDisposition *string
+	contentLanguage    *string
+	metadata           map[string]*string
+}
+
+// Get the response headers of a cached object, as for a GET from S3
+func (e *cacheEntry) headers() objectHeaders {
+	return objectHeaders{
+		contentType:        aws.String(e.contentType),
+		contentLength:      e.size,
+		lastModified:       e.lastModified,
+		etag:               e.etag,
+		versionID:          e.versionID,
+		cacheControl:       e.cacheControl,
+		contentDisposition: e.contentDisposition,
+		contentLanguage:    e.contentLanguage,
+		metadata:           e.metadata,
+	}
+}
+
+// Open the content of a cached object
+func (e *cacheEntry) open() (io.ReadSeeker, func(), error) {
+	if e.file == "" {
+		return bytes.NewReader(e.data), func() {}, nil
+	}
+	f, err := os.Open(e.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// Read-through cache of the objects on local disk or in memory, keyed by bucket, key and ETag
+type objectCache struct {
+	mu      sync.Mutex
+	cfg     cacheConfig
+	entries map[string]*cacheEntry
+	lru     *list.List
+	size    int64
+}
+
+// Object caches of the GET responses, the memory cache of the small objects before the disk cache
+type objectCaches []*objectCache
+
+// Enabled object caches
+var caches objectCaches
+
+// Create the memory cache
+func newMemoryCache(cfg cacheConfig) *objectCache {
+	cfg.Dir = ""
+	return &objectCache{cfg: cfg, entries: map[string]*cacheEntry{}, lru: list.New()}
+}
+
+// Create the disk cache, the files of a previous run are removed
+func newDiskCache(cfg cacheConfig) (*objectCache, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = filepath.Join(os.TempDir(), "s3webserver-cache")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+	stale, _ := filepath.Glob(filepath.Join(cfg.Dir, "*"+cacheFileExtension))
+	for _, file := range stale {
+		os.Remove(file)
+	}
+	return &objectCache{cfg: cfg, entries: map[string]*cacheEntry{}, lru: list.New()}, nil
+}
+
+// Identifier of an object in the cache
+func cacheID(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// Check if a GET request can use the caches: server side encrypted objects and customer keys are never cached
+func (oc objectCaches) cacheable(c *gin.Context, key string) bool {
+	return len(oc) > 0 && configOf(c).encryptionFor(key) == nil && c.GetHeader(sseCustomerAlgorithmHeader) == ""
+}
+
+// Get the cached entry of an object from the first cache holding it
+func (oc objectCaches) lookup(ctx context.Context, bucket, key string) *cacheEntry {
+	for _, cache := range oc {
+		if entry := cache.lookup(ctx, bucket, key); entry != nil {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Serve a cached object, conditional and range requests are answered from the cached content.
+// Returns false if the cached file is gone, the request is then served from S3.
+func (oc objectCaches) serve(c *gin.Context, entry *cacheEntry) bool {
+	content, done, err := entry.open()
+	if err != nil {
+		return false
+	}
+	defer done()
+	header := c.Writer.Header()
+	// The objects with a Content-Encoding are not cached, nothing to decode
+	entry.headers().set(c.Request, header, entry.key)
+	header.Set("X-Cache", "HIT")
+	http.ServeContent(c.Writer, c.Request, "", entry.lastModified, content)
+	return true
+}
+
+// Start caching a downloaded object in the first cache accepting its size, returns the body to copy
+// to the client and a function adding the object to the cache once the whole body is copied
+func (oc objectCaches) store(bucket, key string, resp *s3.GetObjectOutput) (io.Reader, func(n int64)) {
+	if resp.ContentRange != nil || resp.ContentEncoding != nil || resp.WebsiteRedirectLocation != nil || resp.ETag == nil {
+		return resp.Body, func(int64) {}
+	}
+	for _, cache := range oc {
+		if aws.Int64Value(resp.ContentLength) <= cache.cfg.MaxObjectSize {
+			return cache.store(bucket, key, resp)
+		}
+	}
+	return resp.Body, func(int64) {}
+}
+
+// Get a cached derived content (e.g. a transformed image) by its id, never revalidated as the id
+// holds the ETag of its source
+func (oc objectCaches) get(id string) *cacheEntry {
+	for _, cache := range oc {
+		cache.mu.Lock()
+		entry, ok := cache.entries[id]
+		if ok {
+			cache.lru.MoveToFront(entry.element)
+		}
+		cache.mu.Unlock()
+		if ok {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Add a derived content to the first cache accepting its size
+func (oc objectCaches) put(entry *cacheEntry, data []byte) {
+	entry.size = int64(len(data))
+	for _, cache := range oc {
+		if entry.size > cache.cfg.MaxObjectSize {
+			continue
+		}
+		if cache.cfg.Dir == "" {
+			entry.data = data
+		} else {
+			sum := sha256.Sum256([]byte(entry.id))
+			entry.file = filepath.Join(cache.cfg.Dir, hex.EncodeToString(sum[:])+cacheFileExtension)
+			if err := ioutil.WriteFile(entry.file, data, 0600); err != nil {
+				log.Warnf("Unable to cache %s: %v", entry.id, err)
+				return
+			}
+		}
+		cache.add(entry)
+		return
+	}
+}
+
+// Remove an object from the caches, after a PUT or a DELETE
+func (oc objectCaches) invalidate(bucket, key string) {
+	for _, cache := range oc {
+		cache.invalidate(bucket, key)
+	}
+	chunkCache.invalidate(bucket, key)
+}
+
+// Remove the cached objects matching and the contents derived from them, returns the number of
+// entries removed from each cache
+func (oc objectCaches) purge(match func(bucket, key string) bool) map[string]int {
+	purged := map[string]int{}
+	for _, cache := range oc {
+		purged[cache.name()] = 0
+	}
+	for _, cache := range oc {
+		cache.mu.Lock()
+		for _, entry := range cache.entries {
+			if match(entry.bucket, entry.source) {
+				cache.removeLocked(entry, true)
+				purged[cache.name()]++
+			}
+		}
+		cache.mu.Unlock()
+	}
+	chunkCache.purge(match)
+	return purged
+}
+
+// Get the cached entry of an object, revalidated with its ETag in S3 once older than maxAge
+func (cache *objectCache) lookup(ctx context.Context, bucket, key string) *cacheEntry {
+	cache.mu.Lock()
+	entry, ok := cache.entries[cacheID(bucket, key)]
+	if ok {
+		cache.lru.MoveToFront(entry.element)
+	}
+	cache.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if time.Since(entry.checked) < cache.cfg.MaxAge.Duration {
+		return entry
+	}
+	ctx, cancel := s3Context(ctx, configFrom(ctx).Timeouts.Head)
+	defer cancel()
+	resp, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil || aws.StringValue(resp.ETag) != entry.etag {
+		cache.invalidate(bucket, key)
+		return nil
+	}
+	cache.mu.Lock()
+	entry.checked = time.Now()
+	cache.mu.Unlock()
+	return entry
+}
+
+// Copy a downloaded object in the cache while it is sent to the client
+func (cache *objectCache) store(bucket, key string, resp *s3.GetObjectOutput) (io.Reader, func(n int64)) {
+	size := aws.Int64Value(resp.ContentLength)
+	entry := &cacheEntry{
+		id:           cacheID(bucket, key),
+		key:          key,
+		bucket:       bucket,
+		source:       key,
+		etag:         aws.StringValue(resp.ETag),
+		contentType:  currentConfig.Load().objectContentType(key, resp.ContentType),
+		lastModified: aws.TimeValue(resp.LastModified),
+		size:         size,
+		versionID:    resp.VersionId,
+		// Stored content headers and user metadata
+		cacheControl:       resp.CacheControl,
+		contentDisposition: resp.ContentDisposition,
+		contentLanguage:    resp.ContentLanguage,
+		metadata:           resp.Metadata,
+	}
+	if cache.cfg.Dir == "" {
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		return io.TeeReader(resp.Body, buf), func(n int64) {
+			if n == size {
+				entry.data = buf.Bytes()
+				cache.add(entry)
+			}
+		}
+	}
+	tmp, err := ioutil.TempFile(cache.cfg.Dir, "download-")
+	if err != nil {
+		log.Warnf("Unable to cache %s: %v", key, err)
+		return resp.Body, func(int64) {}
+	}
+	return io.TeeReader(resp.Body, tmp), func(n int64) {
+		tmp.Close()
+		if n != size {
+			os.Remove(tmp.Name())
+			return
+		}
+		sum := sha256.Sum256([]byte(entry.id + "/" + entry.etag))
+		entry.file = filepath.Join(cache.cfg.Dir, hex.EncodeToString(sum[:])+cacheFileExtension)
+		if err := os.Rename(tmp.Name(), entry.file); err != nil {
+			log.Warnf("Unable to cache %s: %v", key, err)
+			os.Remove(tmp.Name())
+			return
+		}
+		cache.add(entry)
+	}
+}
+
+// Add an entry to the cache, replacing the previous version of the object and evicting the least recently used objects
+func (cache *objectCache) add(entry *cacheEntry) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if previous, ok := cache.entries[entry.id]; ok {
+		cache.removeLocked(previous, previous.file != entry.file)
+	}
+	entry.checked = time.Now()
+	entry.element = cache.lru.PushFront(entry)
+	cache.entries[entry.id] = entry
+	cache.size += entry.size
+	for cache.size > cache.cfg.MaxSize {
+		cache.removeLocked(cache.lru.Back().Value.(*cacheEntry), true)
+	}
+}
+
+// Remove an entry, and its file if asked
+func (cache *objectCache) removeLocked(entry *cacheEntry, removeFile bool) {
+	cache.lru.Remove(entry.element)
+	delete(cache.entries, entry.id)
+	cache.size -= entry.size
+	if removeFile && entry.file != "" {
+		os.Remove(entry.file)
+	}
+}
+
+// Remove an object from the cache
+func (cache *objectCache) invalidate(bucket, key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry, ok := cache.entries[cacheID(bucket, key)]; ok {
+		cache.removeLocked(entry, true)
+	}
+}
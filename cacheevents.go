@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Longest pause of the queue polling after errors
+const maxCacheEventsBackoff = time.Minute
+
+// Cache invalidation config type, by the S3 event notifications of the objects changed outside the server
+type cacheEventsConfig struct {
+	// URL of the SQS queue receiving the S3 event notifications, directly, through a SNS topic or from EventBridge
+	QueueURL string `json:"queueUrl" yaml:"queueUrl" toml:"queueUrl"`
+	// Region of the queue, default is awsRegion
+	Region string `json:"region" yaml:"region" toml:"region"`
+	// SQS endpoint, e.g. of a local SQS server
+	Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+}
+
+// Check if the cache invalidation by events is enabled
+func (cfg cacheEventsConfig) enabled() bool {
+	return cfg.QueueURL != ""
+}
+
+// Check that a cache is enabled when the events are
+func (cfg *cacheEventsConfig) validate(config *webConfig) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	if !config.MemoryCache.Enabled && !config.DiskCache.Enabled {
+		return fmt.Errorf("cacheEvents needs memoryCache or diskCache")
+	}
+	if cfg.Region == "" {
+		cfg.Region = config.AwsRegion
+	}
+	return nil
+}
+
+// SQS client of the cache events queue
+var sqsSession *sqs.SQS
+
+// S3 event notification type, only the fields used to find the changed objects
+type s3EventNotification struct {
+	Records []struct {
+		S3 struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+	// SNS envelope, the S3 event is in Message
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+	// EventBridge event
+	Source string `json:"source"`
+	Detail struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"detail"`
+}
+
+// Object changed by an event
+type changedObject struct {
+	bucket string
+	key    string
+}
+
+// Parse the objects changed by a queue message, an S3 event notification, a SNS notification
+// wrapping one or an EventBridge S3 event
+func parseCacheEvent(body string) ([]changedObject, error) {
+	var event s3EventNotification
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil, err
+	}
+	if event.Type == "Notification" && event.Message != "" {
+		return parseCacheEvent(event.Message)
+	}
+	if event.Source == "aws.s3" {
+		// The EventBridge keys are not URL encoded
+		return []changedObject{{event.Detail.Bucket.Name, event.Detail.Object.Key}}, nil
+	}
+	var changed []changedObject
+	for _, record := range event.Records {
+		// The keys of the notifications are URL encoded, with + for the spaces
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", record.S3.Object.Key, err)
+		}
+		changed = append(changed, changedObject{record.S3.Bucket.Name, key})
+	}
+	return changed, nil
+}
+
+// Poll the queue and remove the objects of the received events from the caches.
+// The messages are deleted once handled, the invalid ones too so they are not received again.
+func watchCacheEvents(cfg cacheEventsConfig) {
+	log.Infof("Invalidating the cached objects on the events of %s", cfg.QueueURL)
+	backoff := time.Second
+	for {
+		resp, err := sqsSession.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(cfg.QueueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			log.Warnf("Unable to receive the cache events, retrying in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxCacheEventsBackoff {
+				backoff = maxCacheEventsBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		if len(resp.Messages) == 0 {
+			continue
+		}
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(resp.Messages))
+		for i, message := range resp.Messages {
+			changed, err := parseCacheEvent(aws.StringValue(message.Body))
+			if err != nil {
+				log.Warnf("Invalid cache event %s: %v", aws.StringValue(message.MessageId), err)
+			}
+			for _, object := range changed {
+				log.Debugf("Cache event of %s in %s", object.key, object.bucket)
+				caches.invalidate(object.bucket, object.key)
+			}
+			entries[i] = &sqs.DeleteMessageBatchRequestEntry{Id: aws.String(fmt.Sprint(i)), ReceiptHandle: message.ReceiptHandle}
+		}
+		deleted, err := sqsSession.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{QueueUrl: aws.String(cfg.QueueURL), Entries: entries})
+		if err != nil {
+			log.Warnf("Unable to delete the cache events: %v", err)
+		} else if len(deleted.Failed) > 0 {
+			log.Warnf("Unable to delete %d cache events: %s", len(deleted.Failed), aws.StringValue(deleted.Failed[0].Message))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Chaos mode config type, percentages are of the object requests
+type chaosConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Requests delayed by Latency
+	LatencyPercent float64  `json:"latencyPercent" yaml:"latencyPercent" toml:"latencyPercent"`
+	Latency        duration `json:"latency" yaml:"latency" toml:"latency"`
+	// Requests failing with ErrorStatus
+	ErrorPercent float64 `json:"errorPercent" yaml:"errorPercent" toml:"errorPercent"`
+	ErrorStatus  int     `json:"errorStatus" yaml:"errorStatus" toml:"errorStatus"`
+	// Responses cut in the middle of the body
+	TruncatePercent float64 `json:"truncatePercent" yaml:"truncatePercent" toml:"truncatePercent"`
+}
+
+// Check the chaos mode config
+func (cfg chaosConfig) validate() error {
+	for _, percent := range []float64{cfg.LatencyPercent, cfg.ErrorPercent, cfg.TruncatePercent} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("chaos percentages must be between 0 and 100")
+		}
+	}
+	if cfg.ErrorStatus != 0 && (cfg.ErrorStatus < 400 || cfg.ErrorStatus > 599) {
+		return fmt.Errorf("chaos error status must be a 4xx or 5xx status")
+	}
+	return nil
+}
+
+// Chaos mode state, can be changed at runtime by the admin endpoint
+type chaosState struct {
+	mu  sync.RWMutex
+	cfg chaosConfig
+}
+
+// Chaos mode of the server
+var chaos = &chaosState{}
+
+func (s *chaosState) get() chaosConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+func (s *chaosState) set(cfg chaosConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// Draw whether a fault is injected
+func chaosHit(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// Middleware injecting faults in the object requests
+func chaosMiddleware(c *gin.Context) {
+	cfg := chaos.get()
+	if path := strings.TrimPrefix(c.Request.URL.Path, "/"); !cfg.Enabled || isReservedPath(path) || isHealthPath(path) {
+		return
+	}
+	if chaosHit(cfg.LatencyPercent) {
+		select {
+		case <-time.After(cfg.Latency.orDefault(time.Second)):
+		case <-c.Request.Context().Done():
+		}
+	}
+	if chaosHit(cfg.ErrorPercent) {
+		status := cfg.ErrorStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(c, status, "ChaosInjected", "Fault injected by chaos mode", "")
+		c.Abort()
+		return
+	}
+	if chaosHit(cfg.TruncatePercent) {
+		c.Writer = &truncatingWriter{ResponseWriter: c.Writer, limit: -1}
+	}
+}
+
+// Response writer closing the connection after half of the body, or after half of the first write
+// when the length is not known (chunked or compressed responses)
+type truncatingWriter struct {
+	gin.ResponseWriter
+	limit   int64
+	written int64
+}
+
+func (w *truncatingWriter) Write(data []byte) (int, error) {
+	if w.limit < 0 {
+		length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			length = int64(len(data))
+		}
+		w.limit = length / 2
+	}
+	if w.written+int64(len(data)) <= w.limit {
+		n, err := w.ResponseWriter.Write(data)
+		w.written += int64(n)
+		return n, err
+	}
+	n, _ := w.ResponseWriter.Write(data[:w.limit-w.written])
+	w.written += int64(n)
+	w.ResponseWriter.Flush()
+	if conn, _, err := w.ResponseWriter.Hijack(); err == nil {
+		conn.Close()
+	}
+	log.Debugf("Chaos mode truncated the response after %d bytes", w.written)
+	return n, io.ErrShortWrite
+}
+
+func (w *truncatingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Serve the chaos mode settings
+func serveGetChaos(c *gin.Context) {
+	c.JSON(http.StatusOK, chaos.get())
+}
+
+// Change the chaos mode settings, the faults are only injected when the chaos mode is enabled at startup
+func servePutChaos(c *gin.Context) {
+	if !configOf(c).Chaos.Enabled {
+		writeError(c, http.StatusConflict, "ChaosDisabled", "Chaos mode is not enabled in the configuration", "")
+		return
+	}
+	var cfg chaosConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid chaos settings: "+err.Error(), "")
+		return
+	}
+	if err := cfg.validate(); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", err.Error(), "")
+		return
+	}
+	chaos.set(cfg)
+	log.Warnf("Chaos mode settings changed: %+v", cfg)
+	c.JSON(http.StatusOK, cfg)
+}
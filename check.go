@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Validate a configuration before deploying it: keys of the file, values, credentials and
+// reachability of the buckets, without binding the port. Returns the process exit code.
+func runCheck(configFile string, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", configFile, "`config file`")
+	flags.Parse(args)
+
+	if err := checkConfigKeys(configFile); err != nil {
+		fmt.Printf("[FAIL] configuration: %s: %v\n", configFile, err)
+		return 1
+	}
+	config, err := readConfig(configFile)
+	if err != nil {
+		fmt.Printf("[FAIL] configuration: %v\n", err)
+		return 1
+	}
+	fmt.Printf("[PASS] configuration: %s, bucket %s in %s\n", configFile, config.S3bucket, config.AwsRegion)
+	currentConfig.Store(config)
+	if err := setupAWS(config); err != nil {
+		fmt.Printf("[FAIL] credentials: %v\n", err)
+		return 1
+	}
+
+	checks := []doctorCheck{{"credentials", doctorCredentials}}
+	for _, bucket := range configuredBuckets(config) {
+		checks = append(checks, doctorCheck{"s3:HeadBucket " + bucket, doctorHeadBucket(bucket)})
+	}
+	return runDoctorChecks(checks)
+}
+
+// Check that a configuration file has only known keys, a misspelled key would be silently ignored
+func checkConfigKeys(configPath string) error {
+	bs, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) && configPath == defaultConfigFile {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(configPath) {
+	case ".yaml", ".yml":
+		return yaml.UnmarshalStrict(bs, &webConfig{})
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(bs))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(&webConfig{})
+	case ".toml":
+		md, err := toml.Decode(string(bs), &webConfig{})
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("unknown keys %s", strings.Join(keys, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit breaker config type
+type circuitBreakerConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Number of consecutive failed S3 calls opening the circuit
+	FailureThreshold int `json:"failureThreshold" yaml:"failureThreshold" toml:"failureThreshold"`
+	// Interval between two S3 probes while the circuit is open
+	ProbeInterval duration `json:"probeInterval" yaml:"probeInterval" toml:"probeInterval"`
+	// Local file served while the circuit is open
+	MaintenancePage string `json:"maintenancePage" yaml:"maintenancePage" toml:"maintenancePage"`
+	// Replica buckets serving the reads while the circuit is open
+	Fallback replicaConfig `json:"fallback" yaml:"fallback" toml:"fallback"`
+}
+
+// Circuit breaker around the S3 calls
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	interval  time.Duration
+	failures  int
+	open      bool
+	openedAt  time.Time
+
+	page        []byte
+	contentType string
+}
+
+// Circuit breaker of the S3 calls, nil if disabled
+var breaker *circuitBreaker
+
+// Create the circuit breaker from the configuration
+func newCircuitBreaker(cfg circuitBreakerConfig) (*circuitBreaker, error) {
+	cb := &circuitBreaker{
+		threshold:   cfg.FailureThreshold,
+		interval:    cfg.ProbeInterval.orDefault(10 * time.Second),
+		page:        []byte("Service temporarily unavailable, please retry later.\n"),
+		contentType: "text/plain; charset=utf-8",
+	}
+	if cb.threshold <= 0 {
+		cb.threshold = 5
+	}
+	if cfg.MaintenancePage != "" {
+		page, err := ioutil.ReadFile(cfg.MaintenancePage)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read maintenance page")
+		}
+		cb.page = page
+		if contentType := mime.TypeByExtension(filepath.Ext(cfg.MaintenancePage)); contentType != "" {
+			cb.contentType = contentType
+		}
+	}
+	return cb, nil
+}
+
+// Add the circuit breaker handler to the S3 client
+func (cb *circuitBreaker) register(svc *s3.S3) {
+	svc.Handlers.Complete.PushBack(cb.record)
+}
+
+// Record the result of a S3 call, once all retries are done
+func (cb *circuitBreaker) record(r *request.Request) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !isTransientError(r) && !isDeadlineExceeded(r.Error) {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if !cb.open && cb.failures >= cb.threshold {
+		log.Errorf("Circuit breaker open after %d failed S3 calls: %v", cb.failures, r.Error)
+		if fallback != nil {
+			log.Warnf("Serving the reads from the replica buckets until S3 is reachable again")
+		}
+		cb.open = true
+		cb.openedAt = time.Now()
+		go cb.probe()
+	}
+}
+
+// Check if S3 calls are short-circuited
+func (cb *circuitBreaker) isOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.open
+}
+
+// Probe S3 in background until it answers again, then close the circuit
+func (cb *circuitBreaker) probe() {
+	bucket := currentConfig.Load().S3bucket
+	for {
+		time.Sleep(cb.interval)
+		ctx, cancel := context.WithTimeout(context.Background(), currentConfig.Load().Timeouts.Head.orDefault(backgroundTimeout))
+		_, err := s3Session.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		cancel()
+		if err == nil {
+			cb.mu.Lock()
+			cb.open = false
+			cb.failures = 0
+			cb.mu.Unlock()
+			log.Infof("Circuit breaker closed, S3 is reachable again")
+			return
+		}
+		log.Debugf("Circuit breaker probe failed: %v", err)
+	}
+}
+
+// Serve the degraded mode response
+func (cb *circuitBreaker) serveDegraded(c *gin.Context) {
+	c.Header("Retry-After", fmt.Sprintf("%d", int(cb.interval.Seconds())+1))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusServiceUnavailable, cb.contentType, cb.page)
+}
+
+// Circuit breaker state type
+type circuitState struct {
+	Enabled  bool       `json:"enabled"`
+	Open     bool       `json:"open"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+// Serve the circuit breaker state
+func serveCircuitState(c *gin.Context) {
+	state := circuitState{}
+	if breaker != nil {
+		breaker.mu.Lock()
+		state = circuitState{Enabled: true, Open: breaker.open, Failures: breaker.failures}
+		if breaker.open {
+			openedAt := breaker.openedAt
+			state.OpenedAt = &openedAt
+		}
+		breaker.mu.Unlock()
+	}
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Compression config type
+type compressionConfig struct {
+	// Gzip level, from 1 (best speed) to 9 (best compression), -1 is the default level and 0 disables compression
+	Level *int `json:"level" yaml:"level" toml:"level"`
+	// Responses smaller than this size (in bytes) are not compressed
+	MinSize *int64 `json:"minSize" yaml:"minSize" toml:"minSize"`
+	// Compressed media types, "text/*" matches a whole family
+	Types []string `json:"types" yaml:"types" toml:"types"`
+}
+
+// Media types compressed by default, the images, videos and archives are already compressed
+var defaultCompressedTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/manifest+json",
+	"application/wasm",
+	"image/svg+xml",
+	"image/x-icon",
+	"font/ttf",
+	"font/otf",
+}
+
+// Get the gzip level
+func (cfg compressionConfig) level() int {
+	if cfg.Level == nil {
+		return gzip.DefaultCompression
+	}
+	return *cfg.Level
+}
+
+// Get the minimum size of a compressed response
+func (cfg compressionConfig) minSize() int64 {
+	if cfg.MinSize == nil {
+		return 1024
+	}
+	return *cfg.MinSize
+}
+
+// Get the compressed media types
+func (cfg compressionConfig) types() []string {
+	if cfg.Types == nil {
+		return defaultCompressedTypes
+	}
+	return cfg.Types
+}
+
+// Check the compression configuration
+func (cfg compressionConfig) validate() error {
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, cfg.level()); err != nil {
+		return err
+	}
+	for _, t := range cfg.Types {
+		if !strings.Contains(t, "/") {
+			return fmt.Errorf("invalid media type %q", t)
+		}
+	}
+	return nil
+}
+
+// Check if a media type is listed, the parameters of the content type are ignored
+func matchesMediaType(types []string, contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the client accepts a gzip encoded body
+func acceptsGzipEncoding(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+}
+
+// Check if the response to a request can be compressed on the fly
+func acceptsGzip(r *http.Request) bool {
+	return acceptsGzipEncoding(r) &&
+		!strings.Contains(r.Header.Get("Connection"), "Upgrade") &&
+		r.Method != http.MethodHead
+}
+
+// Add the handler asking S3 for the stored encoding of the downloads: the HTTP client would
+// otherwise decode the gzip objects and drop their Content-Encoding, unlike for a HEAD
+func registerStoredEncoding(svc *s3.S3) {
+	svc.Handlers.Build.PushBack(func(r *request.Request) {
+		if r.Operation.Name == "GetObject" {
+			r.HTTPRequest.Header.Set("Accept-Encoding", "identity")
+		}
+	})
+}
+
+// Add a value to the Vary header, unless it is already listed
+func addVary(header http.Header, value string) {
+	for _, vary := range header["Vary"] {
+		for _, v := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) || strings.TrimSpace(v) == "*" {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// Response writer compressing the body once the response is known to be large enough.
+// Small bodies of unknown length are buffered up to the minimum size before deciding.
+// Every response which could be compressed gets a "Vary: Accept-Encoding" header, whether
+// this client accepts gzip or not, so that caches never serve a representation to a client
+// which did not ask for it.
+type gzipWriter struct {
+	gin.ResponseWriter
+	request  *http.Request
+	accepts  bool
+	pool     *sync.Pool
+	minSize  int64
+	types    []string
+	gz       *gzip.Writer
+	buf      []byte
+	decided  bool
+	compress bool
+}
+
+// Check if the response could be compressed for a client accepting gzip
+func (w *gzipWriter) compressible() bool {
+	if w.ResponseWriter.Status() != http.StatusOK && w.ResponseWriter.Status() != http.StatusCreated {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || strings.Contains(header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(w.request.URL.Path))
+	}
+	return matchesMediaType(w.types, contentType)
+}
+
+// Check if the response is compressed for this client, length is -1 if unknown
+func (w *gzipWriter) eligible(length int64) bool {
+	return w.accepts && (length < 0 || length >= w.minSize)
+}
+
+// Get the response length from the Content-Length header, -1 if unknown
+func (w *gzipWriter) contentLength() int64 {
+	length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return length
+}
+
+// Decide whether the response is compressed and write the buffered data
+func (w *gzipWriter) decide(compress bool) error {
+	w.decided = true
+	if !w.compressible() {
+		compress = false
+	} else {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+	w.compress = compress
+	if compress {
+		header := w.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	_, err := w.write(buf)
+	return err
+}
+
+// Write data once the decision is made
+func (w *gzipWriter) write(data []byte) (int, error) {
+	if w.compress {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if length := w.contentLength(); length >= 0 {
+			if err := w.decide(w.eligible(length)); err != nil {
+				return 0, err
+			}
+		} else {
+			w.buf = append(w.buf, data...)
+			if int64(len(w.buf)) >= w.minSize {
+				if err := w.decide(w.eligible(-1)); err != nil {
+					return 0, err
+				}
+			}
+			return len(data), nil
+		}
+	}
+	return w.write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) WriteHeaderNow() {
+	if !w.decided {
+		// Headers are sent before any body, compress only if the length is known
+		length := w.contentLength()
+		w.decide(length >= 0 && w.eligible(length))
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide(w.eligible(w.contentLength()))
+	}
+	if w.compress {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Write the remaining data once the handlers are done
+func (w *gzipWriter) finish() {
+	if !w.decided {
+		// The whole body is buffered, its length is known
+		w.decide(w.eligible(int64(len(w.buf))))
+	}
+	if w.compress {
+		w.gz.Close()
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// Middleware compressing the responses with gzip
+func compressMiddleware(cfg compressionConfig) gin.HandlerFunc {
+	level := cfg.level()
+	minSize := cfg.minSize()
+	types := cfg.types()
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(ioutil.Discard, level)
+		return gz
+	}}
+	return func(c *gin.Context) {
+		w := &gzipWriter{ResponseWriter: c.Writer, request: c.Request, accepts: acceptsGzip(c.Request), pool: pool, minSize: minSize, types: types}
+		c.Writer = w
+		defer w.finish()
+		c.Next()
+	}
+}
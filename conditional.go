@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+)
+
+// Check if an entity tag matches a If-Match like header value, using the strong comparison
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (candidate == etag && !strings.HasPrefix(candidate, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the error is a failed S3 precondition
+func isPreconditionFailed(err error) bool {
+	awsError, ok := err.(awserr.Error)
+	return ok && awsError.Code() == "PreconditionFailed"
+}
+
+// Apply the If-Range header of a ranged GET.
+// S3 has no If-Range support, so the validator is sent as If-Match or If-Unmodified-Since:
+// the caller must retry without the range if that precondition fails.
+// Returns true if the range is conditional.
+func applyIfRange(r *http.Request, params *s3.GetObjectInput) bool {
+	ifRange := r.Header.Get("If-Range")
+	if params.Range == nil || ifRange == "" {
+		return false
+	}
+	if strings.HasPrefix(ifRange, "\"") {
+		if params.IfMatch != nil {
+			// Cannot combine both validators, serve the full content
+			params.Range = nil
+			return false
+		}
+		params.IfMatch = aws.String(ifRange)
+		return true
+	}
+	if strings.HasPrefix(ifRange, "W/") {
+		// Weak entity tags never match for a range
+		params.Range = nil
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil || params.IfUnmodifiedSince != nil {
+		params.Range = nil
+		return false
+	}
+	params.IfUnmodifiedSince = aws.Time(t)
+	return true
+}
+
+// Set the headers of a 304 response, which must carry the cache headers of a 200 response.
+// S3 errors have no headers, the entity tag is the one of If-None-Match when it has a single value.
+func setNotModifiedHeaders(c *gin.Context, key string) {
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && !strings.Contains(ifNoneMatch, ",") && ifNoneMatch != "*" {
+		c.Header("Etag", strings.TrimSpace(ifNoneMatch))
+	}
+	configOf(c).setExpiryHeaders(c.Writer.Header(), key)
+}
+
+// Check the preconditions of a write (If-Match, If-None-Match, If-Unmodified-Since) against the current object.
+// The object is fetched with a HEAD first, the stores without conditional writes would not check them.
+// Returns false if the preconditions failed and the response has been written.
+func checkWritePreconditions(c *gin.Context, bucket, key string) bool {
+	ifMatch := c.GetHeader("If-Match")
+	ifNoneMatch := c.GetHeader("If-None-Match")
+	ifUnmodifiedSince := c.GetHeader("If-Unmodified-Since")
+	if ifMatch == "" && ifNoneMatch == "" && ifUnmodifiedSince == "" {
+		return true
+	}
+	input := &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if err := applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
+		return false
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	defer cancel()
+	head, err := s3Session.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		if isNotFoundError(err) {
+			if ifMatch != "" {
+				writeError(c, http.StatusPreconditionFailed, "PreconditionFailed", "Object '"+key+"' does not exist", "")
+				return false
+			}
+			return true
+		}
+		handleHTTPException(c, key, err)
+		return false
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, aws.StringValue(head.ETag)) {
+		writeError(c, http.StatusPreconditionFailed, "PreconditionFailed", "Object '"+key+"' does not match If-Match", "")
+		return false
+	}
+	if ifMatch == "" && ifUnmodifiedSince != "" {
+		if t, err := http.ParseTime(ifUnmodifiedSince); err == nil && head.LastModified != nil && head.LastModified.After(t) {
+			writeError(c, http.StatusPreconditionFailed, "PreconditionFailed", "Object '"+key+"' was modified since "+ifUnmodifiedSince, "")
+			return false
+		}
+	}
+	if ifNoneMatch != "" && etagMatchesWeak(ifNoneMatch, aws.StringValue(head.ETag)) {
+		// Not a GET, a matching If-None-Match fails with a 412 rather than a 304
+		message := "Object '" + key + "' matches If-None-Match"
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			message = "Object '" + key + "' already exists"
+		}
+		writeError(c, http.StatusPreconditionFailed, "PreconditionFailed", message, "")
+		return false
+	}
+	return true
+}
+
+// Get the upload options sending the preconditions of a PUT to S3 too, when conditionalWrites is enabled,
+// so that an object written between the HEAD check and the upload is not replaced.
+// S3 only checks If-None-Match: * and a single If-Match entity tag, on PutObject and CompleteMultipartUpload.
+func conditionalWriteOptions(r *http.Request) []func(*s3manager.Uploader) {
+	if !configFrom(r.Context()).ConditionalWrites {
+		return nil
+	}
+	headers := map[string]string{}
+	if strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+		headers["If-None-Match"] = "*"
+	}
+	if ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); strings.HasPrefix(ifMatch, "\"") && !strings.Contains(ifMatch, ",") {
+		headers["If-Match"] = ifMatch
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return []func(*s3manager.Uploader){s3manager.WithUploaderRequestOptions(func(req *request.Request) {
+		if req.Operation.Name != "PutObject" && req.Operation.Name != "CompleteMultipartUpload" {
+			return
+		}
+		req.Handlers.Build.PushBack(func(req *request.Request) {
+			for name, value := range headers {
+				req.HTTPRequest.Header.Set(name, value)
+			}
+		})
+	})}
+}
+
+// Check if an entity tag matches a If-None-Match like header value, using the weak comparison
+func etagMatchesWeak(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate the read preconditions of a request against an object, in the order of RFC 7232.
+// Returns the status of the failed precondition (304 or 412), 0 if the object is to be served.
+func readPreconditionStatus(r *http.Request, etag string, lastModified time.Time) int {
+	// The HTTP dates have no sub-second part
+	lastModified = lastModified.Truncate(time.Second)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && lastModified.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatchesWeak(ifNoneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Content types given by S3 and the upload clients to the objects of unknown type
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+// Check the content type overrides and normalize their extensions (".svg")
+func validateContentTypes(types map[string]string) error {
+	for ext, contentType := range types {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("invalid contentTypes value %s for %s: %v", contentType, ext, err)
+		}
+		normalized := strings.ToLower(ext)
+		if !strings.HasPrefix(normalized, ".") {
+			normalized = "." + normalized
+		}
+		if normalized != ext {
+			delete(types, ext)
+			types[normalized] = contentType
+		}
+	}
+	return nil
+}
+
+// Get the Content-Type of an object, inferred from the extension of its key when S3 gives none or a generic one
+func (config *webConfig) objectContentType(key string, stored *string) string {
+	contentType := aws.StringValue(stored)
+	if contentType != "" && !genericContentTypes[strings.ToLower(contentType)] {
+		return contentType
+	}
+	ext := strings.ToLower(path.Ext(key))
+	if override, ok := config.ContentTypes[ext]; ok {
+		return override
+	}
+	if inferred := mime.TypeByExtension(ext); inferred != "" {
+		return inferred
+	}
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
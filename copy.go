@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Largest object copied by a single CopyObject, the larger ones are copied by parts
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	// Size of the parts of the multipart copies, grown for the objects of more than maxCopyParts parts
+	copyPartSize = 512 * 1024 * 1024
+	maxCopyParts = 10000
+)
+
+// Copy request body type
+type copyRequest struct {
+	Source      string `json:"source" binding:"required"`
+	Destination string `json:"destination" binding:"required"`
+	// Delete the source once copied
+	Move bool `json:"move"`
+	// Replace an existing destination, default is true
+	Overwrite *bool `json:"overwrite"`
+}
+
+// Copy response body type
+type copyResponse struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	VersionID   string `json:"versionId,omitempty"`
+	Size        int64  `json:"size"`
+}
+
+// Get the CopySource of an object
+func copySource(bucket, key string) string {
+	return bucket + "/" + url.PathEscape(key)
+}
+
+// Copy or move an object to another key, S3 copies the content without going through the server
+func serveCopy(c *gin.Context) {
+	config := configOf(c)
+	var req copyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid copy request: "+err.Error(), "")
+		return
+	}
+	source := strings.TrimPrefix(req.Source, "/")
+	destination := config.normalizeUploadKey(strings.TrimPrefix(req.Destination, "/"))
+	for _, key := range []string{source, destination} {
+		if key == "" || strings.HasSuffix(key, "/") || config.isHiddenKey(key) {
+			writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid copy request", "")
+			return
+		}
+	}
+	if source == destination {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "The source and the destination are the same", "")
+		return
+	}
+	// A copy writes the destination, a move deletes the source too
+	if !methodAllowed(http.MethodPut) || (req.Move && !methodAllowed(http.MethodDelete)) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Copies not allowed", "")
+		return
+	}
+	if !checkKeyAccess(c, http.MethodGet, source) || !checkKeyAccess(c, http.MethodPut, destination) ||
+		(req.Move && !checkKeyAccess(c, http.MethodDelete, source)) {
+		return
+	}
+
+	srcBucket, srcKey := config.resolveObject(c.Request.Host, source)
+	dstBucket, dstKey := config.resolveObject(c.Request.Host, destination)
+	if config.encryptionFor(srcKey) != config.encryptionFor(dstKey) {
+		// The copy would keep the content as stored, encrypted or not
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Objects cannot be copied between keys of different encryption rules", "")
+		return
+	}
+	if req.Move && !checkSoftDelete(c, srcBucket, srcKey) {
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), config.Timeouts.Put)
+	defer cancel()
+	if req.Overwrite != nil && !*req.Overwrite {
+		_, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(dstBucket), Key: aws.String(dstKey)})
+		if err == nil {
+			writeError(c, http.StatusPreconditionFailed, "PreconditionFailed", "Object '"+destination+"' already exists", "")
+			return
+		}
+		if !isNotFoundError(err) {
+			handleHTTPException(c, destination, err)
+			return
+		}
+	}
+	resp := copyResponse{Source: source, Destination: destination}
+	var err error
+	resp.VersionID, resp.Size, err = copyObject(ctx, srcBucket, srcKey, dstBucket, dstKey)
+	if handleHTTPException(c, source, err) != nil {
+		return
+	}
+	caches.invalidate(dstBucket, dstKey)
+	if req.Move {
+		_, err = s3Session.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(srcBucket), Key: aws.String(srcKey)})
+		if handleHTTPException(c, source, err) != nil {
+			return
+		}
+		caches.invalidate(srcBucket, srcKey)
+		requestLog(c).Infof("Moved %s to %s", source, destination)
+	} else {
+		requestLog(c).Infof("Copied %s to %s", source, destination)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Copy an object, by parts if it is too large for a single copy.
+// Returns the version and the size of the new object.
+func copyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (string, int64, error) {
+	head, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(srcBucket), Key: aws.String(srcKey)})
+	if err != nil {
+		return "", 0, err
+	}
+	size := aws.Int64Value(head.ContentLength)
+	if size > maxCopyObjectSize {
+		versionID, err := copyObjectParts(ctx, head, copySource(srcBucket, srcKey), dstBucket, dstKey)
+		return versionID, size, err
+	}
+	input := &s3.CopyObjectInput{Bucket: aws.String(dstBucket), Key: aws.String(dstKey), CopySource: aws.String(copySource(srcBucket, srcKey))}
+	configFrom(ctx).applyServerSideEncryption(nil, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+	resp, err := s3Session.CopyObjectWithContext(ctx, input)
+	if err != nil {
+		return "", 0, err
+	}
+	return aws.StringValue(resp.VersionId), size, nil
+}
+
+// Copy a large object with a multipart upload whose parts are copied by S3, upload.concurrency at a time.
+// The headers and the metadata of the source are kept, as CopyObject does.
+func copyObjectParts(ctx context.Context, head *s3.HeadObjectOutput, source, bucket, key string) (string, error) {
+	size := aws.Int64Value(head.ContentLength)
+	partSize := int64(copyPartSize)
+	if n := (size + maxCopyParts - 1) / maxCopyParts; n > partSize {
+		partSize = n
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+		Metadata:           head.Metadata,
+		StorageClass:       head.StorageClass,
+	}
+	configFrom(ctx).applyServerSideEncryption(nil, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+	upload, err := s3Session.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	partsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	parts := make([]*s3.CompletedPart, (size+partSize-1)/partSize)
+	slots := make(chan struct{}, configFrom(ctx).Upload.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var partErr error
+	for i := range parts {
+		if partsCtx.Err() != nil {
+			break
+		}
+		start := int64(i) * partSize
+		end := start + partSize
+		if end > size {
+			end = size
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, start, end int64) {
+			defer func() { <-slots; wg.Done() }()
+			number := aws.Int64(int64(i + 1))
+			resp, err := s3Session.UploadPartCopyWithContext(partsCtx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(bucket),
+				Key:             aws.String(key),
+				UploadId:        upload.UploadId,
+				PartNumber:      number,
+				CopySource:      aws.String(source),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+			})
+			if err != nil {
+				mu.Lock()
+				if partErr == nil {
+					partErr = err
+					cancel()
+				}
+				mu.Unlock()
+				return
+			}
+			parts[i] = &s3.CompletedPart{ETag: resp.CopyPartResult.ETag, PartNumber: number}
+		}(i, start, end)
+	}
+	wg.Wait()
+	if partErr == nil {
+		partErr = ctx.Err()
+	}
+	if partErr != nil {
+		// The parts already copied are billed until the upload is aborted
+		abortCtx, abortCancel := s3Context(context.Background(), configFrom(ctx).Timeouts.Delete)
+		defer abortCancel()
+		s3Session.AbortMultipartUploadWithContext(abortCtx, &s3.AbortMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(key), UploadId: upload.UploadId})
+		return "", partErr
+	}
+	resp, err := s3Session.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.VersionId), nil
+}
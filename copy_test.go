@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCopyEncryptedKey(t *testing.T) {
+	router, fake := newTestServer(t, newEncryptionTestConfig())
+	fake.put("bucket/site/public/a.txt", testContent)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"cleartext to encrypted", `{"source": "public/a.txt", "destination": "private/a.txt"}`, http.StatusBadRequest},
+		{"cleartext to cleartext", `{"source": "public/a.txt", "destination": "public/b.txt"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestBody(router, http.MethodPost, "/_api/copy", nil, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+	if _, ok := fake.get("bucket/site/private/a.txt"); ok {
+		t.Errorf("cleartext object copied under the encryption prefix")
+	}
+}
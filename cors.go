@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS config type, enabled when origins are allowed
+type corsConfig struct {
+	// Allowed origins, "*" for any origin and "https://*.example.com" for the sub-domains
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins" toml:"allowedOrigins"`
+	AllowedMethods []string `json:"allowedMethods" yaml:"allowedMethods" toml:"allowedMethods"`
+	// Allowed request headers, default is the headers asked by the preflight request
+	AllowedHeaders []string `json:"allowedHeaders" yaml:"allowedHeaders" toml:"allowedHeaders"`
+	// Response headers readable by the browser scripts
+	ExposedHeaders []string `json:"exposedHeaders" yaml:"exposedHeaders" toml:"exposedHeaders"`
+	// Delay during which the browsers cache a preflight response
+	MaxAge           duration `json:"maxAge" yaml:"maxAge" toml:"maxAge"`
+	AllowCredentials bool     `json:"allowCredentials" yaml:"allowCredentials" toml:"allowCredentials"`
+}
+
+// Check if the CORS requests are answered
+func (cfg corsConfig) enabled() bool {
+	return len(cfg.AllowedOrigins) > 0
+}
+
+// Set the CORS defaults
+func (cfg *corsConfig) validate() error {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{"GET", "HEAD", "PUT", "POST", "DELETE"}
+	}
+	for i, method := range cfg.AllowedMethods {
+		cfg.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	if len(cfg.ExposedHeaders) == 0 {
+		cfg.ExposedHeaders = []string{"Etag", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", requestIDHeader}
+	}
+	return nil
+}
+
+// Check if an origin is allowed
+func (cfg corsConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if i := strings.Index(allowed, "*."); i >= 0 && strings.HasPrefix(origin, allowed[:i]) && strings.HasSuffix(origin, allowed[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a method is allowed
+func (cfg corsConfig) allowsMethod(method string) bool {
+	for _, m := range cfg.AllowedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Answer the preflight requests and add the CORS headers to the responses of the allowed origins
+func corsMiddleware(c *gin.Context) {
+	cfg := configOf(c).CORS
+	origin := c.GetHeader("Origin")
+	header := c.Writer.Header()
+	if origin == "" {
+		return
+	}
+	header.Add("Vary", "Origin")
+	requestMethod := c.GetHeader("Access-Control-Request-Method")
+	preflight := c.Request.Method == http.MethodOptions && requestMethod != ""
+	if !cfg.allowsOrigin(origin) {
+		if preflight {
+			writeError(c, http.StatusForbidden, "CORSForbidden", "Origin "+origin+" is not allowed", "")
+			c.Abort()
+		}
+		return
+	}
+	if containsString(cfg.AllowedOrigins, "*") && !cfg.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if cfg.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		header.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+		return
+	}
+	// Preflight request
+	if cfg.allowsMethod(requestMethod) {
+		header.Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+		if len(cfg.AllowedHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+		} else if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		if cfg.MaxAge.Duration > 0 {
+			header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(cfg.MaxAge.Seconds()), 10))
+		}
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// Check if a list holds a string
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
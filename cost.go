@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Hours in a billing month, used to extrapolate the observed traffic
+const hoursPerMonth = 730
+
+// S3 pricing config type, prices are in the billing currency. A price set to 0 is free, e.g. the
+// transfer from MinIO or an internal endpoint.
+type pricingConfig struct {
+	GetPer1000    *float64 `json:"getPer1000" yaml:"getPer1000" toml:"getPer1000"`
+	PutPer1000    *float64 `json:"putPer1000" yaml:"putPer1000" toml:"putPer1000"`
+	ListPer1000   *float64 `json:"listPer1000" yaml:"listPer1000" toml:"listPer1000"`
+	TransferPerGB *float64 `json:"transferPerGB" yaml:"transferPerGB" toml:"transferPerGB"`
+}
+
+// Set the S3 Standard pricing of us-east-1 for missing prices
+func (p *pricingConfig) setDefaults() {
+	if p.GetPer1000 == nil {
+		p.GetPer1000 = aws.Float64(0.0004)
+	}
+	if p.PutPer1000 == nil {
+		p.PutPer1000 = aws.Float64(0.005)
+	}
+	if p.ListPer1000 == nil {
+		p.ListPer1000 = aws.Float64(0.005)
+	}
+	if p.TransferPerGB == nil {
+		p.TransferPerGB = aws.Float64(0.09)
+	}
+}
+
+// S3 usage counters type
+type usageCounters struct {
+	started  time.Time
+	gets     int64
+	puts     int64
+	lists    int64
+	bytesOut int64
+	bytesIn  int64
+}
+
+// Usage counters since the server started
+var usage = &usageCounters{started: time.Now()}
+
+// Count bytes sent to clients
+func (u *usageCounters) addBytesOut(n int64) {
+	atomic.AddInt64(&u.bytesOut, n)
+}
+
+// Count bytes received from clients
+func (u *usageCounters) addBytesIn(n int64) {
+	atomic.AddInt64(&u.bytesIn, n)
+}
+
+// Count every S3 request attempt by pricing class, the deletes are free
+func (u *usageCounters) countRequest(r *request.Request) {
+	switch r.Operation.Name {
+	case "GetObject", "HeadObject", "HeadBucket", "SelectObjectContent":
+		atomic.AddInt64(&u.gets, 1)
+	case "ListObjects", "ListObjectsV2", "ListObjectVersions", "ListMultipartUploads", "ListParts":
+		atomic.AddInt64(&u.lists, 1)
+	case "PutObject", "CopyObject", "UploadPart", "UploadPartCopy", "CreateMultipartUpload", "CompleteMultipartUpload":
+		atomic.AddInt64(&u.puts, 1)
+	}
+}
+
+// Add usage tracking handlers to the S3 client
+func registerUsageHandlers(svc *s3.S3) {
+	svc.Handlers.CompleteAttempt.PushBack(usage.countRequest)
+}
+
+// Header of the S3 requests accepting the charges of a requester pays bucket
+const requestPayerHeader = "X-Amz-Request-Payer"
+
+// Add the handler sending all the S3 requests as the payer, for the requester pays buckets
+func registerRequesterPays(svc *s3.S3) {
+	svc.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(requestPayerHeader, s3.RequestPayerRequester)
+	})
+}
+
+// Cost report line type
+type costLine struct {
+	Count            int64   `json:"count"`
+	MonthlyCount     int64   `json:"monthlyCount"`
+	EstimatedMonthly float64 `json:"estimatedMonthly"`
+}
+
+// Cost report type
+type costReport struct {
+	Since            time.Time `json:"since"`
+	UptimeSeconds    int64     `json:"uptimeSeconds"`
+	Get              costLine  `json:"get"`
+	Put              costLine  `json:"put"`
+	List             costLine  `json:"list"`
+	Transfer         costLine  `json:"transferBytes"`
+	BytesIn          int64     `json:"bytesIn"`
+	EstimatedMonthly float64   `json:"estimatedMonthly"`
+}
+
+// Build a cost report extrapolating the observed traffic to a month
+func (u *usageCounters) report(pricing pricingConfig) *costReport {
+	uptime := time.Since(u.started)
+	factor := float64(hoursPerMonth*time.Hour) / float64(uptime)
+	line := func(count int64, unitPrice, unit float64) costLine {
+		monthly := int64(float64(count) * factor)
+		return costLine{Count: count, MonthlyCount: monthly, EstimatedMonthly: float64(monthly) / unit * unitPrice}
+	}
+	report := &costReport{
+		Since:         u.started,
+		UptimeSeconds: int64(uptime.Seconds()),
+		Get:           line(atomic.LoadInt64(&u.gets), aws.Float64Value(pricing.GetPer1000), 1000),
+		Put:           line(atomic.LoadInt64(&u.puts), aws.Float64Value(pricing.PutPer1000), 1000),
+		List:          line(atomic.LoadInt64(&u.lists), aws.Float64Value(pricing.ListPer1000), 1000),
+		Transfer:      line(atomic.LoadInt64(&u.bytesOut), aws.Float64Value(pricing.TransferPerGB), 1<<30),
+		BytesIn:       atomic.LoadInt64(&u.bytesIn),
+	}
+	report.EstimatedMonthly = report.Get.EstimatedMonthly + report.Put.EstimatedMonthly + report.List.EstimatedMonthly + report.Transfer.EstimatedMonthly
+	return report
+}
+
+// Serve the cost estimation report
+func serveCost(c *gin.Context) {
+	c.JSON(http.StatusOK, usage.report(configOf(c).Pricing))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestCountRequest(t *testing.T) {
+	tests := []struct {
+		operation         string
+		gets, puts, lists int64
+	}{
+		{"GetObject", 1, 0, 0},
+		{"ListObjectsV2", 0, 0, 1},
+		{"PutObject", 0, 1, 0},
+		{"DeleteObject", 0, 0, 0},
+		{"DeleteObjects", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			u := &usageCounters{}
+			u.countRequest(&request.Request{Operation: &request.Operation{Name: tt.operation}})
+			if u.gets != tt.gets || u.puts != tt.puts || u.lists != tt.lists {
+				t.Errorf("gets, puts, lists = %d, %d, %d, want %d, %d, %d", u.gets, u.puts, u.lists, tt.gets, tt.puts, tt.lists)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+// AWS credentials config type, the default credential chain (environment, shared files,
+// instance or task role) is used when nothing is set
+type credentialsConfig struct {
+	// Static access key
+	AccessKeyID     string `json:"accessKeyId" yaml:"accessKeyId" toml:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey" yaml:"secretAccessKey" toml:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken" yaml:"sessionToken" toml:"sessionToken"`
+	// Profile of the shared credentials and config files (~/.aws/credentials and ~/.aws/config)
+	Profile string `json:"profile" yaml:"profile" toml:"profile"`
+	// Role assumed with the credentials above, or those of the default chain
+	RoleARN    string `json:"roleArn" yaml:"roleArn" toml:"roleArn"`
+	ExternalID string `json:"externalId" yaml:"externalId" toml:"externalId"`
+	// Name of the role sessions, shown in CloudTrail
+	RoleSessionName string `json:"roleSessionName" yaml:"roleSessionName" toml:"roleSessionName"`
+	// Lifetime of the role credentials, from 15 minutes to the maximum session duration of the role
+	RoleDuration duration `json:"roleDuration" yaml:"roleDuration" toml:"roleDuration"`
+}
+
+// Bounds of the role sessions duration accepted by STS
+const (
+	minRoleDuration = 15 * time.Minute
+	maxRoleDuration = 12 * time.Hour
+)
+
+// Check that the credential settings are consistent
+func (cfg credentialsConfig) validate() error {
+	if (cfg.AccessKeyID == "") != (cfg.SecretAccessKey == "") {
+		return fmt.Errorf("credentials need both accessKeyId and secretAccessKey")
+	}
+	if cfg.AccessKeyID != "" && cfg.Profile != "" {
+		return fmt.Errorf("credentials cannot have both an access key and a profile")
+	}
+	if cfg.SessionToken != "" && cfg.AccessKeyID == "" {
+		return fmt.Errorf("credentials sessionToken needs an access key")
+	}
+	if (cfg.ExternalID != "" || cfg.RoleSessionName != "" || cfg.RoleDuration.Duration != 0) && cfg.RoleARN == "" {
+		return fmt.Errorf("credentials externalId, roleSessionName and roleDuration need a roleArn")
+	}
+	if d := cfg.RoleDuration.Duration; d != 0 && (d < minRoleDuration || d > maxRoleDuration) {
+		return fmt.Errorf("invalid credentials roleDuration %s, must be between %s and %s", d, minRoleDuration, maxRoleDuration)
+	}
+	return nil
+}
+
+// Print the credential settings without the secrets, e.g. in the debug logs
+func (cfg credentialsConfig) String() string {
+	secret := ""
+	if cfg.SecretAccessKey != "" {
+		secret = "***"
+	}
+	return fmt.Sprintf("{%s %s %s %s %s %s %s}", cfg.AccessKeyID, secret, cfg.Profile, cfg.RoleARN, cfg.ExternalID, cfg.RoleSessionName, cfg.RoleDuration)
+}
+
+// Create the AWS session of the configured credentials
+func newAWSSession(cfg credentialsConfig, region string) (*session.Session, error) {
+	opts := session.Options{Config: aws.Config{Region: aws.String(region)}, Profile: cfg.Profile}
+	if cfg.Profile != "" {
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	if cfg.AccessKeyID != "" {
+		opts.Config.Credentials = credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RoleARN == "" {
+		return sess, nil
+	}
+	// The role credentials are refreshed with the base credentials before they expire, so that
+	// the requests in progress never use expired credentials
+	provider := &stscreds.AssumeRoleProvider{
+		Client:          sts.New(sess, &aws.Config{STSRegionalEndpoint: endpoints.RegionalSTSEndpoint}),
+		RoleARN:         cfg.RoleARN,
+		RoleSessionName: cfg.RoleSessionName,
+		Duration:        cfg.RoleDuration.orDefault(time.Hour),
+	}
+	if provider.RoleSessionName == "" {
+		provider.RoleSessionName = "s3webserver"
+	}
+	if cfg.ExternalID != "" {
+		provider.ExternalID = aws.String(cfg.ExternalID)
+	}
+	provider.ExpiryWindow = provider.Duration / 10
+	if provider.ExpiryWindow > 5*time.Minute {
+		provider.ExpiryWindow = 5 * time.Minute
+	}
+	return sess.Copy(&aws.Config{Credentials: credentials.NewCredentials(&roleProvider{provider})}), nil
+}
+
+// Provider of the role credentials logging the refreshes
+type roleProvider struct {
+	*stscreds.AssumeRoleProvider
+}
+
+func (p *roleProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(aws.BackgroundContext())
+}
+
+func (p *roleProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	value, err := p.AssumeRoleProvider.RetrieveWithContext(ctx)
+	if err != nil {
+		log.Warnf("Unable to assume the role %s: %v", p.RoleARN, err)
+		return value, err
+	}
+	log.Debugf("Assumed the role %s, credentials refreshed before %s", p.RoleARN, p.ExpiresAt().Format(time.RFC3339))
+	return value, nil
+}
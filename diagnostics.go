@@ -0,0 +1,255 @@
+package main
+
+import (
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Interval between two samples of the bucket counters
+const throughputSampleInterval = 10 * time.Second
+
+// Number of samples kept, the throughput is measured over the last minute
+const throughputSamples = 7
+
+// Open connections of the TCP listener by state
+type connectionStates struct {
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+// Open connections of the server
+var connections = &connectionStates{states: map[net.Conn]http.ConnState{}}
+
+// Track the state of a connection, the http.Server ConnState hook
+func (cs *connectionStates) track(conn net.Conn, state http.ConnState) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if state == http.StateClosed || state == http.StateHijacked {
+		delete(cs.states, conn)
+	} else {
+		cs.states[conn] = state
+	}
+}
+
+// Connection counts type
+type connectionCounts struct {
+	Open   int `json:"open"`
+	Active int `json:"active"`
+	Idle   int `json:"idle"`
+}
+
+// Count the open connections by state, the new ones are active
+func (cs *connectionStates) counts() connectionCounts {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	counts := connectionCounts{Open: len(cs.states)}
+	for _, state := range cs.states {
+		if state == http.StateIdle {
+			counts.Idle++
+		} else {
+			counts.Active++
+		}
+	}
+	return counts
+}
+
+// Traffic counters of a bucket
+type bucketCounters struct {
+	requests int64
+	bytesIn  int64
+	bytesOut int64
+}
+
+// Counter values at a time
+type bucketSample struct {
+	at                          time.Time
+	requests, bytesIn, bytesOut int64
+}
+
+// Traffic of the buckets served, with the recent samples of the counters
+type bucketTraffic struct {
+	mu       sync.Mutex
+	counters map[string]*bucketCounters
+	samples  map[string][]bucketSample
+}
+
+// Traffic of the buckets since the server started
+var traffic = &bucketTraffic{counters: map[string]*bucketCounters{}, samples: map[string][]bucketSample{}}
+
+// Get the counters of a bucket
+func (t *bucketTraffic) bucket(name string) *bucketCounters {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counters, ok := t.counters[name]
+	if !ok {
+		counters = &bucketCounters{}
+		t.counters[name] = counters
+		t.samples[name] = []bucketSample{{at: time.Now()}}
+	}
+	return counters
+}
+
+// Sample the counters of the buckets, the oldest sample is dropped
+func (t *bucketTraffic) sample() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for name, counters := range t.counters {
+		samples := append(t.samples[name], bucketSample{
+			at:       now,
+			requests: atomic.LoadInt64(&counters.requests),
+			bytesIn:  atomic.LoadInt64(&counters.bytesIn),
+			bytesOut: atomic.LoadInt64(&counters.bytesOut),
+		})
+		if len(samples) > throughputSamples {
+			samples = samples[1:]
+		}
+		t.samples[name] = samples
+	}
+}
+
+// Sample the bucket counters in the background
+func (t *bucketTraffic) run() {
+	for range time.Tick(throughputSampleInterval) {
+		t.sample()
+	}
+}
+
+// Bucket throughput type, the rates are per second over the last minute
+type bucketThroughput struct {
+	Requests          int64   `json:"requests"`
+	BytesIn           int64   `json:"bytesIn"`
+	BytesOut          int64   `json:"bytesOut"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	BytesInPerSecond  float64 `json:"bytesInPerSecond"`
+	BytesOutPerSecond float64 `json:"bytesOutPerSecond"`
+}
+
+// Get the throughput of the buckets
+func (t *bucketTraffic) throughput() map[string]bucketThroughput {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	result := map[string]bucketThroughput{}
+	for name, counters := range t.counters {
+		tp := bucketThroughput{
+			Requests: atomic.LoadInt64(&counters.requests),
+			BytesIn:  atomic.LoadInt64(&counters.bytesIn),
+			BytesOut: atomic.LoadInt64(&counters.bytesOut),
+		}
+		if samples := t.samples[name]; len(samples) > 0 {
+			if elapsed := now.Sub(samples[0].at).Seconds(); elapsed > 0 {
+				tp.RequestsPerSecond = float64(tp.Requests-samples[0].requests) / elapsed
+				tp.BytesInPerSecond = float64(tp.BytesIn-samples[0].bytesIn) / elapsed
+				tp.BytesOutPerSecond = float64(tp.BytesOut-samples[0].bytesOut) / elapsed
+			}
+		}
+		result[name] = tp
+	}
+	return result
+}
+
+// Request body counting the bytes read
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
+
+// Count the requests and the bytes of the bucket of the object requests
+func trafficMiddleware(c *gin.Context) {
+	path := strings.TrimPrefix(c.Request.URL.Path, "/")
+	if isReservedPath(path) || isHealthPath(path) {
+		return
+	}
+	bucket, _ := configOf(c).resolveObject(c.Request.Host, path)
+	counters := traffic.bucket(bucket)
+	atomic.AddInt64(&counters.requests, 1)
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		c.Request.Body = countingBody{ReadCloser: c.Request.Body, n: &counters.bytesIn}
+	}
+	c.Next()
+	if size := c.Writer.Size(); size > 0 {
+		atomic.AddInt64(&counters.bytesOut, int64(size))
+	}
+}
+
+// Heap statistics type
+type heapStats struct {
+	Alloc        uint64 `json:"alloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapIdle     uint64 `json:"heapIdle"`
+	HeapReleased uint64 `json:"heapReleased"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"numGC"`
+	// Duration of the last garbage collection pause
+	LastPauseMs float64 `json:"lastPauseMs"`
+}
+
+// Runtime statistics type
+type runtimeStats struct {
+	UptimeSeconds    int64                       `json:"uptimeSeconds"`
+	Goroutines       int                         `json:"goroutines"`
+	InflightRequests int64                       `json:"inflightRequests"`
+	Connections      connectionCounts            `json:"connections"`
+	Heap             *heapStats                  `json:"heap,omitempty"`
+	Buckets          map[string]bucketThroughput `json:"buckets"`
+}
+
+// Collect the runtime statistics, with the heap ones if asked as they stop the world briefly
+func collectStats(withHeap bool) runtimeStats {
+	stats := runtimeStats{
+		UptimeSeconds:    int64(time.Since(usage.started).Seconds()),
+		Goroutines:       runtime.NumGoroutine(),
+		InflightRequests: drain.active(),
+		Connections:      connections.counts(),
+		Buckets:          traffic.throughput(),
+	}
+	if withHeap {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		stats.Heap = &heapStats{
+			Alloc:        m.Alloc,
+			HeapInuse:    m.HeapInuse,
+			HeapIdle:     m.HeapIdle,
+			HeapReleased: m.HeapReleased,
+			HeapObjects:  m.HeapObjects,
+			Sys:          m.Sys,
+			NumGC:        m.NumGC,
+			LastPauseMs:  float64(m.PauseNs[(m.NumGC+255)%256]) / float64(time.Millisecond),
+		}
+	}
+	return stats
+}
+
+// Serve the runtime statistics
+func serveStats(c *gin.Context) {
+	c.JSON(http.StatusOK, collectStats(true))
+}
+
+// Serve the expvar variables, the runtime memstats and the server statistics
+func serveExpvar(c *gin.Context) {
+	expvar.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+// Publish the server statistics in expvar, the heap ones are already in memstats, and start sampling
+// the bucket traffic
+func startDiagnostics() {
+	expvar.Publish("s3webserver", expvar.Func(func() interface{} { return collectStats(false) }))
+	go traffic.run()
+}
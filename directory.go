@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Directory redirect modes
+const (
+	// Redirect /foo to /foo/ when /foo is missing but /foo/ has an index page
+	directoryRedirectAdd = "add"
+	// Redirect /foo/ to /foo when /foo/ has no index page but /foo exists
+	directoryRedirectRemove = "remove"
+)
+
+// Context key of the request path before the index page is appended
+const ctxOriginalPath = "originalPath"
+
+// Check if an object exists
+func objectExists(c *gin.Context, bucket, key string) bool {
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	defer cancel()
+	client, target := readTarget(c, bucket)
+	_, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: target, Key: aws.String(key)})
+	return err == nil
+}
+
+// Redirect a request for a missing object to its directory form, or the other way round,
+// according to the configuration. Returns true if the redirect response has been written.
+func redirectDirectory(c *gin.Context, bucket, key string) bool {
+	homepage := configOf(c).Homepage
+	path := c.GetString(ctxOriginalPath)
+	if homepage == "" || path == "" || path == "/" {
+		return false
+	}
+	var target, targetKey string
+	switch configOf(c).DirectoryRedirect {
+	case directoryRedirectAdd:
+		if strings.HasSuffix(path, "/") {
+			return false
+		}
+		target, targetKey = path+"/", key+"/"+homepage
+	case directoryRedirectRemove:
+		if !strings.HasSuffix(path, "/") {
+			return false
+		}
+		target, targetKey = strings.TrimSuffix(path, "/"), strings.TrimSuffix(strings.TrimSuffix(key, homepage), "/")
+	default:
+		return false
+	}
+	if targetKey == "" || !objectExists(c, bucket, targetKey) {
+		return false
+	}
+	target = escapePath(target)
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+	c.Redirect(http.StatusMovedPermanently, target)
+	return true
+}
+
+// Redirect to the location of the x-amz-website-redirect-location object metadata, like S3 static
+// website hosting does. Returns true if the redirect response has been written.
+func redirectWebsiteLocation(c *gin.Context, location *string) bool {
+	if aws.StringValue(location) == "" {
+		return false
+	}
+	c.Redirect(http.StatusMovedPermanently, *location)
+	return true
+}
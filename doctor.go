@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Maximum clock skew accepted by S3 is 15 minutes, warn well before
+const maxClockSkew = 5 * time.Minute
+
+// Doctor check type, returns a detail on success
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// Run the diagnostics of the configuration and the S3 access, returns the process exit code
+func runDoctor(configFile string, args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	readOnly := flags.Bool("read-only", false, "`Skip` the upload and delete probes")
+	flags.Parse(args)
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		fmt.Printf("[FAIL] configuration: %v\n", err)
+		return 1
+	}
+	fmt.Printf("[PASS] configuration: %s, bucket %s in %s\n", configFile, config.S3bucket, config.AwsRegion)
+	currentConfig.Store(config)
+	if err := setupAWS(config); err != nil {
+		fmt.Printf("[FAIL] credentials: %v\n", err)
+		return 1
+	}
+
+	checks := []doctorCheck{{"credentials", doctorCredentials}}
+	for _, bucket := range configuredBuckets(config) {
+		checks = append(checks, doctorBucketChecks(bucket, *readOnly)...)
+	}
+	for _, rule := range config.Encryption {
+		if rule.KMSKeyID != "" {
+			checks = append(checks, doctorCheck{"kms key " + rule.KMSKeyID, doctorKMS(rule.KMSKeyID)})
+		}
+	}
+
+	return runDoctorChecks(checks)
+}
+
+// Run the checks and print the report, the configuration is the first check passed.
+// Returns the process exit code.
+func runDoctorChecks(checks []doctorCheck) int {
+	failed := 0
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+		detail, err := check.run(ctx)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n", check.name, err)
+		} else {
+			fmt.Printf("[PASS] %s: %s\n", check.name, detail)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks)+1)
+		return 1
+	}
+	fmt.Printf("All %d checks passed\n", len(checks)+1)
+	return 0
+}
+
+// Check that the credential chain resolves
+func doctorCredentials(ctx context.Context) (string, error) {
+	creds, err := s3Session.Config.Credentials.Get()
+	if err != nil {
+		return "", fmt.Errorf("no usable AWS credentials: %v", err)
+	}
+	return "resolved by " + creds.ProviderName, nil
+}
+
+// Get the endpoint serving a bucket, as the SDK resolves it
+func bucketEndpoint(bucket string) (*url.URL, error) {
+	req, _ := s3Session.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err := req.Build(); err != nil {
+		return nil, err
+	}
+	return &url.URL{Scheme: req.HTTPRequest.URL.Scheme, Host: req.HTTPRequest.URL.Host, Path: "/"}, nil
+}
+
+// Checks of a bucket: endpoint resolution and reachability, clock and permission probes
+func doctorBucketChecks(bucket string, readOnly bool) []doctorCheck {
+	var firstKey *string
+	probeKey := ".s3webserver-doctor-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	checks := []doctorCheck{
+		{"dns " + bucket, func(ctx context.Context) (string, error) {
+			endpoint, err := bucketEndpoint(bucket)
+			if err != nil {
+				return "", err
+			}
+			addrs, err := net.DefaultResolver.LookupHost(ctx, endpoint.Hostname())
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s resolves to %v", endpoint.Hostname(), addrs), nil
+		}},
+		{"tls and clock " + bucket, func(ctx context.Context) (string, error) {
+			endpoint, err := bucketEndpoint(bucket)
+			if err != nil {
+				return "", err
+			}
+			req, _ := http.NewRequest(http.MethodHead, endpoint.String(), nil)
+			resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+			if err != nil {
+				return "", err
+			}
+			resp.Body.Close()
+			transport := "TLS certificate valid"
+			if endpoint.Scheme == "http" {
+				transport = "reachable without TLS"
+			}
+			date, err := http.ParseTime(resp.Header.Get("Date"))
+			if err != nil {
+				return transport + ", no Date header to check the clock", nil
+			}
+			skew := time.Since(date)
+			if skew < -maxClockSkew || skew > maxClockSkew {
+				return "", fmt.Errorf("local clock is %s off the S3 clock, synchronize it (NTP)", skew.Round(time.Second))
+			}
+			return fmt.Sprintf("%s, clock skew %s", transport, skew.Round(time.Second)), nil
+		}},
+		{"s3:HeadBucket " + bucket, doctorHeadBucket(bucket)},
+		{"s3:ListBucket " + bucket, func(ctx context.Context) (string, error) {
+			list, err := s3Session.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), MaxKeys: aws.Int64(1)})
+			if err != nil {
+				return "", preflightError(bucket, currentConfig.Load().AwsRegion, "ListObjectsV2", "s3:ListBucket", err)
+			}
+			if len(list.Contents) == 0 {
+				return "bucket is empty", nil
+			}
+			firstKey = list.Contents[0].Key
+			return "objects can be listed", nil
+		}},
+		{"s3:GetObject " + bucket, func(ctx context.Context) (string, error) {
+			if firstKey == nil {
+				return "skipped, no object to read", nil
+			}
+			_, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: firstKey})
+			if err != nil {
+				return "", preflightError(bucket, currentConfig.Load().AwsRegion, "HeadObject", "s3:GetObject", err)
+			}
+			return "objects can be read", nil
+		}},
+	}
+	if readOnly {
+		return checks
+	}
+	return append(checks,
+		doctorCheck{"s3:PutObject " + bucket, func(ctx context.Context) (string, error) {
+			_, err := s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(probeKey), Body: bytes.NewReader(nil)})
+			if err != nil {
+				return "", preflightError(bucket, currentConfig.Load().AwsRegion, "PutObject", "s3:PutObject", err)
+			}
+			return "objects can be uploaded (probe " + probeKey + ")", nil
+		}},
+		doctorCheck{"s3:DeleteObject " + bucket, func(ctx context.Context) (string, error) {
+			_, err := s3Session.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(probeKey)})
+			if err != nil {
+				return "", preflightError(bucket, currentConfig.Load().AwsRegion, "DeleteObject", "s3:DeleteObject", err)
+			}
+			return "objects can be deleted", nil
+		}})
+}
+
+// Check that a bucket exists and is reachable
+func doctorHeadBucket(bucket string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		_, err := s3Session.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		if err != nil {
+			return "", preflightError(bucket, configFrom(ctx).AwsRegion, "HeadBucket", "", err)
+		}
+		return "bucket exists and is reachable in " + configFrom(ctx).AwsRegion, nil
+	}
+}
+
+// Check that a KMS key can generate data keys
+func doctorKMS(keyID string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		_, err := kmsSession.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{KeyId: aws.String(keyID), KeySpec: aws.String(kms.DataKeySpecAes256)})
+		if err != nil {
+			return "", fmt.Errorf("cannot generate a data key, grant kms:GenerateDataKey and kms:Decrypt: %v", err)
+		}
+		return "data keys can be generated", nil
+	}
+}
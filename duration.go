@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration read from the configuration as a string like "1.5s" or "300ms"
+type duration struct {
+	time.Duration
+}
+
+// Decode a duration from a toml or json string
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Decode a duration from a yaml string
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// Encode a duration as a string
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Get the duration or a default value if not set
+func (d duration) orDefault(defaultVal time.Duration) time.Duration {
+	if d.Duration <= 0 {
+		return defaultVal
+	}
+	return d.Duration
+}
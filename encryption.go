@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Object metadata of the encrypted objects
+const (
+	metaEncryption      = "s3ws-encryption"
+	metaKeyID           = "s3ws-key-id"
+	metaDataKey         = "s3ws-data-key"
+	metaPlaintextLength = "s3ws-plaintext-length"
+	encryptionAESGCM    = "AES-GCM"
+)
+
+// KMS client, set up when a rule uses a KMS key
+var kmsSession *kms.KMS
+
+// Encryption rule type, the objects uploaded under the prefix are encrypted before being stored
+type encryptionRule struct {
+	// Prefix of the keys stored in S3, the keyPrefix included
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	// Base64 encoded AES key (16, 24 or 32 bytes)
+	Key string `json:"key" yaml:"key" toml:"key"`
+	// KMS key generating a data key for each object
+	KMSKeyID string `json:"kmsKeyId" yaml:"kmsKeyId" toml:"kmsKeyId"`
+	key      []byte
+}
+
+// Get the id of the rule key stored with the objects, to find it back on decryption
+func (rule *encryptionRule) keyID() string {
+	if rule.KMSKeyID != "" {
+		return "kms"
+	}
+	sum := sha256.Sum256(rule.key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Check the encryption rules and decode their keys
+func validateEncryption(rules []encryptionRule) error {
+	for i := range rules {
+		rule := &rules[i]
+		if (rule.Key == "") == (rule.KMSKeyID == "") {
+			return fmt.Errorf("encryption rule for prefix '%s' needs either a key or a kmsKeyId", rule.Prefix)
+		}
+		if rule.Key == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(rule.Key)
+		if err != nil {
+			return fmt.Errorf("encryption key for prefix '%s' is not valid base64: %v", rule.Prefix, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return fmt.Errorf("encryption key for prefix '%s' is invalid: %v", rule.Prefix, err)
+		}
+		rule.key = key
+	}
+	return nil
+}
+
+// Check if a rule uses KMS
+func usesKMS(rules []encryptionRule) bool {
+	for _, rule := range rules {
+		if rule.KMSKeyID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the encryption rule of a key, the longest matching prefix wins, nil if the key is stored in clear
+func (config *webConfig) encryptionFor(key string) *encryptionRule {
+	var found *encryptionRule
+	rules := config.Encryption
+	for i := range rules {
+		if strings.HasPrefix(key, rules[i].Prefix) && (found == nil || len(rules[i].Prefix) > len(found.Prefix)) {
+			found = &rules[i]
+		}
+	}
+	return found
+}
+
+// Get an object metadata value, the SDK canonicalizes the names returned by S3
+func metadataValue(metadata map[string]*string, name string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
+
+// Check if an object has been encrypted by the server
+func isEncrypted(metadata map[string]*string) bool {
+	return metadataValue(metadata, metaEncryption) != ""
+}
+
+// Get the plaintext length of an encrypted object
+func plaintextLength(metadata map[string]*string) (int64, bool) {
+	length, err := strconv.ParseInt(metadataValue(metadata, metaPlaintextLength), 10, 64)
+	return length, err == nil
+}
+
+// Seal a value with AES-GCM, the nonce is put before the ciphertext
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open a value sealed by sealAESGCM
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted object is truncated")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// Encrypt an object body, returns the ciphertext and the metadata to store with it
+func (rule *encryptionRule) encrypt(ctx context.Context, plaintext []byte) ([]byte, map[string]*string, error) {
+	metadata := map[string]*string{
+		metaEncryption:      aws.String(encryptionAESGCM),
+		metaKeyID:           aws.String(rule.keyID()),
+		metaPlaintextLength: aws.String(strconv.Itoa(len(plaintext))),
+	}
+	key := rule.key
+	if rule.KMSKeyID != "" {
+		dataKey, err := kmsSession.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{KeyId: aws.String(rule.KMSKeyID), KeySpec: aws.String(kms.DataKeySpecAes256)})
+		if err != nil {
+			return nil, nil, err
+		}
+		key = dataKey.Plaintext
+		metadata[metaDataKey] = aws.String(base64.StdEncoding.EncodeToString(dataKey.CiphertextBlob))
+	}
+	ciphertext, err := sealAESGCM(key, plaintext)
+	return ciphertext, metadata, err
+}
+
+// Decrypt an object body encrypted by the server
+func decryptObject(ctx context.Context, metadata map[string]*string, body io.Reader) ([]byte, error) {
+	if algorithm := metadataValue(metadata, metaEncryption); algorithm != encryptionAESGCM {
+		return nil, fmt.Errorf("unknown object encryption %s", algorithm)
+	}
+	var key []byte
+	if dataKey := metadataValue(metadata, metaDataKey); dataKey != "" {
+		blob, err := base64.StdEncoding.DecodeString(dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data key of encrypted object: %v", err)
+		}
+		if kmsSession == nil {
+			return nil, fmt.Errorf("object is encrypted with a KMS data key but no KMS key is configured")
+		}
+		out, err := kmsSession.DecryptWithContext(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+		if err != nil {
+			return nil, err
+		}
+		key = out.Plaintext
+	} else {
+		keyID := metadataValue(metadata, metaKeyID)
+		for _, rule := range configFrom(ctx).Encryption {
+			if rule.KMSKeyID == "" && rule.keyID() == keyID {
+				key = rule.key
+				break
+			}
+		}
+		if key == nil {
+			return nil, fmt.Errorf("no configured key %s to decrypt the object", keyID)
+		}
+	}
+	sealed, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return openAESGCM(key, sealed)
+}
+
+// Set the headers of an encrypted object response, which is always served as a whole
+func setEncryptedHeaders(header http.Header, metadata map[string]*string) {
+	if length, ok := plaintextLength(metadata); ok {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	header.Set("Accept-Ranges", "none")
+}
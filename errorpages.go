@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Check the error page status codes
+func validateErrorPages(pages map[string]string) error {
+	for code, key := range pages {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 400 || status > 599 {
+			return fmt.Errorf("invalid errorPages status %s, must be between 400 and 599", code)
+		}
+		if strings.TrimPrefix(key, "/") == "" {
+			return fmt.Errorf("missing errorPages key of status %s", code)
+		}
+	}
+	return nil
+}
+
+// Serve the error page of a status from the bucket, instead of the generated error body.
+// Returns false if no page is configured or it cannot be fetched.
+func serveErrorPage(c *gin.Context, status int) bool {
+	page := strings.TrimPrefix(configOf(c).ErrorPages[strconv.Itoa(status)], "/")
+	if page == "" || c.Request.Method == http.MethodHead || (breaker != nil && breaker.isOpen()) {
+		return false
+	}
+	bucket, key := configOf(c).resolveObject(c.Request.Host, page)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		requestLog(c).Warnf("Unable to get the error page %s: %v", page, err)
+		return false
+	}
+	defer resp.Body.Close()
+	w := c.Writer
+	w.Header().Set("Content-Type", configOf(c).objectContentType(key, resp.ContentType))
+	if resp.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*resp.ContentLength, 10))
+	}
+	if encoding := aws.StringValue(resp.ContentEncoding); encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.WriteHeader(status)
+	io.Copy(w, resp.Body)
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gin-gonic/gin"
+)
+
+// Levels of error detail exposed to clients
+const (
+	// Full error messages, including the AWS error messages and causes
+	errorDetailFull = "full"
+	// Only the AWS error code
+	errorDetailCode = "code"
+	// Only generic messages
+	errorDetailGeneric = "generic"
+)
+
+// Error response body type
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// HTML page of an error response
+const errorPage = `<!DOCTYPE html>
+<html>
+<head><title>%d %s</title></head>
+<body>
+<h1>%d %s</h1>
+<p>%s</p>
+</body>
+</html>
+`
+
+// Error response formats
+const (
+	errorFormatText = "text"
+	errorFormatHTML = "html"
+	errorFormatJSON = "json"
+)
+
+// Choose the error response format from the request path and Accept header
+func errorFormat(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/_api/") {
+		return errorFormatJSON
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return errorFormatJSON
+	case strings.Contains(accept, "text/html"):
+		return errorFormatHTML
+	default:
+		return errorFormatText
+	}
+}
+
+// Write an error response in the format expected by the client
+func writeError(c *gin.Context, status int, code, message, requestID string) {
+	c.Set(ctxErrorCode, code)
+	w := c.Writer
+	w.Header().Del("Content-Length")
+	if status == http.StatusNotModified {
+		// A 304 response never has a body
+		w.WriteHeader(status)
+		return
+	}
+	format := errorFormat(c.Request)
+	if format != errorFormatJSON && serveErrorPage(c, status) {
+		return
+	}
+	switch format {
+	case errorFormatJSON:
+		c.JSON(status, errorResponse{Code: code, Message: message, RequestID: requestID})
+	case errorFormatHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		text := http.StatusText(status)
+		fmt.Fprintf(w, errorPage, status, text, status, text, html.EscapeString(message))
+	default:
+		http.Error(w, message, status)
+	}
+}
+
+// Write an internal error response, redacting the details according to the configuration
+func writeInternalError(c *gin.Context, code, detail, requestID string) {
+	message := "An internal error occurred"
+	switch configOf(c).ErrorDetail {
+	case errorDetailFull:
+		message += ": " + detail
+	case errorDetailCode:
+		message += ": " + code
+	default:
+		code = "InternalError"
+	}
+	if configOf(c).ErrorDetail != errorDetailFull {
+		requestLog(c).Errorf("Internal error: %s", detail)
+	}
+	writeError(c, http.StatusInternalServerError, code, message, requestID)
+}
+
+// Check if the error is a missing S3 object
+func isNotFoundError(err error) bool {
+	awsError, ok := err.(awserr.Error)
+	return ok && (awsError.Code() == "NoSuchKey" || awsError.Code() == "NotFound")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Extension hooks the request and response phases of the server.
+// A Go plugin (built with -buildmode=plugin against the same gin version) must export
+// a symbol named "Extension" implementing this interface.
+type Extension interface {
+	// Name of the extension, used in logs
+	Name() string
+	// OnRequest is called before the request is handled. Returning false stops the processing,
+	// the extension is then responsible for writing the response.
+	OnRequest(c *gin.Context) bool
+	// OnResponse is called with the response status before the response headers are written,
+	// so headers can still be added or changed.
+	OnResponse(c *gin.Context, status int)
+}
+
+// Load extensions from the Go plugin files
+func loadExtensions(paths []string) ([]Extension, error) {
+	var exts []Extension
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open plugin %s", path)
+		}
+		sym, err := p.Lookup("Extension")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load plugin %s", path)
+		}
+		ext, ok := sym.(Extension)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s: symbol Extension does not implement the extension interface", path)
+		}
+		log.Infof("Loaded extension %s from %s", ext.Name(), path)
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// Response writer calling the extensions response hooks before the headers are written
+type extensionWriter struct {
+	gin.ResponseWriter
+	c      *gin.Context
+	exts   []Extension
+	hooked bool
+}
+
+// Call the response hooks once, before anything is sent to the client
+func (w *extensionWriter) beforeWrite() {
+	if w.hooked || w.ResponseWriter.Written() {
+		return
+	}
+	w.hooked = true
+	for _, ext := range w.exts {
+		ext.OnResponse(w.c, w.ResponseWriter.Status())
+	}
+}
+
+func (w *extensionWriter) WriteHeaderNow() {
+	w.beforeWrite()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *extensionWriter) Write(data []byte) (int, error) {
+	w.beforeWrite()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *extensionWriter) WriteString(s string) (int, error) {
+	w.beforeWrite()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Middleware running the extensions hooks around the request
+func extensionMiddleware(exts []Extension) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, ext := range exts {
+			if !ext.OnRequest(c) {
+				requestLog(c).Debugf("Request stopped by extension %s", ext.Name())
+				c.Abort()
+				return
+			}
+		}
+		w := &extensionWriter{ResponseWriter: c.Writer, c: c, exts: exts}
+		c.Writer = w
+		c.Next()
+		// Responses without body are written by gin after the handlers
+		w.beforeWrite()
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+)
+
+// Get the archive format of an uploaded file from its name, "" if it is not an archive
+func extractFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// Check if an upload asks for its archives to be extracted with ?extract=true
+func extractRequested(c *gin.Context) bool {
+	extract, _ := strconv.ParseBool(c.Query("extract"))
+	return extract && configOf(c).Archives.Extract
+}
+
+// Error of an archive entry whose failure response has already been written
+var errResponseWritten = errors.New("response written")
+
+// Error of an archive over the limits or with an invalid entry, written as a response
+type extractError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *extractError) Error() string {
+	return e.message
+}
+
+// Serve a PUT of an archive with ?extract=true: its entries are stored under the directory of the path
+func serveExtract(c *gin.Context, filePath string) {
+	format := extractFormat(filePath)
+	if format == "" {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Only the .zip, .tar.gz and .tgz archives can be extracted", "")
+		return
+	}
+	dir := ""
+	if i := strings.LastIndex(filePath, "/"); i >= 0 {
+		dir = filePath[:i+1]
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	created, ok := extractArchive(ctx, c, dir, format, c.Request.Body)
+	if !ok {
+		return
+	}
+	requestLog(c).Infof("Extracted %d objects of %s", len(created), filePath)
+	c.JSON(http.StatusCreated, gin.H{"objects": created})
+}
+
+// Store the files of an archive under a directory (empty or ending with /), in archive order.
+// Returns false if the extraction failed and the response has been written; the files
+// stored before the failure are kept.
+func extractArchive(ctx context.Context, c *gin.Context, dir, format string, body io.Reader) ([]formObject, bool) {
+	config := configOf(c)
+	created := []formObject{}
+	var total int64
+	store := func(name string, size int64, r io.Reader) error {
+		cfg := config.Archives
+		total += size
+		if len(created) >= cfg.MaxObjects || (cfg.MaxSize > 0 && total > cfg.MaxSize) {
+			return &extractError{http.StatusRequestEntityTooLarge, "ArchiveTooLarge", "Archive over the extraction limits"}
+		}
+		name = path.Clean(strings.Replace(name, "\\", "/", -1))
+		if name == "." || strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+			return &extractError{http.StatusBadRequest, "InvalidRequest", "Invalid archive entry " + name}
+		}
+		objectPath := config.normalizeUploadKey(dir + name)
+		if config.isHiddenKey(objectPath) {
+			return &extractError{http.StatusBadRequest, "InvalidRequest", "Invalid archive entry " + name}
+		}
+		if !checkACL(c, objectPath) {
+			return errResponseWritten
+		}
+		bucket, key := config.resolveObject(c.Request.Host, objectPath)
+		counted := &countingReader{Reader: r}
+		params := &s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: counted, ContentType: aws.String(config.objectContentType(objectPath, nil))}
+		if err := applySSECustomer(c, &params.SSECustomerAlgorithm, &params.SSECustomerKey, &params.SSECustomerKeyMD5); err != nil {
+			writeSSECustomerError(c, err)
+			return errResponseWritten
+		}
+		config.applyServerSideEncryption(params.SSECustomerAlgorithm, &params.ServerSideEncryption, &params.SSEKMSKeyId, &params.BucketKeyEnabled)
+		resp, err := storeObject(ctx, params, size, nil)
+		usage.addBytesIn(counted.n)
+		if handleHTTPException(c, key, err) != nil {
+			return errResponseWritten
+		}
+		requestLog(c).Debugf("Archive entry %s stored as %s", name, key)
+		created = append(created, formObject{Path: "/" + objectPath, ETag: aws.StringValue(resp.ETag), VersionID: aws.StringValue(resp.VersionID), Size: counted.n})
+		return nil
+	}
+
+	var err error
+	if format == "zip" {
+		err = readZipEntries(body, store)
+	} else {
+		err = readTarGzEntries(body, store)
+	}
+	switch e := err.(type) {
+	case nil:
+		return created, true
+	case *extractError:
+		writeError(c, e.status, e.code, e.message, "")
+	default:
+		if err == errResponseWritten {
+			break
+		}
+		if isUploadTooLarge(err) {
+			writeUploadTooLarge(c)
+			break
+		}
+		writeError(c, http.StatusBadRequest, "InvalidArchive", "Invalid archive: "+err.Error(), "")
+	}
+	return nil, false
+}
+
+// Read the files of a zip archive. The zip directory is at the end of the archive,
+// so the archive is first written to a temporary file.
+func readZipEntries(body io.Reader, store func(name string, size int64, r io.Reader) error) error {
+	f, err := ioutil.TempFile("", "s3webserver-extract-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, body)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = store(file.Name, int64(file.UncompressedSize64), r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read the regular files of a gzipped tar archive, streamed from the body
+func readTarGzEntries(body io.Reader, store func(name string, size int64, r io.Reader) error) error {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if err := store(header.Name, header.Size, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+// Build a zip archive of files by name
+func testZip(t *testing.T, files ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range files {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(testContent))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExtractAccess(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket",
+		Archives: archiveConfig{Extract: true},
+		Auth: authConfig{OIDC: issuer.config(
+			oidcPermission{Prefix: "upload/", Write: []string{"staff"}},
+			oidcPermission{Prefix: "upload/secret/", Write: []string{"admin"}},
+		)},
+		ACL: []aclRule{{Pattern: "upload/locked/**", Methods: []string{"GET"}}},
+	})
+	tests := []struct {
+		name    string
+		files   []string
+		status  int
+		stored  []string
+		missing []string
+	}{
+		{"allowed entries", []string{"a.txt", "sub/b.txt"}, http.StatusCreated, []string{"upload/a.txt", "upload/sub/b.txt"}, nil},
+		{"entry denied by the oidc permissions", []string{"c.txt", "secret/d.txt"}, http.StatusForbidden, []string{"upload/c.txt"}, []string{"upload/secret/d.txt"}},
+		{"entry denied by the acl", []string{"locked/e.txt"}, http.StatusForbidden, nil, []string{"upload/locked/e.txt"}},
+		{"entry out of the directory", []string{"../f.txt"}, http.StatusBadRequest, nil, []string{"f.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestBody(router, http.MethodPut, "/upload/files.zip?extract=true", bearer(issuer.token(t, "staff")), testZip(t, tt.files...))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			for _, key := range tt.stored {
+				if _, ok := fake.get("bucket/" + key); !ok {
+					t.Errorf("%s not stored", key)
+				}
+			}
+			for _, key := range tt.missing {
+				if _, ok := fake.get("bucket/" + key); ok {
+					t.Errorf("%s stored, want it refused", key)
+				}
+			}
+		})
+	}
+}
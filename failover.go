@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Context key of the reads served by the replica buckets
+const ctxFailover = "failover"
+
+// Check the replica buckets serving the reads while the circuit is open
+func (cfg *circuitBreakerConfig) validate(config *webConfig) error {
+	if !cfg.Fallback.enabled() {
+		return nil
+	}
+	if !cfg.Enabled {
+		return fmt.Errorf("circuitBreaker fallback needs the circuitBreaker enabled")
+	}
+	return cfg.Fallback.validate(config)
+}
+
+// Replica buckets serving the reads while the circuit is open, nil if not configured
+var fallback *replicaTarget
+
+// Check if a request can be served from the replicas while the circuit is open: the object reads only,
+// the listings, archives, transforms and writes need the primary bucket
+func failoverAllowed(method string) bool {
+	return fallback != nil && (method == "GET" || method == "HEAD")
+}
+
+// Check if a request is served from the replicas
+func isFailingOver(c *gin.Context) bool {
+	return c.GetBool(ctxFailover)
+}
+
+// Get the S3 client and the bucket of an object read: the fallback ones while failing over, else the
+// region chosen among the read replicas
+func readTarget(c *gin.Context, bucket string) (*s3.S3, *string) {
+	if isFailingOver(c) {
+		if replica, ok := fallback.bucket(bucket); ok {
+			return fallback.client, aws.String(replica)
+		}
+	} else if replicas != nil {
+		target := replicas.target(c, bucket)
+		replica, _ := target.bucket(bucket)
+		return target.client, aws.String(replica)
+	}
+	return s3Session, aws.String(bucket)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+)
+
+// Object created by an upload
+type formObject struct {
+	Path      string `json:"path"`
+	ETag      string `json:"etag"`
+	VersionID string `json:"versionId,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// Serve a POST request with a multipart/form-data body, each file is stored under the request path.
+// The parts are streamed to S3 one after the other, the other form fields are ignored.
+func servePostS3Files(c *gin.Context, dir string) {
+	config := configOf(c)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "POST needs a multipart/form-data body", "")
+		return
+	}
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	ctx, cancel := s3Context(c.Request.Context(), config.Timeouts.Put)
+	defer cancel()
+
+	created := []formObject{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if isUploadTooLarge(err) {
+			writeUploadTooLarge(c)
+			return
+		}
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid multipart body: "+err.Error(), "")
+			return
+		}
+		name := path.Base(strings.Replace(part.FileName(), "\\", "/", -1))
+		if part.FileName() == "" || name == "." || name == "/" || name == ".." {
+			part.Close()
+			continue
+		}
+		if format := extractFormat(name); format != "" && extractRequested(c) {
+			objects, ok := extractArchive(ctx, c, dir, format, part)
+			part.Close()
+			if !ok {
+				return
+			}
+			created = append(created, objects...)
+			continue
+		}
+		objectPath := config.normalizeUploadKey(dir + name)
+		if config.isHiddenKey(objectPath) {
+			writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid file name "+name, "")
+			return
+		}
+		if !checkACL(c, objectPath) {
+			return
+		}
+		bucket, key := config.resolveObject(c.Request.Host, objectPath)
+		body := &countingReader{Reader: part}
+		params := &s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: body}
+		if contentType := part.Header.Get("Content-Type"); contentType != "" {
+			params.ContentType = aws.String(contentType)
+		}
+		if err := applySSECustomer(c, &params.SSECustomerAlgorithm, &params.SSECustomerKey, &params.SSECustomerKeyMD5); err != nil {
+			writeSSECustomerError(c, err)
+			return
+		}
+		config.applyServerSideEncryption(params.SSECustomerAlgorithm, &params.ServerSideEncryption, &params.SSEKMSKeyId, &params.BucketKeyEnabled)
+		resp, err := storeObject(ctx, params, -1, nil)
+		usage.addBytesIn(body.n)
+		part.Close()
+		if handleHTTPException(c, key, err) != nil {
+			return
+		}
+		requestLog(c).Debugf("Form upload of %s stored as %s", name, key)
+		created = append(created, formObject{Path: "/" + objectPath, ETag: aws.StringValue(resp.ETag), VersionID: aws.StringValue(resp.VersionID), Size: body.n})
+	}
+	if len(created) == 0 {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "No file in the form", "")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"objects": created})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response header rule config type, the Headers are set on the GET and HEAD responses of the
+// paths matching Pattern. An empty value removes the header.
+type headerRule struct {
+	// Glob pattern of the path without leading / ("static/*.js"), of the file name when it has
+	// no / ("*.html"), "dir/**" matches any depth
+	Pattern string            `json:"pattern" yaml:"pattern" toml:"pattern"`
+	Headers map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+}
+
+// Check the header rule patterns
+func validateHeaderRules(rules []headerRule) error {
+	for _, rule := range rules {
+		if err := validatePathPattern(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid headers pattern %s: %v", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// Check the syntax of a path glob pattern
+func validatePathPattern(pattern string) error {
+	_, err := path.Match(strings.TrimSuffix(pattern, "/**"), "")
+	return err
+}
+
+// Check if a header rule applies to a path
+func (rule headerRule) matches(p string) bool {
+	return matchPathPattern(rule.Pattern, p)
+}
+
+// Check if a path without leading / matches a glob pattern. A pattern without / matches the
+// file name, "dir/**" matches the paths under dir at any depth.
+func matchPathPattern(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		dir := strings.TrimSuffix(pattern, "/**")
+		for d := path.Dir(p); d != "." && d != "/"; d = path.Dir(d) {
+			if ok, _ := path.Match(dir, d); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// Set the headers of the rules matching a path, the later rules override the former ones
+func (config *webConfig) applyHeaderRules(header http.Header, p string) {
+	for _, rule := range config.Headers {
+		if !rule.matches(p) {
+			continue
+		}
+		for name, value := range rule.Headers {
+			if value == "" {
+				header.Del(name)
+			} else {
+				header.Set(name, value)
+			}
+		}
+	}
+}
+
+// Response writer setting the header rules just before the headers are sent, so that they
+// override the headers of the handler. The path is the one served, with the homepage.
+type headerRulesWriter struct {
+	gin.ResponseWriter
+	request *http.Request
+	applied bool
+}
+
+func (w *headerRulesWriter) apply() {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	if w.Status() < http.StatusBadRequest {
+		configFrom(w.request.Context()).applyHeaderRules(w.Header(), strings.TrimPrefix(w.request.URL.Path, "/"))
+	}
+}
+
+func (w *headerRulesWriter) WriteHeaderNow() {
+	w.apply()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *headerRulesWriter) Write(data []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *headerRulesWriter) WriteString(s string) (int, error) {
+	w.apply()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Set the response headers of the header rules on the GET and HEAD object responses
+func headerRulesMiddleware(c *gin.Context) {
+	p := strings.TrimPrefix(c.Request.URL.Path, "/")
+	if (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) || isReservedPath(p) {
+		return
+	}
+	w := &headerRulesWriter{ResponseWriter: c.Writer, request: c.Request}
+	c.Writer = w
+	c.Next()
+	if !w.Written() {
+		w.apply()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Paths of the liveness and readiness probes
+const (
+	livenessPath  = "healthz"
+	readinessPath = "readyz"
+)
+
+// Deadline of the readiness S3 checks
+const readinessTimeout = 2 * time.Second
+
+// Delay during which a readiness result is reused, so that frequent probes do not flood S3
+const readinessCacheDuration = 5 * time.Second
+
+// Check if a path (without leading /) is a probe endpoint, never authenticated
+func isHealthPath(path string) bool {
+	return path == livenessPath || path == readinessPath
+}
+
+// Result of the last readiness check
+type readiness struct {
+	mu      sync.Mutex
+	checked time.Time
+	errors  []string
+}
+
+// Readiness of the server
+var ready = &readiness{}
+
+// Check that the buckets are reachable, the result is reused for a few seconds
+func (r *readiness) check() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < readinessCacheDuration {
+		return r.errors
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+	var errors []string
+	if breaker != nil && breaker.isOpen() {
+		errors = append(errors, "circuit breaker is open")
+	} else {
+		for _, bucket := range configuredBuckets(currentConfig.Load()) {
+			_, err := s3Session.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				errors = append(errors, bucket+": "+errorCode(err))
+			}
+		}
+	}
+	if len(errors) > 0 && len(r.errors) == 0 {
+		log.Warnf("Server is not ready: %v", errors)
+	}
+	r.checked, r.errors = time.Now(), errors
+	return errors
+}
+
+// Serve the liveness probe, the process answers
+func serveLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Serve the readiness probe, S3 is reachable with the configured credentials and the server is not shutting down
+func serveReadiness(c *gin.Context) {
+	if drain.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	if errors := ready.check(); len(errors) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "errors": errors})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
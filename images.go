@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Largest number of pixels of a transformed source image, the larger ones would use too much memory
+const maxImagePixels = 50 << 20
+
+// Image transforms config type, the images are resized and converted on GET ?w=&h=&fit=&format=
+type imagesConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Largest width and height of a transformed image
+	MaxWidth  int `json:"maxWidth" yaml:"maxWidth" toml:"maxWidth"`
+	MaxHeight int `json:"maxHeight" yaml:"maxHeight" toml:"maxHeight"`
+	// Largest size (in bytes) of a source image
+	MaxSourceSize int64 `json:"maxSourceSize" yaml:"maxSourceSize" toml:"maxSourceSize"`
+	// Key prefix of the transformed images stored in s3bucket, never served directly; without it
+	// the transformed images are kept in the memoryCache or diskCache
+	CachePrefix string `json:"cachePrefix" yaml:"cachePrefix" toml:"cachePrefix"`
+	// Default JPEG quality, 1 to 100
+	Quality int `json:"quality" yaml:"quality" toml:"quality"`
+}
+
+// Set the image transforms defaults and check the values
+func (cfg *imagesConfig) validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxWidth <= 0 {
+		cfg.MaxWidth = 4096
+	}
+	if cfg.MaxHeight <= 0 {
+		cfg.MaxHeight = 4096
+	}
+	if cfg.MaxWidth > 16384 || cfg.MaxHeight > 16384 {
+		return fmt.Errorf("images maxWidth and maxHeight must be at most 16384")
+	}
+	if cfg.MaxSourceSize <= 0 {
+		cfg.MaxSourceSize = 25 << 20
+	}
+	if cfg.Quality == 0 {
+		cfg.Quality = 85
+	}
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		return fmt.Errorf("invalid images quality %d, must be between 1 and 100", cfg.Quality)
+	}
+	return nil
+}
+
+// Check if a key is a stored transformed image, which must not be served as an object
+func (cfg imagesConfig) hides(key string) bool {
+	return cfg.Enabled && cfg.CachePrefix != "" && strings.HasPrefix(key, cfg.CachePrefix)
+}
+
+// Content types of the transformed image formats
+var imageFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// Transforms running at once, they are CPU bound
+var imageTransforms = make(chan struct{}, runtime.NumCPU())
+
+// Transform of an image asked by a request
+type imageTransform struct {
+	width   int
+	height  int
+	fit     string
+	format  string
+	quality int
+}
+
+// Check if a GET asks for a transformed image
+func imageTransformRequested(c *gin.Context) bool {
+	if !configOf(c).Images.Enabled {
+		return false
+	}
+	for _, param := range []string{"w", "h", "fit", "format", "q"} {
+		if _, ok := c.GetQuery(param); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the transform of a request, returns an error message for the invalid parameters
+func parseImageTransform(c *gin.Context) (*imageTransform, string) {
+	cfg := configOf(c).Images
+	t := &imageTransform{fit: strings.ToLower(c.DefaultQuery("fit", "contain")), format: strings.ToLower(c.Query("format")), quality: cfg.Quality}
+	for _, dim := range []struct {
+		param string
+		value *int
+		max   int
+	}{{"w", &t.width, cfg.MaxWidth}, {"h", &t.height, cfg.MaxHeight}, {"q", &t.quality, 100}} {
+		if s := c.Query(dim.param); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > dim.max {
+				return nil, fmt.Sprintf("%s must be between 1 and %d", dim.param, dim.max)
+			}
+			*dim.value = n
+		}
+	}
+	if t.fit != "contain" && t.fit != "cover" && t.fit != "fill" {
+		return nil, "fit must be contain, cover or fill"
+	}
+	if t.format == "jpg" {
+		t.format = "jpeg"
+	}
+	if _, ok := imageFormats[t.format]; t.format != "" && !ok {
+		return nil, "format must be jpeg, png, gif or webp"
+	}
+	return t, ""
+}
+
+// Identifier of the transform of a source image version, also the ETag of the result
+func (t *imageTransform) id(bucket, key, etag string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%s/w%d-h%d-%s-q%d-%s", bucket, key, etag, t.width, t.height, t.fit, t.quality, t.format)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Resize an image, within the width and height (contain, never enlarged), covering them (cover,
+// centered crop) or stretched to them (fill). A missing dimension keeps the aspect ratio.
+func (t *imageTransform) apply(src image.Image) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	w, h := t.width, t.height
+	if w == 0 && h == 0 {
+		return src
+	}
+	if w == 0 {
+		w = (sw*h + sh/2) / sh
+	} else if h == 0 {
+		h = (sh*w + sw/2) / sw
+	}
+	srcRect := bounds
+	switch t.fit {
+	case "contain":
+		scale := float64(w) / float64(sw)
+		if s := float64(h) / float64(sh); s < scale {
+			scale = s
+		}
+		if scale >= 1 {
+			return src
+		}
+		w, h = int(float64(sw)*scale+0.5), int(float64(sh)*scale+0.5)
+	case "cover":
+		// Crop the source to the aspect ratio of the result
+		if sw*h > sh*w {
+			cw := (sh*w + h/2) / h
+			srcRect.Min.X += (sw - cw) / 2
+			srcRect.Max.X = srcRect.Min.X + cw
+		} else {
+			ch := (sw*h + w/2) / w
+			srcRect.Min.Y += (sh - ch) / 2
+			srcRect.Max.Y = srcRect.Min.Y + ch
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Rect, src, srcRect, draw.Src, nil)
+	return dst
+}
+
+// Encode a transformed image
+func (t *imageTransform) encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: t.quality})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, &gif.Options{NumColors: 256, Drawer: draw.FloydSteinberg})
+	case "webp":
+		return encodeWebP(w, img)
+	}
+	return fmt.Errorf("unsupported image format %s", format)
+}
+
+// Transformed image, read from its cache or just made
+type transformedImage struct {
+	data        []byte
+	contentType string
+}
+
+// Get a transformed image from the S3 prefix or the local caches, nil if it is not there yet
+func lookupTransformedImage(c *gin.Context, id string) *transformedImage {
+	cfg := configOf(c).Images
+	if cfg.CachePrefix == "" {
+		if entry := caches.get("images/" + id); entry != nil {
+			content, done, err := entry.open()
+			if err == nil {
+				defer done()
+				if data, err := ioutil.ReadAll(content); err == nil {
+					return &transformedImage{data: data, contentType: entry.contentType}
+				}
+			}
+		}
+		return nil
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(configOf(c).S3bucket), Key: aws.String(cfg.CachePrefix + id)})
+	if err != nil {
+		if !isNotFoundError(err) {
+			requestLog(c).Warnf("Unable to read the transformed image %s: %v", id, err)
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+	return &transformedImage{data: data, contentType: aws.StringValue(resp.ContentType)}
+}
+
+// Keep a transformed image in the S3 prefix or the local caches
+func storeTransformedImage(c *gin.Context, bucket, key, id string, img *transformedImage, lastModified time.Time) {
+	cfg := configOf(c).Images
+	if cfg.CachePrefix == "" {
+		caches.put(&cacheEntry{id: "images/" + id, key: id, bucket: bucket, source: key, etag: id, contentType: img.contentType, lastModified: lastModified}, img.data)
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	_, err := s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(configOf(c).S3bucket),
+		Key:         aws.String(cfg.CachePrefix + id),
+		Body:        bytes.NewReader(img.data),
+		ContentType: aws.String(img.contentType),
+	})
+	if err != nil {
+		requestLog(c).Warnf("Unable to store the transformed image %s: %v", id, err)
+	}
+}
+
+// Read and transform a source image
+func transformImage(c *gin.Context, bucket, key string, t *imageTransform) (*transformedImage, string, error) {
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "Object is not a JPEG, PNG, GIF or WebP image", nil
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, "Image too large to be transformed", nil
+	}
+	if t.format != "" {
+		format = t.format
+	}
+
+	imageTransforms <- struct{}{}
+	defer func() { <-imageTransforms }()
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "Invalid image: " + err.Error(), nil
+	}
+	var buf bytes.Buffer
+	if err := t.encode(&buf, t.apply(src), format); err != nil {
+		return nil, "", err
+	}
+	return &transformedImage{data: buf.Bytes(), contentType: imageFormats[format]}, "", nil
+}
+
+// Serve a transformed image. The transformed images are identified by the ETag of their source,
+// so a new version of the source is transformed again.
+func serveImageTransform(c *gin.Context, bucket, key string) {
+	t, invalid := parseImageTransform(c)
+	if t == nil {
+		writeError(c, http.StatusBadRequest, "InvalidArgument", invalid, "")
+		return
+	}
+	if configOf(c).encryptionFor(key) != nil || c.GetHeader(sseCustomerAlgorithmHeader) != "" {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Encrypted images cannot be transformed", "")
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	head, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	cancel()
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	if aws.Int64Value(head.ContentLength) > configOf(c).Images.MaxSourceSize {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Image too large to be transformed", "")
+		return
+	}
+	id := t.id(bucket, key, aws.StringValue(head.ETag))
+	etag := "\"" + id + "\""
+	lastModified := aws.TimeValue(head.LastModified)
+	setHeaders := func() {
+		header := c.Writer.Header()
+		header.Set("Etag", etag)
+		header.Set("Last-Modified", httpDate(lastModified))
+		configOf(c).setExpiryHeaders(header, key)
+	}
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatchesWeak(ifNoneMatch, etag) {
+		setHeaders()
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cache := "HIT"
+	img := lookupTransformedImage(c, id)
+	if img == nil {
+		cache = "MISS"
+		img, invalid, err = transformImage(c, bucket, key, t)
+		if handleHTTPException(c, key, err) != nil {
+			return
+		}
+		if img == nil {
+			writeError(c, http.StatusBadRequest, "InvalidRequest", invalid, "")
+			return
+		}
+		storeTransformedImage(c, bucket, key, id, img, lastModified)
+	}
+	setHeaders()
+	c.Header("Content-Type", img.contentType)
+	c.Header("X-Cache", cache)
+	usage.addBytesOut(int64(len(img.data)))
+	http.ServeContent(c.Writer, c.Request, "", lastModified, bytes.NewReader(img.data))
+}
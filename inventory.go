@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Upper bounds (in bytes) of the inventory size histogram bins, last bin is unbounded
+var inventoryHistogramBounds = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20, 1 << 30}
+
+// Inventory histogram bin type
+type inventoryBin struct {
+	MaxSize int64 `json:"maxSize,omitempty"`
+	Count   int64 `json:"count"`
+	Size    int64 `json:"size"`
+}
+
+// Inventory object reference type
+type inventoryObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Inventory report type
+type inventoryReport struct {
+	Bucket      string           `json:"bucket"`
+	Prefix      string           `json:"prefix"`
+	ObjectCount int64            `json:"objectCount"`
+	TotalSize   int64            `json:"totalSize"`
+	Histogram   []inventoryBin   `json:"histogram"`
+	Oldest      *inventoryObject `json:"oldest,omitempty"`
+	Newest      *inventoryObject `json:"newest,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Create an empty inventory report
+func newInventoryReport(bucket, prefix string) *inventoryReport {
+	report := &inventoryReport{Bucket: bucket, Prefix: prefix, Histogram: make([]inventoryBin, len(inventoryHistogramBounds)+1)}
+	for i, bound := range inventoryHistogramBounds {
+		report.Histogram[i].MaxSize = bound
+	}
+	return report
+}
+
+// Add a page of listed objects to the report
+func (r *inventoryReport) add(objects []*s3.Object) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, obj := range objects {
+		size := aws.Int64Value(obj.Size)
+		r.ObjectCount++
+		r.TotalSize += size
+		bin := len(inventoryHistogramBounds)
+		for i, bound := range inventoryHistogramBounds {
+			if size <= bound {
+				bin = i
+				break
+			}
+		}
+		r.Histogram[bin].Count++
+		r.Histogram[bin].Size += size
+
+		modified := aws.TimeValue(obj.LastModified)
+		if r.Oldest == nil || modified.Before(r.Oldest.LastModified) {
+			r.Oldest = &inventoryObject{Key: aws.StringValue(obj.Key), Size: size, LastModified: modified}
+		}
+		if r.Newest == nil || modified.After(r.Newest.LastModified) {
+			r.Newest = &inventoryObject{Key: aws.StringValue(obj.Key), Size: size, LastModified: modified}
+		}
+	}
+}
+
+// Build an inventory of the bucket under prefix.
+// The first level is listed with a delimiter, then each common prefix is listed recursively by
+// a limited number of concurrent workers.
+func buildInventory(ctx context.Context, bucket, prefix string, concurrency int) (*inventoryReport, error) {
+	report := newInventoryReport(bucket, prefix)
+	var subPrefixes []string
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix), Delimiter: aws.String("/")}
+	err := listObjectsPages(ctx, input, func(page *s3.ListObjectsV2Output) bool {
+		report.add(page.Contents)
+		for _, p := range page.CommonPrefixes {
+			subPrefixes = append(subPrefixes, aws.StringValue(p.Prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, subPrefix := range subPrefixes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(subPrefix string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(subPrefix)}
+			err := listObjectsPages(ctx, input, func(page *s3.ListObjectsV2Output) bool {
+				report.add(page.Contents)
+				return true
+			})
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(subPrefix)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return report, nil
+}
+
+// Serve the bucket inventory report
+func serveInventory(c *gin.Context) {
+	prefix := strings.TrimPrefix(c.Query("prefix"), "/")
+	report, err := buildInventory(c.Request.Context(), configOf(c).S3bucket, prefix, configOf(c).InventoryConcurrency)
+	if handleHTTPException(c, prefix, err) != nil {
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Proxies trusted by default, the loopback and private networks of the load balancers
+var defaultTrustedProxies = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+
+// Headers giving the client IP by default, the first one set applies
+var defaultClientIPHeaders = []string{"X-Forwarded-For", "X-Real-Ip"}
+
+// Client IP extraction config type
+type clientIPConfig struct {
+	// CIDR ranges of the proxies whose headers give the client IP
+	TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies" toml:"trustedProxies"`
+	// Headers set by the trusted proxies, the first one present applies
+	Headers []string `json:"headers" yaml:"headers" toml:"headers"`
+
+	trusted []*net.IPNet
+}
+
+// IP filter config type, the denied ranges are checked before the allowed ones
+type ipFilterConfig struct {
+	// CIDR ranges of the allowed clients, default is any client
+	Allow []string `json:"allow" yaml:"allow" toml:"allow"`
+	// CIDR ranges of the denied clients
+	Deny []string `json:"deny" yaml:"deny" toml:"deny"`
+	// CIDR ranges of the allowed clients by method, replacing allow for these methods
+	Methods map[string][]string `json:"methods" yaml:"methods" toml:"methods"`
+
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	methods map[string][]*net.IPNet
+}
+
+// Parse CIDR ranges, a single IP is a range of one address
+func parseCIDRs(ranges []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, r := range ranges {
+		if !strings.Contains(r, "/") {
+			if ip := net.ParseIP(r); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Check if an IP is in one of the ranges
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the client IP defaults and parse the trusted proxies
+func (cfg *clientIPConfig) validate() error {
+	if cfg.TrustedProxies == nil {
+		cfg.TrustedProxies = defaultTrustedProxies
+	}
+	if cfg.Headers == nil {
+		cfg.Headers = defaultClientIPHeaders
+	}
+	trusted, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid clientIp trustedProxies: %v", err)
+	}
+	cfg.trusted = trusted
+	return nil
+}
+
+// Get the IP of the client of a request. The headers of the trusted proxies are read from the
+// right, the first address which is not a trusted proxy is the client.
+func (cfg clientIPConfig) resolve(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return ""
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !containsIP(cfg.trusted, remote) {
+		return host
+	}
+	for _, name := range cfg.Headers {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		addrs := strings.Split(strings.Join(values, ","), ",")
+		client := host
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if !containsIP(cfg.trusted, ip) {
+				break
+			}
+		}
+		return client
+	}
+	return host
+}
+
+// Middleware setting the client IP as the remote address of the request, so that all the
+// handlers and the logs see the client instead of the proxy
+func clientIPMiddleware(c *gin.Context) {
+	_, port, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return
+	}
+	if ip := configOf(c).ClientIP.resolve(c.Request); ip != "" {
+		c.Request.RemoteAddr = net.JoinHostPort(ip, port)
+	}
+}
+
+// Check if the IP filter is set
+func (cfg ipFilterConfig) enabled() bool {
+	return len(cfg.Allow) > 0 || len(cfg.Deny) > 0 || len(cfg.Methods) > 0
+}
+
+// Parse the IP filter ranges and put the methods in upper case
+func (cfg *ipFilterConfig) validate() error {
+	var err error
+	if cfg.allow, err = parseCIDRs(cfg.Allow); err != nil {
+		return fmt.Errorf("invalid ipFilter allow: %v", err)
+	}
+	if cfg.deny, err = parseCIDRs(cfg.Deny); err != nil {
+		return fmt.Errorf("invalid ipFilter deny: %v", err)
+	}
+	cfg.methods = map[string][]*net.IPNet{}
+	for method, ranges := range cfg.Methods {
+		nets, err := parseCIDRs(ranges)
+		if err != nil {
+			return fmt.Errorf("invalid ipFilter methods %s: %v", method, err)
+		}
+		cfg.methods[strings.ToUpper(method)] = nets
+	}
+	return nil
+}
+
+// Check if a client IP can use a method
+func (cfg ipFilterConfig) allows(method string, ip net.IP) bool {
+	if ip == nil || containsIP(cfg.deny, ip) {
+		return false
+	}
+	if nets, ok := cfg.methods[method]; ok {
+		return containsIP(nets, ip)
+	}
+	return len(cfg.allow) == 0 || containsIP(cfg.allow, ip)
+}
+
+// Middleware answering 403 to the clients outside the allowed ranges, the probes are never filtered
+func ipFilterMiddleware(c *gin.Context) {
+	if path := strings.TrimPrefix(c.Request.URL.Path, "/"); isHealthPath(path) {
+		return
+	}
+	if !configOf(c).IPFilter.allows(c.Request.Method, net.ParseIP(c.ClientIP())) {
+		requestLog(c).Debugf("Client %s denied by the IP filter", c.ClientIP())
+		writeError(c, http.StatusForbidden, "AccessDenied", "Access denied", "")
+		c.Abort()
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum length of a S3 key in bytes
+const maxKeyLength = 1024
+
+// Decode the object path of a request URL (without the leading /).
+// The path must be valid UTF-8 once percent-decoded and must not contain control characters,
+// so that keys with spaces, '+', '#' or non-ASCII characters are looked up exactly as stored.
+// The '.', '..' and empty segments are refused, as they would name another key once the path is cleaned.
+func decodeObjectPath(u *url.URL) (string, error) {
+	path, err := url.PathUnescape(u.EscapedPath())
+	if err != nil {
+		return "", fmt.Errorf("malformed percent-encoding in path")
+	}
+	if !utf8.ValidString(path) {
+		return "", fmt.Errorf("path is not valid UTF-8")
+	}
+	for _, r := range path {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("path contains control characters")
+		}
+	}
+	path = strings.TrimPrefix(path, "/")
+	if len(path) > maxKeyLength {
+		return "", fmt.Errorf("path is longer than %d bytes", maxKeyLength)
+	}
+	if err := checkPathSegments(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Check that a path (without leading /) has no '.', '..' or empty segment, a trailing / excepted
+func checkPathSegments(path string) error {
+	if path == "" {
+		return nil
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "." || segment == "..":
+			return fmt.Errorf("path contains a '%s' segment", segment)
+		case segment == "" && i < len(segments)-1:
+			return fmt.Errorf("path contains an empty segment")
+		}
+	}
+	return nil
+}
+
+// Escape a decoded path for a Location header
+func escapePath(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Most entries of a page of /_api/list, the S3 limit
+const maxListKeys = 1000
+
+// Listed object type
+type listObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Listing of a prefix type
+type listResult struct {
+	Prefix   string       `json:"prefix"`
+	Prefixes []string     `json:"prefixes"`
+	Objects  []listObject `json:"objects"`
+	// Token of the next page, when the listing is truncated
+	NextContinuationToken string `json:"nextContinuationToken,omitempty"`
+}
+
+// Check if a listed key is hidden from the clients
+func (config *webConfig) isHiddenKey(key string) bool {
+	return isReservedPath(key) || config.Shares.hides(key) || config.Tus.hides(key) || config.Images.hides(key)
+}
+
+// Add the visible sub-prefixes and objects of a listed page
+func (r *listResult) add(config *webConfig, page *s3.ListObjectsV2Output) {
+	for _, p := range page.CommonPrefixes {
+		if !config.isHiddenKey(aws.StringValue(p.Prefix)) {
+			r.Prefixes = append(r.Prefixes, aws.StringValue(p.Prefix))
+		}
+	}
+	for _, obj := range page.Contents {
+		key := aws.StringValue(obj.Key)
+		if key == r.Prefix || config.isHiddenKey(key) {
+			continue
+		}
+		r.Objects = append(r.Objects, listObject{Key: key, Size: aws.Int64Value(obj.Size), ETag: aws.StringValue(obj.ETag), LastModified: aws.TimeValue(obj.LastModified)})
+	}
+}
+
+// List the objects and the sub-prefixes directly under a prefix
+func listDirectory(ctx context.Context, bucket, prefix string) (*listResult, error) {
+	result := &listResult{Prefix: prefix, Prefixes: []string{}, Objects: []listObject{}}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix), Delimiter: aws.String("/")}
+	err := listObjectsPages(ctx, input, func(page *s3.ListObjectsV2Output) bool {
+		result.add(configFrom(ctx), page)
+		return true
+	})
+	return result, err
+}
+
+// List a page of at most maxKeys entries under a prefix, the sub-prefixes up to the delimiter
+// or all the objects below the prefix without delimiter
+func listPage(ctx context.Context, bucket, prefix, delimiter string, maxKeys int64, token string) (*listResult, error) {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix), MaxKeys: aws.Int64(maxKeys)}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	ctx, cancel := s3Context(ctx, configFrom(ctx).Timeouts.List)
+	defer cancel()
+	page, err := s3Session.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	result := &listResult{Prefix: prefix, Prefixes: []string{}, Objects: []listObject{}}
+	result.add(configFrom(ctx), page)
+	if aws.BoolValue(page.IsTruncated) {
+		result.NextContinuationToken = aws.StringValue(page.NextContinuationToken)
+	}
+	return result, nil
+}
+
+// Replace the listed object key prefix by the requested path prefix, for the clients
+func (r *listResult) relocate(keyPrefix, pathPrefix string) {
+	r.Prefix = pathPrefix
+	for i, p := range r.Prefixes {
+		r.Prefixes[i] = pathPrefix + strings.TrimPrefix(p, keyPrefix)
+	}
+	for i, obj := range r.Objects {
+		r.Objects[i].Key = pathPrefix + strings.TrimPrefix(obj.Key, keyPrefix)
+	}
+}
+
+// List a path prefix, in the bucket and under the key prefix it is resolved to
+func listPath(c *gin.Context, prefix string) (*listResult, error) {
+	bucket, keyPrefix := configOf(c).resolveObject(c.Request.Host, prefix)
+	result, err := listDirectory(c.Request.Context(), bucket, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result.relocate(keyPrefix, prefix)
+	return result, nil
+}
+
+// Serve a page of the listing of a prefix of the bucket
+func serveList(c *gin.Context) {
+	prefix := strings.TrimPrefix(c.Query("prefix"), "/")
+	maxKeys := int64(maxListKeys)
+	if s := c.Query("maxKeys"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 || n > maxListKeys {
+			writeError(c, http.StatusBadRequest, "InvalidArgument", "maxKeys must be between 1 and 1000", "")
+			return
+		}
+		maxKeys = n
+	}
+	if !checkKeyAccess(c, http.MethodGet, prefix) {
+		return
+	}
+	bucket, keyPrefix := configOf(c).resolveObject(c.Request.Host, prefix)
+	result, err := listPage(c.Request.Context(), bucket, keyPrefix, c.DefaultQuery("delimiter", "/"), maxKeys, c.Query("continuationToken"))
+	if handleHTTPException(c, prefix, err) != nil {
+		return
+	}
+	result.relocate(keyPrefix, prefix)
+	c.JSON(http.StatusOK, result)
+}
+
+// HTML page of a directory listing
+var listingPage = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of /{{.Prefix}}</title></head>
+<body>
+<h1>Index of /{{.Prefix}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>
+{{if .Prefix}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Prefixes}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>-</td><td></td></tr>
+{{end}}{{range .Objects}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.LastModified}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Directory listing entry of the HTML page
+type listingEntry struct {
+	Name         string
+	Href         string
+	Size         int64
+	LastModified string
+}
+
+// Serve a directory listing of a prefix, as JSON or HTML according to the Accept header
+func serveListing(c *gin.Context, prefix string) {
+	result, err := listPath(c, prefix)
+	if handleHTTPException(c, prefix, err) != nil {
+		return
+	}
+	if strings.Contains(c.GetHeader("Accept"), "application/json") {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	page := struct {
+		Prefix   string
+		Prefixes []listingEntry
+		Objects  []listingEntry
+	}{Prefix: prefix}
+	for _, p := range result.Prefixes {
+		name := strings.TrimPrefix(p, prefix)
+		page.Prefixes = append(page.Prefixes, listingEntry{Name: name, Href: "./" + escapePath(name)})
+	}
+	for _, obj := range result.Objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		page.Objects = append(page.Objects, listingEntry{Name: name, Href: "./" + escapePath(name), Size: obj.Size, LastModified: httpDate(obj.LastModified)})
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := listingPage.Execute(c.Writer, page); err != nil {
+		requestLog(c).Debugf("Listing of %s interrupted: %v", prefix, err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Largest number of log files kept for a retry while their upload fails
+const maxPendingLogFiles = 24
+
+// Access log shipping config type, the access logs are uploaded to S3 in gzip files as the S3 server
+// access logging does
+type accessLogS3Config struct {
+	// Bucket receiving the log files, shipping is disabled without it
+	Bucket string `json:"bucket" yaml:"bucket" toml:"bucket"`
+	// Key prefix of the log files, e.g. logs/
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	// Period of a log file
+	Interval duration `json:"interval" yaml:"interval" toml:"interval"`
+	// Size (in bytes) of the logs before their file is uploaded ahead of the end of the period
+	MaxSize int64 `json:"maxSize" yaml:"maxSize" toml:"maxSize"`
+}
+
+// Check if the access logs are shipped to S3
+func (cfg accessLogS3Config) enabled() bool {
+	return cfg.Bucket != ""
+}
+
+// Set the shipping defaults and check the values
+func (cfg *accessLogS3Config) validate() error {
+	if !cfg.enabled() {
+		return nil
+	}
+	if err := checkBucketName(cfg.Bucket); err != nil {
+		return err
+	}
+	cfg.Prefix = strings.TrimPrefix(cfg.Prefix, "/")
+	cfg.Interval.Duration = cfg.Interval.orDefault(time.Hour)
+	if cfg.Interval.Duration < time.Minute {
+		return fmt.Errorf("accessLog s3 interval must be at least 1m")
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 64 << 20
+	}
+	return nil
+}
+
+// Log file to upload
+type logFile struct {
+	key  string
+	data []byte
+}
+
+// Writer of the access logs batching them into the gzip files of a period
+type logShipper struct {
+	cfg  accessLogS3Config
+	host string
+
+	mu sync.Mutex
+	// Start of the period of the current file
+	period time.Time
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	// Uncompressed size of the current file
+	size    int64
+	pending []logFile
+	// Serializes the uploads
+	uploadMu sync.Mutex
+}
+
+// Shipper of the access logs, nil if not enabled
+var logs *logShipper
+
+// Create the shipper and start uploading the files at the end of their period
+func newLogShipper(cfg accessLogS3Config) *logShipper {
+	host, _ := os.Hostname()
+	ls := &logShipper{cfg: cfg, host: strings.ReplaceAll(host, "/", "-")}
+	ls.gz = gzip.NewWriter(&ls.buf)
+	go ls.run()
+	return ls
+}
+
+// Add log lines to the current file, after the file of the previous period is closed
+func (ls *logShipper) Write(p []byte) (int, error) {
+	ls.mu.Lock()
+	if period := time.Now().Truncate(ls.cfg.Interval.Duration); !period.Equal(ls.period) {
+		ls.closeLocked()
+		ls.period = period
+	}
+	n, err := ls.gz.Write(p)
+	ls.size += int64(n)
+	full := ls.size >= ls.cfg.MaxSize
+	if full {
+		ls.closeLocked()
+	}
+	ls.mu.Unlock()
+	if full {
+		go ls.upload()
+	}
+	return n, err
+}
+
+// Close the current file and queue it for the upload, if it has logs
+func (ls *logShipper) closeLocked() {
+	if ls.size == 0 {
+		return
+	}
+	ls.gz.Close()
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	key := ls.cfg.Prefix + ls.period.UTC().Format("2006-01-02-15-04-05") + "-" + ls.host + "-" + strings.ToUpper(hex.EncodeToString(suffix)) + ".gz"
+	data := append([]byte(nil), ls.buf.Bytes()...)
+	if len(ls.pending) >= maxPendingLogFiles {
+		log.Warnf("Dropping the access log file %s, the previous uploads failed", ls.pending[0].key)
+		ls.pending = ls.pending[1:]
+	}
+	ls.pending = append(ls.pending, logFile{key: key, data: data})
+	ls.buf.Reset()
+	ls.gz.Reset(&ls.buf)
+	ls.size = 0
+}
+
+// Upload the closed files, the failed ones are tried again on the next upload
+func (ls *logShipper) upload() {
+	ls.uploadMu.Lock()
+	defer ls.uploadMu.Unlock()
+	ls.mu.Lock()
+	files := ls.pending
+	ls.pending = nil
+	ls.mu.Unlock()
+	var failed []logFile
+	for _, file := range files {
+		ctx, cancel := s3Context(context.Background(), currentConfig.Load().Timeouts.Put)
+		_, err := s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(ls.cfg.Bucket),
+			Key:         aws.String(file.key),
+			Body:        bytes.NewReader(file.data),
+			ContentType: aws.String("application/gzip"),
+		})
+		cancel()
+		if err != nil {
+			log.Warnf("Unable to upload the access log file %s: %v", file.key, err)
+			failed = append(failed, file)
+			continue
+		}
+		log.Debugf("Uploaded the access log file %s", file.key)
+	}
+	if len(failed) > 0 {
+		ls.mu.Lock()
+		ls.pending = append(failed, ls.pending...)
+		ls.mu.Unlock()
+	}
+}
+
+// Close the file of the past period every minute, so that it is uploaded without waiting for a request
+func (ls *logShipper) run() {
+	for range time.Tick(time.Minute) {
+		ls.mu.Lock()
+		if !time.Now().Truncate(ls.cfg.Interval.Duration).Equal(ls.period) {
+			ls.closeLocked()
+		}
+		pending := len(ls.pending) > 0
+		ls.mu.Unlock()
+		if pending {
+			ls.upload()
+		}
+	}
+}
+
+// Upload the current file on shutdown
+func (ls *logShipper) flush() {
+	ls.mu.Lock()
+	ls.closeLocked()
+	ls.mu.Unlock()
+	ls.upload()
+}
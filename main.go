@@ -2,28 +2,36 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/gin-contrib/gzip"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
@@ -33,25 +41,176 @@ var (
 	Tag = "Unknown"
 	// Date of current version
 	Date = "Unknown"
-	// GracefullTimeout set timeout before exit from server
-	GracefullTimeout int = 1
-	// Configuration holder
-	configHolder *confHolder
+	// Configuration in use, replaced on reload
+	currentConfig atomic.Pointer[webConfig]
 	// S3 Session
 	s3Session *s3.S3
 )
 
+// Configuration file read by default
+const defaultConfigFile = "config.toml"
+
 // Application config type
 type webConfig struct {
 	Port      string `json:"port" yaml:"port" toml:"port"`
 	S3bucket  string `json:"s3bucket" yaml:"s3bucket" toml:"s3bucket"`
 	AwsRegion string `json:"awsRegion" yaml:"awsRegion" toml:"awsRegion"`
 	Homepage  string `json:"homepage" yaml:"homepage" toml:"homepage"`
+	// OpenTelemetry spans of the requests and S3 calls, exported with OTLP
+	Tracing tracingConfig `json:"tracing" yaml:"tracing" toml:"tracing"`
+	// Format and file of the access log
+	AccessLog accessLogConfig `json:"accessLog" yaml:"accessLog" toml:"accessLog"`
+	// AWS credentials, default is the credential chain of the SDK
+	Credentials credentialsConfig `json:"credentials" yaml:"credentials" toml:"credentials"`
+	// Cross-origin requests of the browser applications
+	CORS corsConfig `json:"cors" yaml:"cors" toml:"cors"`
+	// Basic and bearer token authentication
+	Auth authConfig `json:"auth" yaml:"auth" toml:"auth"`
+	// HTTPS with a certificate file or Let's Encrypt certificates
+	TLS tlsConfig `json:"tls" yaml:"tls" toml:"tls"`
+	// HTTP/2 cleartext and HTTP/3 listeners
+	Protocols protocolsConfig `json:"protocols" yaml:"protocols" toml:"protocols"`
+	// Timeouts, header size and connections limits of the HTTP server
+	Server serverConfig `json:"server" yaml:"server" toml:"server"`
+	// Listener of the admin endpoints, metrics and profiles, with its own credentials
+	Admin adminConfig `json:"admin" yaml:"admin" toml:"admin"`
+	// SFTP gateway to the bucket, for the tools that cannot speak HTTP
+	SFTP sftpConfig `json:"sftp" yaml:"sftp" toml:"sftp"`
+	// Number of concurrent listings used to build an inventory report
+	InventoryConcurrency int `json:"inventoryConcurrency" yaml:"inventoryConcurrency" toml:"inventoryConcurrency"`
+	// Pay the requests and transfers of requester pays buckets
+	RequesterPays bool `json:"requesterPays" yaml:"requesterPays" toml:"requesterPays"`
+	// S3 prices used to estimate the monthly cost
+	Pricing pricingConfig `json:"pricing" yaml:"pricing" toml:"pricing"`
+	// Level of error detail exposed to clients (full, code or generic)
+	ErrorDetail string `json:"errorDetail" yaml:"errorDetail" toml:"errorDetail"`
+	// Content types of the key extensions, used when S3 gives no or a generic content type
+	ContentTypes map[string]string `json:"contentTypes" yaml:"contentTypes" toml:"contentTypes"`
+	// Keys of the error pages served from the bucket by status code
+	ErrorPages map[string]string `json:"errorPages" yaml:"errorPages" toml:"errorPages"`
+	// Go plugin files providing extensions
+	Plugins []string `json:"plugins" yaml:"plugins" toml:"plugins"`
+	// Allow access point ARNs from another region than awsRegion
+	UseArnRegion bool `json:"useArnRegion" yaml:"useArnRegion" toml:"useArnRegion"`
+	// S3 endpoint URL of a S3 compatible store (MinIO, Ceph, LocalStack...), default is the AWS endpoint of the region
+	Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+	// Use path style URLs (endpoint/bucket/key) instead of virtual hosted buckets
+	ForcePathStyle bool `json:"forcePathStyle" yaml:"forcePathStyle" toml:"forcePathStyle"`
+	// Connect to the S3 endpoint over plain HTTP
+	DisableSSL bool `json:"disableSSL" yaml:"disableSSL" toml:"disableSSL"`
+	// Use the FIPS 140-2 validated S3 endpoints
+	UseFIPSEndpoint bool `json:"useFipsEndpoint" yaml:"useFipsEndpoint" toml:"useFipsEndpoint"`
+	// Use the dual-stack (IPv4 and IPv6) S3 endpoints
+	UseDualStack bool `json:"useDualStack" yaml:"useDualStack" toml:"useDualStack"`
+	// Retry policy of the S3 calls
+	Retry retryConfig `json:"retry" yaml:"retry" toml:"retry"`
+	// Serve a listing of the directories without homepage
+	EnableListing bool `json:"enableListing" yaml:"enableListing" toml:"enableListing"`
+	// Downloads of the prefixes as archives and extraction of the uploaded archives
+	Archives archiveConfig `json:"archives" yaml:"archives" toml:"archives"`
+	// Serve the index page instead of the missing objects without file extension, for the client-side routers
+	SPAMode bool `json:"spaMode" yaml:"spaMode" toml:"spaMode"`
+	// Index page of the single-page application, default is the homepage or index.html
+	SPAIndex string `json:"spaIndex" yaml:"spaIndex" toml:"spaIndex"`
+	// DELETE only adds delete markers, the objects can be restored on /_admin/restore
+	SoftDelete bool `json:"softDelete" yaml:"softDelete" toml:"softDelete"`
+	// Redirect between /foo and /foo/ when only one of them exists (add or remove)
+	DirectoryRedirect string `json:"directoryRedirect" yaml:"directoryRedirect" toml:"directoryRedirect"`
+	// Normalization rules of the uploaded keys by key prefix
+	KeyNormalization []keyNormalization `json:"keyNormalization" yaml:"keyNormalization" toml:"keyNormalization"`
+	// Cache lifetimes of the objects by key prefix
+	TTL []ttlRule `json:"ttl" yaml:"ttl" toml:"ttl"`
+	// Gzip compression of the responses
+	Compression compressionConfig `json:"compression" yaml:"compression" toml:"compression"`
+	// Client IP behind the trusted proxies
+	ClientIP clientIPConfig `json:"clientIp" yaml:"clientIp" toml:"clientIp"`
+	// Allowed and denied client IP ranges
+	IPFilter ipFilterConfig `json:"ipFilter" yaml:"ipFilter" toml:"ipFilter"`
+	// Request and bandwidth limits, per client IP and overall
+	RateLimit rateLimitConfig `json:"rateLimit" yaml:"rateLimit" toml:"rateLimit"`
+	// Deadlines of the S3 calls by operation
+	Timeouts timeoutsConfig `json:"timeouts" yaml:"timeouts" toml:"timeouts"`
+	// Draining of the in-flight requests on SIGTERM
+	Shutdown shutdownConfig `json:"shutdown" yaml:"shutdown" toml:"shutdown"`
+	// Circuit breaker around the S3 calls
+	CircuitBreaker circuitBreakerConfig `json:"circuitBreaker" yaml:"circuitBreaker" toml:"circuitBreaker"`
+	// Regional replicas of the buckets serving the reads
+	Replicas replicasConfig `json:"replicas" yaml:"replicas" toml:"replicas"`
+	// Prefix added to all the object keys
+	KeyPrefix string `json:"keyPrefix" yaml:"keyPrefix" toml:"keyPrefix"`
+	// Rewrite rules of the paths into object keys, the first matching rule applies
+	Rewrites []rewriteRule `json:"rewrites" yaml:"rewrites" toml:"rewrites"`
+	// Response headers of the GET and HEAD requests by path pattern
+	Headers []headerRule `json:"headers" yaml:"headers" toml:"headers"`
+	// Buckets serving some hosts or path prefixes instead of s3bucket
+	Buckets []bucketMapping `json:"buckets" yaml:"buckets" toml:"buckets"`
+	// Bucket and prefix serving /.well-known/ paths
+	WellKnown wellKnownConfig `json:"wellKnown" yaml:"wellKnown" toml:"wellKnown"`
+	// Access control rules of the object paths, the first rule matching the path applies
+	ACL []aclRule `json:"acl" yaml:"acl" toml:"acl"`
+	// Methods served on the object paths, e.g. GET and HEAD for a read-only gateway, default is all
+	AllowedMethods []string `json:"allowedMethods" yaml:"allowedMethods" toml:"allowedMethods"`
+	// Maximum size (in bytes) of an upload body, 0 is unlimited
+	MaxUploadSize int64 `json:"maxUploadSize" yaml:"maxUploadSize" toml:"maxUploadSize"`
+	// Streaming of the uploads to S3
+	Upload uploadConfig `json:"upload" yaml:"upload" toml:"upload"`
+	// Send the If-Match and If-None-Match preconditions of the uploads to S3 too, for the stores with conditional writes
+	ConditionalWrites bool `json:"conditionalWrites" yaml:"conditionalWrites" toml:"conditionalWrites"`
+	// Encryption of the stored objects by S3 (SSE-S3 or SSE-KMS)
+	ServerSideEncryption sseConfig `json:"serverSideEncryption" yaml:"serverSideEncryption" toml:"serverSideEncryption"`
+	// Encryption of the objects by key prefix, before they are stored in S3
+	Encryption []encryptionRule `json:"encryption" yaml:"encryption" toml:"encryption"`
+	// Share links to objects, with expiry and download limits
+	Shares sharesConfig `json:"shares" yaml:"shares" toml:"shares"`
+	// Resumable uploads with the tus protocol on /_api/tus/
+	Tus tusConfig `json:"tus" yaml:"tus" toml:"tus"`
+	// Multipart uploads driven by the clients on /_api/multipart
+	MultipartAPI bool `json:"multipartApi" yaml:"multipartApi" toml:"multipartApi"`
+	// Listing of the prefixes page by page on /_api/list
+	ListAPI bool `json:"listApi" yaml:"listApi" toml:"listApi"`
+	// Search of the object keys on /_api/search
+	SearchAPI bool `json:"searchApi" yaml:"searchApi" toml:"searchApi"`
+	// S3 Select queries of the objects on /_api/select
+	SelectAPI bool `json:"selectApi" yaml:"selectApi" toml:"selectApi"`
+	// Presigned S3 URLs created on /_api/presign
+	Presign presignConfig `json:"presign" yaml:"presign" toml:"presign"`
+	// Embedded file browser UI on /_ui/
+	UI uiConfig `json:"ui" yaml:"ui" toml:"ui"`
+	// Resized and converted images served on GET ?w=&h=&fit=&format=
+	Images imagesConfig `json:"images" yaml:"images" toml:"images"`
+	// Markdown objects rendered as HTML pages for the browsers
+	Markdown markdownConfig `json:"markdown" yaml:"markdown" toml:"markdown"`
+	// Memory cache of the small downloaded objects
+	MemoryCache cacheConfig `json:"memoryCache" yaml:"memoryCache" toml:"memoryCache"`
+	// Local disk cache of the downloaded objects
+	DiskCache cacheConfig `json:"diskCache" yaml:"diskCache" toml:"diskCache"`
+	// Chunks of the objects read with range requests, stored in the memory or the disk cache
+	RangeCache rangeCacheConfig `json:"rangeCache" yaml:"rangeCache" toml:"rangeCache"`
+	// Invalidation of the cached objects changed outside the server, from S3 events received by SQS
+	CacheEvents cacheEventsConfig `json:"cacheEvents" yaml:"cacheEvents" toml:"cacheEvents"`
+	// Fault injection for testing the clients, can be changed at runtime on /_admin/chaos
+	Chaos chaosConfig `json:"chaos" yaml:"chaos" toml:"chaos"`
+}
+
+// Key of the configuration of a request in its context
+type ctxConfigKey struct{}
+
+// Get the configuration of a request, the current one outside of the requests
+func configFrom(ctx context.Context) *webConfig {
+	if config, ok := ctx.Value(ctxConfigKey{}).(*webConfig); ok {
+		return config
+	}
+	return currentConfig.Load()
+}
+
+// Get the configuration of a request
+func configOf(c *gin.Context) *webConfig {
+	return configFrom(c.Request.Context())
 }
 
-// Configuration holder type
-type confHolder struct {
-	Config *webConfig
+// Read the configuration once per request, so that a reload never changes it while the request is served
+func configMiddleware(c *gin.Context) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxConfigKey{}, currentConfig.Load()))
 }
 
 // Get an environment variable or use a default value if not set
@@ -70,43 +229,204 @@ func getEnvOrDefault(envName, defaultVal string, fatal bool) (envVal string) {
 	return
 }
 
-// Read configuration file. Default is CONFIG_FILE environment variable value if it is set or file "config.yaml".
-// If configuration file do not exists, then
+// Read configuration file, then apply the S3WS_ environment variables and the -set flags.
+// The default configuration file is optional, the configuration can come from the environment only.
 func readConfig(configPath string) (*webConfig, error) {
 	if configPath == "" {
-		configPath = "config.yaml"
+		configPath = defaultConfigFile
 	}
 	// Read file content
 	bs, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) && configPath == defaultConfigFile {
+		log.Infof("No configuration file %s, using the environment and the flags", configPath)
+		bs, err = nil, nil
+	}
 	if err != nil {
 		return &webConfig{}, errors.Wrap(err, "failed to read configuration file")
 	}
 	// Check file extension
 	extension := filepath.Ext(configPath)
 	var cfg *webConfig = &webConfig{}
+	if bs != nil {
+		err = parseConfig(configPath, extension, bs, cfg)
+	}
+	if err != nil {
+		return &webConfig{}, errors.Wrap(err, "failed to parse configuration file")
+	}
+	if err := applyOverrides(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	return setConfigDefaults(cfg)
+}
+
+// Parse the content of a configuration file by its extension
+func parseConfig(configPath, extension string, bs []byte, cfg *webConfig) (err error) {
 	switch extension {
 	case ".yaml", ".yml":
 		// Unmarshal Yaml file to config struct
-		err = yaml.Unmarshal(bs, &cfg)
+		err = yaml.Unmarshal(bs, cfg)
 	case ".json":
 		// Unmarshal Json file to config struct
-		err = json.Unmarshal(bs, &cfg)
+		err = json.Unmarshal(bs, cfg)
 	case ".toml":
 		// Unmarshal Toml file to config struct
-		_, err = toml.DecodeFile(configPath, &cfg)
+		_, err = toml.DecodeFile(configPath, cfg)
 	default:
 		err = fmt.Errorf("Unknown configuration file format %s (support only yaml or json)", extension)
 	}
-	if err != nil {
-		return &webConfig{}, errors.Wrap(err, "failed to parse configuration file")
-	}
+	return err
+}
+
+// Set the configuration defaults and check the values
+func setConfigDefaults(cfg *webConfig) (*webConfig, error) {
 	log.Debugf("config = %v", cfg)
 	if cfg.Port == "" {
 		cfg.Port = "8000"
 	}
+	if cfg.AwsRegion == "" {
+		cfg.AwsRegion = bucketARNRegion(cfg.S3bucket)
+	}
 	if cfg.AwsRegion == "" {
 		cfg.AwsRegion = getEnvOrDefault("AWS_REGION", "eu-west-1", false)
 	}
+	if cfg.InventoryConcurrency <= 0 {
+		cfg.InventoryConcurrency = 4
+	}
+	cfg.Pricing.setDefaults()
+	switch cfg.DirectoryRedirect {
+	case "", directoryRedirectAdd, directoryRedirectRemove:
+	default:
+		return &webConfig{}, fmt.Errorf("Unknown directoryRedirect %s (support only add or remove)", cfg.DirectoryRedirect)
+	}
+	if err := cfg.Compression.validate(); err != nil {
+		return &webConfig{}, errors.Wrap(err, "invalid compression")
+	}
+	switch cfg.ErrorDetail {
+	case "":
+		cfg.ErrorDetail = errorDetailCode
+	case errorDetailFull, errorDetailCode, errorDetailGeneric:
+	default:
+		return &webConfig{}, fmt.Errorf("Unknown errorDetail %s (support only full, code or generic)", cfg.ErrorDetail)
+	}
+	if err := validateKeyNormalization(cfg.KeyNormalization); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateACL(cfg.ACL); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateAllowedMethods(cfg.AllowedMethods); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Archives.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if cfg.MaxUploadSize < 0 {
+		return &webConfig{}, fmt.Errorf("invalid maxUploadSize %d", cfg.MaxUploadSize)
+	}
+	if err := cfg.Upload.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.ServerSideEncryption.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateEncryption(cfg.Encryption); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.MemoryCache.validate(64<<20, 1<<20); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.DiskCache.validate(1<<30, 100<<20); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.CacheEvents.validate(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.RangeCache.validate(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.CircuitBreaker.validate(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Replicas.validate(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Images.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Markdown.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Presign.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.CORS.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.TLS.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Protocols.validate(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Server.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Admin.validate(cfg); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.SFTP.validate(cfg.Auth); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Chaos.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Credentials.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.ClientIP.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.IPFilter.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.RateLimit.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Shutdown.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.AccessLog.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.Tracing.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := cfg.WellKnown.validate(); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateContentTypes(cfg.ContentTypes); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateErrorPages(cfg.ErrorPages); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateHeaderRules(cfg.Headers); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateRewrites(cfg.Rewrites); err != nil {
+		return &webConfig{}, err
+	}
+	if err := validateBucketMappings(cfg.Buckets); err != nil {
+		return &webConfig{}, err
+	}
+	for _, bucket := range configuredBuckets(cfg) {
+		if err := checkBucketName(bucket); err != nil {
+			return &webConfig{}, err
+		}
+	}
 	return cfg, nil
 }
 
@@ -116,81 +436,235 @@ func showVersion() string {
 }
 
 // Serve a HEAD request for a S3 file
-func serveHeadS3File(c *gin.Context) {
-	r := c.Request
+func serveHeadS3File(c *gin.Context, bucket, filePath string) {
 	w := c.Writer
-	filePath := r.URL.Path[1:]
-	input := &s3.HeadObjectInput{Bucket: aws.String(configHolder.Config.S3bucket), Key: aws.String(filePath)}
-	etag := r.Header.Get("ETag")
-	if etag != "" {
-		input.IfNoneMatch = &etag
-	}
-	resp, err := s3Session.HeadObject(input)
-	if handleHTTPException(filePath, w, err) != nil {
+	// The preconditions are checked on the response, so that a 304 carries the headers of the object
+	client, target := readTarget(c, bucket)
+	input := &s3.HeadObjectInput{Bucket: target, Key: aws.String(filePath), VersionId: requestedVersion(c)}
+	if err := applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
 		return
 	}
-	w.Header().Set("Content-Type", *resp.ContentType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", *resp.ContentLength))
-	w.Header().Set("Last-Modified", resp.LastModified.String())
-	w.Header().Set("Etag", *resp.ETag)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	defer cancel()
+	resp, err := client.HeadObjectWithContext(ctx, input)
+	if isNotFoundError(err) && (redirectDirectory(c, bucket, filePath) || serveSPAIndex(c, serveHeadS3File)) {
+		return
+	}
+	if handleHTTPException(c, filePath, err) != nil {
+		return
+	}
+	if redirectWebsiteLocation(c, resp.WebsiteRedirectLocation) {
+		return
+	}
+	status := readPreconditionStatus(c.Request, aws.StringValue(resp.ETag), aws.TimeValue(resp.LastModified))
+	if status == http.StatusPreconditionFailed {
+		writeError(c, status, "PreconditionFailed", "Object '"+filePath+"' does not match the preconditions", "")
+		return
+	}
+	headObjectHeaders(resp).set(c.Request, w.Header(), filePath)
+	if status == http.StatusNotModified {
+		w.Header().Del("Content-Length")
+		c.Status(status)
+		return
+	}
+	c.Status(http.StatusOK)
 }
 
 // Serve a GET request for a S3 file
-func serveGetS3File(c *gin.Context) {
+func serveGetS3File(c *gin.Context, bucket, filePath string) {
 	w := c.Writer
-	filePath := c.Request.URL.Path[1:]
 
-	params := &s3.GetObjectInput{Bucket: aws.String(configHolder.Config.S3bucket), Key: aws.String(filePath)}
-	resp, err := s3Session.GetObject(params)
-	if handleHTTPException(filePath, w, err) != nil {
+	client, target := readTarget(c, bucket)
+	params := &s3.GetObjectInput{Bucket: target, Key: aws.String(filePath), VersionId: requestedVersion(c)}
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		params.IfMatch = aws.String(ifMatch)
+	}
+	if t, err := http.ParseTime(c.GetHeader("If-Unmodified-Since")); err == nil {
+		params.IfUnmodifiedSince = aws.Time(t)
+	}
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		params.IfNoneMatch = aws.String(ifNoneMatch)
+	} else if t, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil {
+		// If-Modified-Since is ignored when If-None-Match is present (RFC 7232)
+		params.IfModifiedSince = aws.Time(t)
+	}
+	// Encrypted objects are decrypted as a whole
+	if rangeHeader := c.GetHeader("Range"); isValidRange(rangeHeader) && configOf(c).encryptionFor(filePath) == nil {
+		params.Range = aws.String(rangeHeader)
+	}
+	if err := applySSECustomer(c, &params.SSECustomerAlgorithm, &params.SSECustomerKey, &params.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
+		return
+	}
+	// The caches only hold the current versions of the primary bucket
+	cacheable := params.VersionId == nil && !isFailingOver(c) && caches.cacheable(c, filePath)
+	if cacheable {
+		if entry := caches.lookup(c.Request.Context(), bucket, filePath); entry != nil && caches.serve(c, entry) {
+			return
+		}
+		if params.Range != nil && configOf(c).RangeCache.Enabled && serveRangeCached(c, bucket, filePath) {
+			return
+		}
+	}
+	ifMatch, ifUnmodifiedSince := params.IfMatch, params.IfUnmodifiedSince
+	conditionalRange := applyIfRange(c.Request, params)
+	if params.Range != nil && isMultiRange(*params.Range) {
+		if ranges := splitRanges(*params.Range); ranges != nil && serveMultiRange(c, bucket, filePath, params, ranges, conditionalRange) {
+			return
+		}
+		// Serve the full content
+		params.Range, params.IfMatch, params.IfUnmodifiedSince = nil, ifMatch, ifUnmodifiedSince
+		conditionalRange = false
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := client.GetObjectWithContext(ctx, params)
+	if conditionalRange && isPreconditionFailed(err) {
+		// If-Range validator does not match, send the full content
+		params.Range, params.IfMatch, params.IfUnmodifiedSince = nil, ifMatch, ifUnmodifiedSince
+		resp, err = client.GetObjectWithContext(ctx, params)
+	}
+	if isNotFoundError(err) && (redirectDirectory(c, bucket, filePath) || serveSPAIndex(c, serveGetS3File)) {
+		return
+	}
+	if errorCode(err) == "InvalidRange" {
+		setUnsatisfiedRange(c, bucket, filePath)
+	}
+	if errorCode(err) == "NotModified" {
+		setNotModifiedHeaders(c, filePath)
+	}
+	if handleHTTPException(c, filePath, err) != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if redirectWebsiteLocation(c, resp.WebsiteRedirectLocation) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", *resp.ContentType)
-	w.Header().Set("Last-Modified", resp.LastModified.String())
-	w.Header().Set("Etag", *resp.ETag)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", *resp.ContentLength))
+	var body io.Reader = resp.Body
+	cached := func(int64) {}
+	if isEncrypted(resp.Metadata) {
+		if resp.ContentRange != nil {
+			// Encrypted outside of the encryption prefixes, fetch the whole object
+			params.Range, params.IfMatch, params.IfUnmodifiedSince = nil, ifMatch, ifUnmodifiedSince
+			resp.Body.Close()
+			resp, err = client.GetObjectWithContext(ctx, params)
+			if handleHTTPException(c, filePath, err) != nil {
+				return
+			}
+			defer resp.Body.Close()
+		}
+		plaintext, err := decryptObject(ctx, resp.Metadata, resp.Body)
+		if err != nil {
+			writeInternalError(c, "DecryptionFailed", "Failed to decrypt "+filePath+": "+err.Error(), "")
+			return
+		}
+		body = bytes.NewReader(plaintext)
+		resp.ContentLength = aws.Int64(int64(len(plaintext)))
+	} else if cacheable {
+		body, cached = caches.store(bucket, filePath, resp)
+	}
+
+	// The body is ready before any header is set, so that a failure still gets a clean error response
+	headers := getObjectHeaders(resp)
+	if headers.decodedFor(c.Request) {
+		gz, err := gzip.NewReader(body)
+		if handleHTTPException(c, filePath, err) != nil {
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// Headers first, then the status, then the body
+	headers.set(c.Request, w.Header(), filePath)
+	status := http.StatusOK
+	if resp.ContentRange != nil {
+		w.Header().Set("Content-Range", *resp.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
 
-	// File is ready to download
-	io.Copy(w, resp.Body)
+	// File is ready to download, the copy stops as soon as the client is gone
+	n, err := io.Copy(w, body)
+	usage.addBytesOut(n)
+	cached(n)
+	if err != nil {
+		// The status is sent, the client gets a body shorter than its Content-Length
+		if c.Request.Context().Err() != nil {
+			requestLog(c).Debugf("Download of %s interrupted by the client after %d bytes", filePath, n)
+		} else {
+			requestLog(c).Warnf("Download of %s failed after %d bytes: %v", filePath, n, err)
+		}
+	}
 }
 
 // Serve a PUT request for a S3 file
-func servePutS3File(c *gin.Context) {
-	// Convert the uploaded body to a byte array TODO fix this for large sizes
+func servePutS3File(c *gin.Context, bucket, filePath string) {
 	r := c.Request
 	w := c.Writer
-	filePath := r.URL.Path[1:]
-	b, err := ioutil.ReadAll(r.Body)
-
-	if handleHTTPException(filePath, w, err) != nil {
+	if !checkWritePreconditions(c, bucket, filePath) {
 		return
 	}
+	replaces := uploadReplaces(c, bucket, filePath)
+	progress := uploads.track(c, filePath)
+	if progress != nil {
+		r.Body = &progressReader{ReadCloser: r.Body, progress: progress}
+		defer func() { uploads.finish(progress, w.Status()) }()
+	}
+	body := &countingReader{Reader: r.Body}
+	defer func() { usage.addBytesIn(body.n) }()
 
-	params := &s3.PutObjectInput{Bucket: aws.String(configHolder.Config.S3bucket), Key: aws.String(filePath), Body: bytes.NewReader(b)}
+	// The body is streamed to S3
+	params := &s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(filePath), Body: body}
+	applyObjectHeaders(r.Header, params)
+	if err := applySSECustomer(c, &params.SSECustomerAlgorithm, &params.SSECustomerKey, &params.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
+		return
+	}
+	configOf(c).applyServerSideEncryption(params.SSECustomerAlgorithm, &params.ServerSideEncryption, &params.SSEKMSKeyId, &params.BucketKeyEnabled)
 
-	resp, err := s3Session.PutObject(params)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	resp, err := storeObject(ctx, params, r.ContentLength, progress, conditionalWriteOptions(r)...)
 
-	if handleHTTPException(filePath, w, err) != nil {
+	if handleHTTPException(c, filePath, err) != nil {
 		return
 	}
-	w.Header().Set("ETag", *resp.ETag)
+	w.Header().Set("ETag", aws.StringValue(resp.ETag))
+	setVersionHeader(w.Header(), resp.VersionID)
+	setSSECustomerHeaders(w.Header(), params.SSECustomerAlgorithm, params.SSECustomerKeyMD5)
 
-	// File has been created TODO do not return a http.StatusCreated if the file was updated
-	http.Redirect(w, r, "/"+filePath, http.StatusCreated)
+	// 201 for a new object, 200 when an existing one was replaced
+	status := http.StatusCreated
+	if replaces {
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", escapePath(r.URL.Path))
+	c.JSON(status, formObject{Path: r.URL.Path, ETag: aws.StringValue(resp.ETag), VersionID: aws.StringValue(resp.VersionID), Size: body.n})
 }
 
 // Serve a DELETE request for a S3 file
-func serveDeleteS3File(c *gin.Context) {
+func serveDeleteS3File(c *gin.Context, bucket, filePath string) {
 	w := c.Writer
-	filePath := c.Request.URL.Path[1:]
-	params := &s3.DeleteObjectInput{Bucket: aws.String(configHolder.Config.S3bucket), Key: aws.String(filePath)}
-	_, err := s3Session.DeleteObject(params)
+	if !checkWritePreconditions(c, bucket, filePath) || !checkSoftDelete(c, bucket, filePath) {
+		return
+	}
+	// A versionId deletes the version permanently, else a delete marker is added on versioned buckets
+	params := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(filePath), VersionId: requestedVersion(c)}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Delete)
+	defer cancel()
+	resp, err := s3Session.DeleteObjectWithContext(ctx, params)
 
-	if handleHTTPException(filePath, w, err) != nil {
+	if handleHTTPException(c, filePath, err) != nil {
 		return
 	}
+	caches.invalidate(bucket, filePath)
+	setVersionHeader(w.Header(), resp.VersionId)
+	if aws.BoolValue(resp.DeleteMarker) {
+		w.Header().Set(deleteMarkerHeader, "true")
+	}
 
 	// File has been deleted
 	w.WriteHeader(http.StatusNoContent)
@@ -198,74 +672,275 @@ func serveDeleteS3File(c *gin.Context) {
 
 // Handle http method to provide the good S3 function
 func methodHandler(c *gin.Context) {
+	config := configOf(c)
 	r := c.Request
-	w := c.Writer
 	var method = r.Method
-	var path = r.URL.Path[1:] // Remove the / from the start of the URL
+	path, err := decodeObjectPath(r.URL) // Without the / from the start of the URL
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidPath", "Invalid path: "+err.Error(), "")
+		return
+	}
+	c.Set(ctxOriginalPath, r.URL.Path)
+
+	// Server endpoints are not backed by the bucket
+	if config.isHiddenKey(path) {
+		writeError(c, http.StatusNotFound, "NotFound", "Path '"+path+"' not found", "")
+		return
+	}
+
+	// Methods outside allowedMethods are refused, e.g. the writes on a read-only gateway
+	if !methodAllowed(method) {
+		c.Header("Allow", strings.Join(allowedMethods(), ", "))
+		writeError(c, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method "+method+" not allowed", "")
+		return
+	}
+
+	// S3 is failing, serve the objects from the replicas or the degraded mode response
+	if breaker != nil && breaker.isOpen() {
+		prefix := path == "" || strings.HasSuffix(path, "/")
+		if !failoverAllowed(method) || (prefix && (config.Homepage == "" || archiveFormat(c) != "")) {
+			breaker.serveDegraded(c)
+			return
+		}
+		c.Set(ctxFailover, true)
+	}
+
+	// Uploads over the maximum size are refused before their body is read
+	if (method == "PUT" || method == "POST") && !limitUploadSize(c) {
+		return
+	}
+
+	// Form uploads are stored under the path
+	if method == "POST" {
+		servePostS3Files(c, path)
+		return
+	}
 
 	// A file with no path cannot be served
 	if path == "" || path[len(path)-1:] == "/" {
-		if configHolder.Config.Homepage == "" {
-			log.Debugln("GET : filepath is empty")
-			http.Error(w, "Path must be provided", http.StatusBadRequest)
+		// The objects under the prefix are deleted, each key checked against the access control rules
+		if method == "DELETE" {
+			serveDeletePrefix(c, path)
+			return
+		}
+		if format := archiveFormat(c); format != "" && method == "GET" {
+			serveArchive(c, path, format)
 			return
 		}
-		r.URL.Path = r.URL.Path + configHolder.Config.Homepage
+		if config.Homepage == "" && config.EnableListing && (method == "GET" || method == "HEAD") {
+			if checkACL(c, path) {
+				serveListing(c, path)
+			}
+			return
+		}
+		if config.Homepage == "" {
+			requestLog(c).Debugln("GET : filepath is empty")
+			writeError(c, http.StatusBadRequest, "BadRequest", "Path must be provided", "")
+			return
+		}
+		r.URL.Path = r.URL.Path + config.Homepage
+		path += config.Homepage
+	}
+
+	if method == "PUT" {
+		if normalized := config.normalizeUploadKey(path); normalized != path {
+			requestLog(c).Debugf("Upload key %s normalized to %s", path, normalized)
+			path = normalized
+			r.URL.Path = "/" + path
+		}
+	}
+
+	// The archive is not stored, its entries are, each checked against the access control rules
+	if method == "PUT" && extractRequested(c) {
+		serveExtract(c, path)
+		return
 	}
 
+	// The access control rules apply to the path of the stored key
+	if !checkACL(c, path) {
+		return
+	}
+
+	bucket, key := config.resolveObject(r.Host, path)
+	if isFailingOver(c) {
+		// The transforms are not served from the replicas
+		if _, ok := fallback.bucket(bucket); !ok || imageTransformRequested(c) || markdownRequested(c, key) {
+			breaker.serveDegraded(c)
+			return
+		}
+		c.Header("X-Fallback", "true")
+	}
 	switch method {
 	case "GET":
-		serveGetS3File(c)
+		if imageTransformRequested(c) {
+			serveImageTransform(c, bucket, key)
+			return
+		}
+		if markdownRequested(c, key) {
+			serveMarkdown(c, bucket, key)
+			return
+		}
+		serveGetS3File(c, bucket, key)
 	case "PUT":
-		servePutS3File(c)
+		servePutS3File(c, bucket, key)
 	case "DELETE":
-		serveDeleteS3File(c)
+		serveDeleteS3File(c, bucket, key)
 	case "HEAD":
-		serveHeadS3File(c)
+		serveHeadS3File(c, bucket, key)
 	default:
-		http.Error(w, "Method "+method+" not supported", http.StatusMethodNotAllowed)
+		writeError(c, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method "+method+" not supported", "")
+	}
+}
+
+// Resolve the bucket and the key of the object served for a request host and path
+func (config *webConfig) resolveObject(host, path string) (bucket, key string) {
+	if isWellKnownPath(path) && config.WellKnown.isMapped() {
+		return config.WellKnown.resolve(config.S3bucket, path)
+	}
+	path = config.rewritePath(path)
+	bucket, key = config.S3bucket, path
+	if m := config.findBucketMapping(host, path); m != nil {
+		bucket, key = m.resolve(path)
 	}
+	return bucket, config.KeyPrefix + key
 }
 
 // Handle an exception and write to response
-func handleHTTPException(path string, w http.ResponseWriter, err error) (e error) {
+func handleHTTPException(c *gin.Context, path string, err error) (e error) {
 	if err != nil {
-		if awsError, ok := err.(awserr.Error); ok {
-			log.Debugf("Failed : %v", awsError)
+		requestID := ""
+		if reqErr, ok := err.(awserr.RequestFailure); ok {
+			requestID = reqErr.RequestID()
+		}
+		if isUploadTooLarge(err) {
+			requestLog(c).Debugf("Upload of %s over the maximum size", path)
+			writeUploadTooLarge(c)
+		} else if isCanceled(err) {
+			// The client is gone, nobody will read the response
+			requestLog(c).Debugf("Canceled : %v", err)
+		} else if isDeadlineExceeded(err) {
+			requestLog(c).Debugf("Failed : %v", err)
+			writeError(c, http.StatusGatewayTimeout, "Timeout", "S3 did not answer in time", requestID)
+		} else if awsError, ok := err.(awserr.Error); ok {
+			requestLog(c).Debugf("Failed : %v", awsError)
 			// aws error
 			switch awsError.Code() {
 			case "MissingContentLength":
-				http.Error(w, "Bad Request", http.StatusBadRequest)
+				writeError(c, http.StatusBadRequest, awsError.Code(), "Bad Request", requestID)
+			case "InvalidRequest", "InvalidArgument", "BadRequest":
+				// e.g. a SSE-C object requested without its key
+				message := "Invalid request for path '" + path + "'"
+				if configOf(c).ErrorDetail == errorDetailFull && awsError.Message() != "" {
+					message += ": " + awsError.Message()
+				}
+				writeError(c, http.StatusBadRequest, awsError.Code(), message, requestID)
 			case "NotModified":
-				http.Error(w, "Object not modified", http.StatusNotModified)
-			case "NoSuchKey", "NotFound":
-				http.Error(w, "Path '"+path+"' not found: "+awsError.Message(), http.StatusNotFound)
+				writeError(c, http.StatusNotModified, awsError.Code(), "Object not modified", requestID)
+			case "PreconditionFailed":
+				writeError(c, http.StatusPreconditionFailed, awsError.Code(), "Precondition failed for path '"+path+"'", requestID)
+			case "ConditionalRequestConflict":
+				// A concurrent conditional write of the same key
+				writeError(c, http.StatusConflict, awsError.Code(), "Conflicting write of path '"+path+"'", requestID)
+			case "InvalidRange":
+				writeError(c, http.StatusRequestedRangeNotSatisfiable, awsError.Code(), "Requested range not satisfiable", requestID)
+			case "NoSuchKey", "NotFound", "NoSuchVersion":
+				message := "Path '" + path + "' not found"
+				if configOf(c).ErrorDetail == errorDetailFull {
+					message += ": " + awsError.Message()
+				}
+				writeError(c, http.StatusNotFound, "NotFound", message, requestID)
+			case "NoSuchUpload":
+				writeError(c, http.StatusNotFound, awsError.Code(), "Upload not found", requestID)
 			default:
 				origErr := awsError.OrigErr()
 				cause := ""
 				if origErr != nil {
 					cause = " (Cause: " + origErr.Error() + ")"
 				}
-				http.Error(w, "An internal error occurred: "+awsError.Code()+" = "+awsError.Message()+cause, http.StatusInternalServerError)
+				writeInternalError(c, awsError.Code(), awsError.Code()+" = "+awsError.Message()+cause, requestID)
 			}
 		} else {
-			log.Debugf("Failed : %v", err)
+			requestLog(c).Debugf("Failed : %v", err)
 			// golang error
-			http.Error(w, "An internal error occurred: "+err.Error(), http.StatusInternalServerError)
+			writeInternalError(c, "InternalError", err.Error(), requestID)
 		}
 	}
 	return err
 }
 
+// Set up the S3 (and KMS) clients from the configuration
+func setupAWS(config *webConfig) error {
+	awsConfig := &aws.Config{
+		Region:                  aws.String(config.AwsRegion),
+		S3UseARNRegion:          aws.Bool(config.UseArnRegion),
+		EnforceShouldRetryCheck: aws.Bool(true),
+		S3ForcePathStyle:        aws.Bool(config.ForcePathStyle),
+		DisableSSL:              aws.Bool(config.DisableSSL),
+		// The keys are sent as validated, never cleaned into another key
+		DisableRestProtocolURICleaning: aws.Bool(true),
+	}
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	if config.UseFIPSEndpoint {
+		awsConfig.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if config.UseDualStack {
+		awsConfig.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+	sess, err := newAWSSession(config.Credentials, config.AwsRegion)
+	if err != nil {
+		return err
+	}
+	awsConfig.HTTPClient = newS3HTTPClient(config.Timeouts, sess.Config.HTTPClient)
+	s3Session = s3.New(sess, request.WithRetryer(awsConfig, newS3Retryer(config.Retry)))
+	registerStoredEncoding(s3Session)
+	if config.RequesterPays {
+		registerRequesterPays(s3Session)
+	}
+	if config.CircuitBreaker.Fallback.enabled() {
+		fallback = newReplicaTarget(sess, config.CircuitBreaker.Fallback, config)
+	}
+	if len(config.Replicas.Regions) > 0 {
+		replicas = newReadReplicas(sess, config.Replicas, config)
+	}
+	uploader = s3manager.NewUploaderWithClient(s3Session, func(u *s3manager.Uploader) {
+		u.PartSize = config.Upload.PartSize
+		u.Concurrency = config.Upload.Concurrency
+	})
+	if usesKMS(config.Encryption) {
+		kmsSession = kms.New(sess, &aws.Config{Region: aws.String(config.AwsRegion), UseFIPSEndpoint: awsConfig.UseFIPSEndpoint})
+	}
+	if config.CacheEvents.enabled() {
+		sqsConfig := &aws.Config{UseFIPSEndpoint: awsConfig.UseFIPSEndpoint}
+		if config.CacheEvents.Region != "" {
+			sqsConfig.Region = aws.String(config.CacheEvents.Region)
+		}
+		if config.CacheEvents.Endpoint != "" {
+			sqsConfig.Endpoint = aws.String(config.CacheEvents.Endpoint)
+		}
+		sqsSession = sqs.New(sess, sqsConfig)
+	}
+	return nil
+}
+
 // main
 func main() {
 	log.SetLevel(log.InfoLevel)
 	log.Printf("S3WebServer By B.LEBOEUF %s", showVersion())
-	configFile := flag.String("config", "config.toml", "`config file`")
+	configFile := flag.String("config", defaultConfigFile, "`config file`")
 	debug := flag.Bool("debug", false, "`Mode debug`")
+	strictStartup := flag.Bool("strict-startup", false, "`Exit` if the startup checks of the bucket fail")
+	registerConfigFlags(flag.CommandLine)
 
 	flag.Parse()
 
+	if flag.Arg(0) == "doctor" {
+		os.Exit(runDoctor(*configFile, flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "check" {
+		os.Exit(runCheck(*configFile, flag.Args()[1:]))
+	}
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 		gin.SetMode(gin.DebugMode)
@@ -277,52 +952,169 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
-	configHolder = &confHolder{config}
+	currentConfig.Store(config)
+	loadedConfigPath = *configFile
 
 	// Set up the S3 connection
-	s3Session = s3.New(session.New(), &aws.Config{Region: aws.String(config.AwsRegion)})
+	if err := setupAWS(config); err != nil {
+		log.Fatalf("Failed to set up the AWS session: %v", err)
+	}
+	registerUsageHandlers(s3Session)
+	registerRequestTracing(s3Session)
+	var shutdownTracing func(context.Context) error
+	if config.Tracing.Enabled {
+		shutdownTracing, err = setupTracing(config.Tracing)
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+		registerS3Tracing(s3Session)
+	}
+	if config.CircuitBreaker.Enabled {
+		breaker, err = newCircuitBreaker(config.CircuitBreaker)
+		if err != nil {
+			log.Fatalf("Failed to set up circuit breaker: %v", err)
+		}
+		breaker.register(s3Session)
+	}
+
+	// Check the S3 access before serving
+	problems := preflight(s3Session, config)
+	for _, problem := range problems {
+		log.Warnf("Startup check failed: %v", problem)
+	}
+	if len(problems) > 0 && *strictStartup {
+		log.Fatalf("Startup checks failed, exiting (strict startup)")
+	}
 
 	// Instanciate router
-	router := gin.Default()
+	router := gin.New()
+	// The client IP is resolved from the headers of the trusted proxies only
+	router.ForwardedByClientIP = false
+	accessLog := accessLogMiddleware(config.AccessLog)
+	router.Use(configMiddleware, clientIPMiddleware, requestIDMiddleware, accessLog, gin.Recovery())
+	if config.Tracing.Enabled {
+		router.Use(tracingMiddleware)
+	}
+	router.Use(inflightMiddleware, trafficMiddleware)
+	if config.Protocols.HTTP3.Enabled {
+		router.Use(altSvcMiddleware(config.Protocols.HTTP3))
+	}
+	if config.IPFilter.enabled() {
+		router.Use(ipFilterMiddleware)
+	}
+	if config.RateLimit.enabled() {
+		router.Use(rateLimitMiddleware(newRateLimiter(config.RateLimit)))
+	}
 
 	// Add middleware
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	if config.CORS.enabled() {
+		router.Use(corsMiddleware)
+	}
+	if config.Compression.level() != gzip.NoCompression {
+		router.Use(compressMiddleware(config.Compression))
+	}
+	if config.Auth.enabled() {
+		router.Use(authMiddleware)
+	} else {
+		log.Warnf("Authentication is disabled, anyone reaching the port can upload and delete objects")
+		if !config.Admin.enabled() {
+			log.Warnf("The admin endpoints are disabled, they need auth or an admin port")
+		}
+	}
+	if len(config.Headers) > 0 {
+		router.Use(headerRulesMiddleware)
+	}
+	exts, err := loadExtensions(config.Plugins)
+	if err != nil {
+		log.Fatalf("Failed to load extensions: %v", err)
+	}
+	if len(exts) > 0 {
+		router.Use(extensionMiddleware(exts))
+	}
+	if config.MemoryCache.Enabled {
+		caches = append(caches, newMemoryCache(config.MemoryCache))
+	}
+	if config.DiskCache.Enabled {
+		diskCache, err := newDiskCache(config.DiskCache)
+		if err != nil {
+			log.Fatalf("Failed to create the disk cache: %v", err)
+		}
+		caches = append(caches, diskCache)
+		log.Infof("Caching the objects in %s", diskCache.cfg.Dir)
+	}
+	if config.CacheEvents.enabled() {
+		go watchCacheEvents(config.CacheEvents)
+	}
+	chaos.set(config.Chaos)
+	if config.Chaos.Enabled {
+		log.Warnf("Chaos mode is enabled, faults are injected in the responses")
+		router.Use(chaosMiddleware)
+	}
 
 	// Init http route
-	router.NoRoute(methodHandler)
+	registerRoutes(router)
+	startDiagnostics()
 
 	// Start HTTP Server
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", config.Port),
-		Handler: router,
+	srv := newHTTPServer(fmt.Sprintf(":%s", config.Port), tcpHandler(router, config.Protocols), config.Server)
+	var quicServer *http3.Server
+	if config.TLS.enabled() {
+		tlsConfig, err := serverTLSConfig(config.TLS)
+		if err != nil {
+			log.Fatalf("Failed to load the certificates: %v", err)
+		}
+		srv.TLSConfig = tlsConfig
+		if config.Protocols.HTTP3.Enabled {
+			quicServer = startHTTP3(router, tlsConfig, config.Protocols.HTTP3, config.Server)
+		}
 	}
 
 	go func() {
 		// service connections
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(srv, config.Server); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	var adminServer *http.Server
+	if config.Admin.enabled() {
+		adminServer = startAdmin(newAdminRouter(accessLog), config.Admin, config.Server)
+	}
+
+	var sftpListener net.Listener
+	if config.SFTP.enabled() {
+		var err error
+		if sftpListener, err = startSFTP(config.SFTP, config.Auth); err != nil {
+			log.Fatalf("sftp listen: %s\n", err)
+		}
+	}
+
+	// Wait for interrupt signal to gracefully shutdown the server, the in-flight requests
+	// are drained up to the shutdown timeout.
+	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall.SIGKILL but can't be catch, so don't need add it
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Infoln("Shutdown Server ...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(GracefullTimeout)*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server Shutdown:", err)
+	if sftpListener != nil {
+		// The open SFTP sessions end with the process
+		sftpListener.Close()
 	}
-	// catching ctx.Done(). timeout of 5 seconds.
-	select {
-	case <-ctx.Done():
-		log.Errorln("timeout of 5 seconds.")
+	shutdownServer(srv, quicServer, config.Shutdown)
+	if adminServer != nil {
+		adminServer.Close()
+	}
+	if logs != nil {
+		logs.flush()
+	}
+	if shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Warnf("Failed to export the last spans: %v", err)
+		}
 	}
 	log.Infoln("Server exiting")
 }
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+)
+
+// Last modification of the objects of the fake bucket, as S3 sends it
+const testLastModified = "Fri, 02 Jan 2026 03:04:05 GMT"
+
+// Content of the objects of the fake bucket
+const testContent = "hello world"
+
+// Object of the fake S3
+type fakeObject struct {
+	body   []byte
+	header http.Header
+}
+
+// In-memory S3, the objects are stored by "bucket/key"
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+// Store an object
+func (s *fakeS3) put(path, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[path] = fakeObject{body: []byte(content), header: http.Header{"Content-Type": {"text/plain"}}}
+}
+
+// Get the content of an object, false if it does not exist
+func (s *fakeS3) get(path string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[path]
+	return string(object.body), ok
+}
+
+// Write an S3 error
+func writeFakeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+	}
+}
+
+// Write an XML response
+func writeFakeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// Keys of a bucket under a prefix, sorted
+func (s *fakeS3) keys(bucket, prefix string) []string {
+	var keys []string
+	for path := range s.objects {
+		if key := strings.TrimPrefix(path, bucket+"/"); key != path && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Fake S3 XML types, with the fields used by the server only
+type (
+	fakeListContent struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	fakeListPrefix struct {
+		Prefix string
+	}
+	fakeListResult struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		KeyCount       int
+		MaxKeys        int
+		IsTruncated    bool
+		Contents       []fakeListContent
+		CommonPrefixes []fakeListPrefix
+	}
+	fakeVersion struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	fakeVersionsResult struct {
+		XMLName     xml.Name `xml:"ListVersionsResult"`
+		Name        string
+		Prefix      string
+		IsTruncated bool
+		Version     []fakeVersion
+	}
+	fakeDeleted struct {
+		Key string
+	}
+	fakeDeleteResult struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Deleted []fakeDeleted
+	}
+	fakeCopyResult struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}
+)
+
+// Serve the S3 requests
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	query := r.URL.Query()
+	const lastModified = "2026-01-02T03:04:05.000Z"
+	switch {
+	case key == "" && query.Has("versions"):
+		result := fakeVersionsResult{Name: bucket, Prefix: query.Get("prefix")}
+		for _, k := range s.keys(bucket, query.Get("prefix")) {
+			result.Version = append(result.Version, fakeVersion{Key: k, VersionId: "null", IsLatest: true, LastModified: lastModified, ETag: `"etag"`, Size: len(s.objects[bucket+"/"+k].body)})
+		}
+		writeFakeS3XML(w, result)
+	case key == "" && r.Method == http.MethodGet:
+		prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+		result := fakeListResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+		seen := map[string]bool{}
+		for _, k := range s.keys(bucket, prefix) {
+			if i := strings.Index(k[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+				if p := k[:len(prefix)+i+len(delimiter)]; !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, fakeListPrefix{Prefix: p})
+				}
+				continue
+			}
+			result.Contents = append(result.Contents, fakeListContent{Key: k, LastModified: lastModified, ETag: `"etag"`, Size: len(s.objects[bucket+"/"+k].body), StorageClass: "STANDARD"})
+		}
+		result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+		writeFakeS3XML(w, result)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		var input struct {
+			Object []struct{ Key string }
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+			break
+		}
+		var result fakeDeleteResult
+		for _, o := range input.Object {
+			delete(s.objects, bucket+"/"+o.Key)
+			result.Deleted = append(result.Deleted, fakeDeleted{Key: o.Key})
+		}
+		writeFakeS3XML(w, result)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		object, ok := s.objects[source]
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+			break
+		}
+		s.objects[path] = object
+		writeFakeS3XML(w, fakeCopyResult{ETag: `"etag"`, LastModified: lastModified})
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		header := http.Header{}
+		for name, values := range r.Header {
+			if name == "Content-Type" || strings.HasPrefix(name, "X-Amz-Meta-") || strings.HasPrefix(name, "X-Amz-Server-Side-Encryption") {
+				header[name] = values
+			}
+		}
+		s.objects[path] = fakeObject{body: body, header: header}
+		w.Header().Set("Etag", `"etag"`)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[path]
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+			break
+		}
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Last-Modified", testLastModified)
+		w.Header().Set("Etag", `"etag"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		first, last, ok := fakeRange(r.Header.Get("Range"), len(object.body))
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			break
+		}
+		status := http.StatusOK
+		if r.Header.Get("Range") != "" {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(object.body)))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(last-first+1))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(object.body[first : last+1])
+		}
+	default:
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// Parse a single byte range of the fake S3, the whole object without range
+func fakeRange(header string, size int) (int, int, bool) {
+	if header == "" {
+		return 0, size - 1, true
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	from, to, found := strings.Cut(spec, "-")
+	if !ok || !found {
+		return 0, 0, false
+	}
+	if from == "" {
+		n, err := strconv.Atoi(to)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	first, err := strconv.Atoi(from)
+	if err != nil || first >= size {
+		return 0, 0, false
+	}
+	last := size - 1
+	if to != "" {
+		if last, err = strconv.Atoi(to); err != nil || last < first {
+			return 0, 0, false
+		}
+	}
+	return first, min(last, size-1), true
+}
+
+// Serve the requests of the tests with the routes of the server and a fake S3 whose
+// bucket "bucket" holds hello.txt
+func newTestServer(t *testing.T, config *webConfig) (*gin.Engine, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{objects: map[string]fakeObject{}}
+	fake.put("bucket/hello.txt", testContent)
+	s3Server := httptest.NewServer(fake)
+	t.Cleanup(s3Server.Close)
+
+	config, err := setConfigDefaults(config)
+	if err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(s3Server.URL),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	previousSession, previousUploader, previousConfig := s3Session, uploader, currentConfig.Load()
+	s3Session = s3.New(sess)
+	uploader = s3manager.NewUploaderWithClient(s3Session)
+	currentConfig.Store(config)
+	t.Cleanup(func() {
+		s3Session, uploader = previousSession, previousUploader
+		currentConfig.Store(previousConfig)
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(configMiddleware)
+	if config.Auth.enabled() {
+		router.Use(authMiddleware)
+	}
+	registerRoutes(router)
+	return router, fake
+}
+
+// Send a request to the test server
+func serveTestRequest(router *gin.Engine, method, path string, header http.Header) *httptest.ResponseRecorder {
+	return serveTestBody(router, method, path, header, "")
+}
+
+// Send a request with a body to the test server
+func serveTestBody(router *gin.Engine, method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// Header authenticating a request with a bearer token
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// OIDC issuer of the tests, serving its signing key
+type testIssuer struct {
+	key *rsa.PrivateKey
+	url string
+}
+
+// Start an OIDC issuer, the signing keys of the previous issuers are forgotten
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kid: "test",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+	previousKeys := jwks
+	jwks = &jwksCache{}
+	t.Cleanup(func() { jwks = previousKeys })
+	return &testIssuer{key: key, url: server.URL}
+}
+
+// OIDC config accepting the tokens of the issuer
+func (i *testIssuer) config(permissions ...oidcPermission) oidcConfig {
+	return oidcConfig{Issuer: i.url, Audience: "s3webserver", JWKSURL: i.url + "/keys", Permissions: permissions}
+}
+
+// Sign a token of the issuer for a client in some groups
+func (i *testIssuer) token(t *testing.T, groups ...string) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": "test"}) + "." +
+		encode(map[string]interface{}{"iss": i.url, "aud": "s3webserver", "sub": "tester", "groups": groups, "exp": time.Now().Add(time.Hour).Unix()})
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestInvalidRequestDetail(t *testing.T) {
+	tests := []struct {
+		detail  string
+		exposed bool
+	}{
+		{errorDetailFull, true},
+		{errorDetailCode, false},
+		{errorDetailGeneric, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.detail, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/secret.txt", nil)
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxConfigKey{}, &webConfig{ErrorDetail: tt.detail}))
+			err := awserr.NewRequestFailure(awserr.New("InvalidRequest", "backend detail", nil), http.StatusBadRequest, "id")
+			handleHTTPException(c, "secret.txt", err)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+			if exposed := strings.Contains(w.Body.String(), "backend detail"); exposed != tt.exposed {
+				t.Errorf("S3 message exposed = %v, want %v: %s", exposed, tt.exposed, w.Body.String())
+			}
+		})
+	}
+}
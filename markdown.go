@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Delay during which a page template read from the bucket is used without checking its ETag
+const markdownTemplateMaxAge = time.Minute
+
+// Markdown rendering config type, the .md objects are served as HTML pages to the browsers
+type markdownConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Key of the html/template of the pages in the bucket, default is a built-in page
+	Template string `json:"template" yaml:"template" toml:"template"`
+	// Largest size (in bytes) of a rendered object, the larger ones are served as is
+	MaxSize int64 `json:"maxSize" yaml:"maxSize" toml:"maxSize"`
+}
+
+// Set the markdown rendering defaults
+func (cfg *markdownConfig) validate() error {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 1 << 20
+	}
+	cfg.Template = strings.TrimPrefix(cfg.Template, "/")
+	return nil
+}
+
+// Markdown renderer with the GitHub flavored extensions, the raw HTML of the documents is left out
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// Data of the page template
+type markdownPage struct {
+	// First level 1 heading, or the file name
+	Title string
+	// Path of the document
+	Path    string
+	Content template.HTML
+}
+
+// Built-in page template
+var defaultMarkdownTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 860px; margin: 0 auto; padding: 24px; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.6; color: #222; }
+a { color: #2f6fb3; }
+pre, code { font-family: SFMono-Regular, Consolas, Menlo, monospace; background: #f5f5f5; border-radius: 3px; }
+pre { padding: 12px; overflow: auto; }
+code { padding: 2px 4px; }
+pre code { padding: 0; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 6px 12px; }
+blockquote { margin: 0; padding-left: 16px; border-left: 4px solid #ddd; color: #555; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+{{.Content}}
+</body>
+</html>
+`))
+
+// Page template read from the bucket
+type markdownTemplate struct {
+	etag    string
+	tmpl    *template.Template
+	checked time.Time
+}
+
+var (
+	markdownTemplatesMu sync.Mutex
+	markdownTemplates   = map[string]*markdownTemplate{}
+)
+
+// Get the page template of a host, read again from the bucket once its ETag changed.
+// The built-in page is used while the template does not exist.
+func pageTemplate(c *gin.Context) (*template.Template, string, error) {
+	key := configOf(c).Markdown.Template
+	if key == "" {
+		return defaultMarkdownTemplate, "", nil
+	}
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	id := cacheID(bucket, objectKey)
+	markdownTemplatesMu.Lock()
+	cached := markdownTemplates[id]
+	markdownTemplatesMu.Unlock()
+	if cached != nil && time.Since(cached.checked) < markdownTemplateMaxAge {
+		return cached.tmpl, cached.etag, nil
+	}
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(objectKey)}
+	if cached != nil && cached.etag != "" {
+		input.IfNoneMatch = aws.String(cached.etag)
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, input)
+	if errorCode(err) == "NotModified" {
+		markdownTemplatesMu.Lock()
+		cached.checked = time.Now()
+		markdownTemplatesMu.Unlock()
+		return cached.tmpl, cached.etag, nil
+	}
+	if isNotFoundError(err) {
+		// Built-in page until the template is uploaded
+		markdownTemplatesMu.Lock()
+		markdownTemplates[id] = &markdownTemplate{tmpl: defaultMarkdownTemplate, checked: time.Now()}
+		markdownTemplatesMu.Unlock()
+		return defaultMarkdownTemplate, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	source, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	tmpl, err := template.New(key).Parse(string(source))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid markdown template %s: %v", key, err)
+	}
+	cached = &markdownTemplate{etag: aws.StringValue(resp.ETag), tmpl: tmpl, checked: time.Now()}
+	markdownTemplatesMu.Lock()
+	markdownTemplates[id] = cached
+	markdownTemplatesMu.Unlock()
+	return tmpl, cached.etag, nil
+}
+
+// Check if a GET of a key is to be rendered: a markdown object asked by a browser, unless ?raw=true.
+// The responses of the markdown objects vary with Accept, rendered or not.
+func markdownRequested(c *gin.Context, key string) bool {
+	if !configOf(c).Markdown.Enabled || c.Query("raw") == "true" || c.Query("versionId") != "" {
+		return false
+	}
+	if ext := strings.ToLower(path.Ext(key)); ext != ".md" && ext != ".markdown" {
+		return false
+	}
+	addVary(c.Writer.Header(), "Accept")
+	return strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
+// Render a markdown document, the title is its first level 1 heading
+func renderMarkdown(source []byte) (template.HTML, string, error) {
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	title := ""
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := n.(*ast.Heading); ok && entering && heading.Level == 1 {
+			title = string(heading.Text(source))
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+		return "", "", err
+	}
+	return template.HTML(buf.String()), title, nil
+}
+
+// Serve a markdown object rendered as an HTML page. The objects over maxSize, encrypted or read
+// with a customer key are served as is.
+func serveMarkdown(c *gin.Context, bucket, key string) {
+	if configOf(c).encryptionFor(key) != nil || c.GetHeader(sseCustomerAlgorithmHeader) != "" {
+		serveGetS3File(c, bucket, key)
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if isNotFoundError(err) || (err == nil && (aws.Int64Value(resp.ContentLength) > configOf(c).Markdown.MaxSize || resp.ContentEncoding != nil)) {
+		if err == nil {
+			resp.Body.Close()
+		}
+		// Missing objects keep the SPA and directory fallbacks
+		serveGetS3File(c, bucket, key)
+		return
+	}
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	defer resp.Body.Close()
+	tmpl, templateETag, err := pageTemplate(c)
+	if err != nil {
+		requestLog(c).Warnf("Unable to get the markdown template: %v", err)
+		writeInternalError(c, "InternalError", "Unable to get the page template", "")
+		return
+	}
+
+	// The page changes with the document and the template
+	sum := sha256.Sum256([]byte(aws.StringValue(resp.ETag) + "/" + templateETag))
+	etag := "\"" + hex.EncodeToString(sum[:16]) + "\""
+	lastModified := aws.TimeValue(resp.LastModified)
+	header := c.Writer.Header()
+	header.Set("Etag", etag)
+	header.Set("Last-Modified", httpDate(lastModified))
+	configOf(c).setExpiryHeaders(header, key)
+	status := readPreconditionStatus(c.Request, etag, lastModified)
+	if status == http.StatusPreconditionFailed {
+		writeError(c, status, "PreconditionFailed", "Object '"+key+"' does not match the preconditions", "")
+		return
+	}
+	if status == http.StatusNotModified {
+		c.Status(status)
+		return
+	}
+	source, err := ioutil.ReadAll(resp.Body)
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	content, title, err := renderMarkdown(source)
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	if title == "" {
+		title = path.Base(key)
+	}
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, markdownPage{Title: title, Path: c.Request.URL.Path, Content: content}); err != nil {
+		requestLog(c).Warnf("Unable to render %s: %v", key, err)
+		writeInternalError(c, "InternalError", "Unable to render the page", "")
+		return
+	}
+	usage.addBytesOut(int64(page.Len()))
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Prefix of the user metadata headers
+const metadataHeaderPrefix = "X-Amz-Meta-"
+
+// Prefix of the metadata keys set by the server, never accepted from the clients
+const serverMetadataPrefix = "s3ws-"
+
+// Copy the content headers and the user metadata of an upload request to the upload input
+func applyObjectHeaders(header http.Header, input *s3manager.UploadInput) {
+	for name, target := range map[string]**string{
+		"Content-Type":        &input.ContentType,
+		"Cache-Control":       &input.CacheControl,
+		"Content-Disposition": &input.ContentDisposition,
+		"Content-Encoding":    &input.ContentEncoding,
+		"Content-Language":    &input.ContentLanguage,
+	} {
+		if value := header.Get(name); value != "" {
+			*target = aws.String(value)
+		}
+	}
+	for name, values := range header {
+		key := strings.ToLower(strings.TrimPrefix(name, metadataHeaderPrefix))
+		if !strings.HasPrefix(name, metadataHeaderPrefix) || key == "" || strings.HasPrefix(key, serverMetadataPrefix) {
+			continue
+		}
+		if input.Metadata == nil {
+			input.Metadata = map[string]*string{}
+		}
+		input.Metadata[key] = aws.String(strings.Join(values, ","))
+	}
+}
+
+// Set the stored content headers and user metadata of an object on a response, without
+// replacing the Cache-Control and Content-Disposition already set by the handler
+func setObjectMetadataHeaders(header http.Header, cacheControl, contentDisposition, contentLanguage *string, metadata map[string]*string) {
+	if header.Get("Cache-Control") == "" && aws.StringValue(cacheControl) != "" {
+		header.Set("Cache-Control", *cacheControl)
+	}
+	if header.Get("Content-Disposition") == "" && aws.StringValue(contentDisposition) != "" {
+		header.Set("Content-Disposition", *contentDisposition)
+	}
+	if aws.StringValue(contentLanguage) != "" {
+		header.Set("Content-Language", *contentLanguage)
+	}
+	for key, value := range metadata {
+		if !strings.HasPrefix(strings.ToLower(key), serverMetadataPrefix) {
+			header.Set(metadataHeaderPrefix+key, aws.StringValue(value))
+		}
+	}
+}
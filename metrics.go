@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Write a metric with its help and type lines, the labels are pairs of name and value
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, sample := range samples {
+		labels := ""
+		for i := 0; i+1 < len(sample.labels); i += 2 {
+			if labels != "" {
+				labels += ","
+			}
+			labels += fmt.Sprintf("%s=%q", sample.labels[i], sample.labels[i+1])
+		}
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s%s %v\n", name, labels, sample.value)
+	}
+}
+
+// Metric sample type
+type metricSample struct {
+	labels []string
+	value  interface{}
+}
+
+// Sample without labels
+func sample(value interface{}) metricSample {
+	return metricSample{value: value}
+}
+
+// Name of a cache in the metrics
+func (cache *objectCache) name() string {
+	if cache.cfg.Dir == "" {
+		return "memory"
+	}
+	return "disk"
+}
+
+// Serve the metrics in the Prometheus text format
+func serveMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := c.Writer
+	writeMetric(w, "s3ws_uptime_seconds", "gauge", "Time since the server started.",
+		sample(int64(time.Since(usage.started).Seconds())))
+	writeMetric(w, "s3ws_inflight_requests", "gauge", "Requests being served.", sample(drain.active()))
+	writeMetric(w, "s3ws_s3_requests_total", "counter", "S3 request attempts by pricing class.",
+		metricSample{[]string{"class", "get"}, atomic.LoadInt64(&usage.gets)},
+		metricSample{[]string{"class", "put"}, atomic.LoadInt64(&usage.puts)},
+		metricSample{[]string{"class", "list"}, atomic.LoadInt64(&usage.lists)})
+	writeMetric(w, "s3ws_sent_bytes_total", "counter", "Bytes sent to the clients.", sample(atomic.LoadInt64(&usage.bytesOut)))
+	writeMetric(w, "s3ws_received_bytes_total", "counter", "Bytes received from the clients.", sample(atomic.LoadInt64(&usage.bytesIn)))
+
+	retries.mu.Lock()
+	retried, exhausted := retries.Retries, retries.Exhausted
+	retries.mu.Unlock()
+	writeMetric(w, "s3ws_s3_retries_total", "counter", "Retried S3 calls.", sample(retried))
+	writeMetric(w, "s3ws_s3_retries_exhausted_total", "counter", "S3 calls failing after all the retries.", sample(exhausted))
+
+	open := 0
+	if breaker != nil && breaker.isOpen() {
+		open = 1
+	}
+	writeMetric(w, "s3ws_circuit_open", "gauge", "1 while the circuit breaker is open.", sample(open))
+
+	var entries, sizes []metricSample
+	for _, cache := range caches {
+		cache.mu.Lock()
+		entries = append(entries, metricSample{[]string{"cache", cache.name()}, len(cache.entries)})
+		sizes = append(sizes, metricSample{[]string{"cache", cache.name()}, cache.size})
+		cache.mu.Unlock()
+	}
+	writeMetric(w, "s3ws_cache_entries", "gauge", "Cached objects.", entries...)
+	writeMetric(w, "s3ws_cache_bytes", "gauge", "Size of the cached objects.", sizes...)
+}
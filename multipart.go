@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Multipart upload creation request type
+type multipartRequest struct {
+	Key         string `json:"key" binding:"required"`
+	ContentType string `json:"contentType"`
+}
+
+// Multipart upload response type
+type multipartResponse struct {
+	Key       string `json:"key"`
+	UploadID  string `json:"uploadId"`
+	ETag      string `json:"etag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+}
+
+// Part of a multipart upload
+type multipartPart struct {
+	PartNumber int64  `json:"partNumber"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size,omitempty"`
+}
+
+// Multipart upload completion request type, the parts in order
+type multipartCompleteRequest struct {
+	Parts []multipartPart `json:"parts" binding:"required"`
+}
+
+// Check the key of a multipart upload request and the access of the client to it.
+// Returns "" if the key is refused and the response has been written.
+func multipartKey(c *gin.Context, key string) string {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || strings.HasSuffix(key, "/") || configOf(c).isHiddenKey(key) {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid multipart upload key", "")
+		return ""
+	}
+	if !methodAllowed(http.MethodPut) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Method PUT not allowed", "")
+		return ""
+	}
+	if !checkKeyAccess(c, http.MethodPut, key) {
+		return ""
+	}
+	return key
+}
+
+// Initiate a multipart upload, whose parts are then uploaded in any order and in parallel
+func serveMultipartCreate(c *gin.Context) {
+	config := configOf(c)
+	var req multipartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid multipart upload request: "+err.Error(), "")
+		return
+	}
+	key := multipartKey(c, config.normalizeUploadKey(strings.TrimPrefix(req.Key, "/")))
+	if key == "" {
+		return
+	}
+	bucket, objectKey := config.resolveObject(c.Request.Host, key)
+	if config.encryptionFor(objectKey) != nil {
+		// The encryption needs the whole content at once
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Objects under an encryption prefix cannot be uploaded by parts", "")
+		return
+	}
+	if req.ContentType == "" {
+		req.ContentType = config.objectContentType(key, nil)
+	}
+	input := &s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(objectKey), ContentType: aws.String(req.ContentType)}
+	if err := applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
+		return
+	}
+	config.applyServerSideEncryption(input.SSECustomerAlgorithm, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+	ctx, cancel := s3Context(c.Request.Context(), config.Timeouts.Put)
+	defer cancel()
+	resp, err := s3Session.CreateMultipartUploadWithContext(ctx, input)
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	requestLog(c).Infof("Multipart upload %s created for %s", aws.StringValue(resp.UploadId), key)
+	c.JSON(http.StatusCreated, multipartResponse{Key: key, UploadID: aws.StringValue(resp.UploadId)})
+}
+
+// Upload a part of a multipart upload. The part is written to a temporary file first,
+// as its signature needs the whole content.
+func serveMultipartPart(c *gin.Context) {
+	key := multipartKey(c, c.Query("key"))
+	if key == "" {
+		return
+	}
+	number, err := strconv.ParseInt(c.Param("partNumber"), 10, 64)
+	if err != nil || number < 1 || number > 10000 {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "partNumber must be between 1 and 10000", "")
+		return
+	}
+	if !limitUploadSize(c) {
+		return
+	}
+	f, err := ioutil.TempFile("", "s3webserver-part-*")
+	if err != nil {
+		writeInternalError(c, "InternalError", "Failed to buffer the part: "+err.Error(), "")
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, c.Request.Body)
+	usage.addBytesIn(size)
+	if isUploadTooLarge(err) {
+		writeUploadTooLarge(c)
+		return
+	}
+	if err != nil {
+		requestLog(c).Debugf("Part %d of %s interrupted: %v", number, key, err)
+		writeError(c, http.StatusBadRequest, "IncompleteBody", "Part body interrupted", "")
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		writeInternalError(c, "InternalError", "Failed to buffer the part: "+err.Error(), "")
+		return
+	}
+
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(objectKey),
+		UploadId:      aws.String(c.Param("uploadId")),
+		PartNumber:    aws.Int64(number),
+		Body:          f,
+		ContentLength: aws.Int64(size),
+	}
+	if err := applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	resp, err := s3Session.UploadPartWithContext(ctx, input)
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	c.Header("ETag", aws.StringValue(resp.ETag))
+	c.JSON(http.StatusOK, multipartPart{PartNumber: number, ETag: aws.StringValue(resp.ETag), Size: size})
+}
+
+// List the parts uploaded, e.g. to resume an upload
+func serveMultipartParts(c *gin.Context) {
+	key := multipartKey(c, c.Query("key"))
+	if key == "" {
+		return
+	}
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	parts := []multipartPart{}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.List)
+	defer cancel()
+	input := &s3.ListPartsInput{Bucket: aws.String(bucket), Key: aws.String(objectKey), UploadId: aws.String(c.Param("uploadId"))}
+	err := s3Session.ListPartsPagesWithContext(ctx, input, func(page *s3.ListPartsOutput, last bool) bool {
+		for _, part := range page.Parts {
+			parts = append(parts, multipartPart{PartNumber: aws.Int64Value(part.PartNumber), ETag: aws.StringValue(part.ETag), Size: aws.Int64Value(part.Size)})
+		}
+		return true
+	})
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "uploadId": c.Param("uploadId"), "parts": parts})
+}
+
+// Complete a multipart upload with its parts, the object is created
+func serveMultipartComplete(c *gin.Context) {
+	key := multipartKey(c, c.Query("key"))
+	if key == "" {
+		return
+	}
+	var req multipartCompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Parts) == 0 {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid multipart completion, the parts are required", "")
+		return
+	}
+	parts := make([]*s3.CompletedPart, len(req.Parts))
+	for i, part := range req.Parts {
+		parts[i] = &s3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)}
+	}
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	resp, err := s3Session.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(objectKey),
+		UploadId:        aws.String(c.Param("uploadId")),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	caches.invalidate(bucket, objectKey)
+	requestLog(c).Infof("Multipart upload %s of %s completed with %d parts", c.Param("uploadId"), key, len(parts))
+	c.JSON(http.StatusOK, multipartResponse{Key: key, UploadID: c.Param("uploadId"), ETag: aws.StringValue(resp.ETag), VersionID: aws.StringValue(resp.VersionId)})
+}
+
+// Abort a multipart upload, the parts uploaded are deleted
+func serveMultipartAbort(c *gin.Context) {
+	key := multipartKey(c, c.Query("key"))
+	if key == "" {
+		return
+	}
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Delete)
+	defer cancel()
+	_, err := s3Session.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(objectKey), UploadId: aws.String(c.Param("uploadId"))})
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	requestLog(c).Infof("Multipart upload %s of %s aborted", c.Param("uploadId"), key)
+	c.Status(http.StatusNoContent)
+}
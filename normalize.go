@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Key normalization rules
+const (
+	// Lowercase the key
+	normalizeLowercase = "lowercase"
+	// Replace whitespace sequences by a dash, trimming them around the path segments
+	normalizeWhitespace = "whitespace"
+	// Unicode canonical composition (NFC)
+	normalizeNFC = "nfc"
+	// Strip control characters
+	normalizeControl = "control"
+)
+
+// Key normalization config type, rules are applied to the uploaded keys starting with Prefix
+type keyNormalization struct {
+	Prefix string   `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Rules  []string `json:"rules" yaml:"rules" toml:"rules"`
+}
+
+// Check the key normalization rules
+func validateKeyNormalization(normalizations []keyNormalization) error {
+	for _, n := range normalizations {
+		for _, rule := range n.Rules {
+			switch rule {
+			case normalizeLowercase, normalizeWhitespace, normalizeNFC, normalizeControl:
+			default:
+				return fmt.Errorf("Unknown key normalization rule %s (support only lowercase, whitespace, nfc or control)", rule)
+			}
+		}
+	}
+	return nil
+}
+
+// Normalize the key of an uploaded object, with the rules of the longest matching prefix
+func (config *webConfig) normalizeUploadKey(key string) string {
+	var match *keyNormalization
+	for i, n := range config.KeyNormalization {
+		if strings.HasPrefix(key, n.Prefix) && (match == nil || len(n.Prefix) > len(match.Prefix)) {
+			match = &config.KeyNormalization[i]
+		}
+	}
+	if match == nil {
+		return key
+	}
+	for _, rule := range match.Rules {
+		switch rule {
+		case normalizeLowercase:
+			key = strings.ToLower(key)
+		case normalizeWhitespace:
+			segments := strings.Split(key, "/")
+			for i, segment := range segments {
+				segments[i] = strings.Join(strings.FieldsFunc(segment, unicode.IsSpace), "-")
+			}
+			key = strings.Join(segments, "/")
+		case normalizeNFC:
+			key = norm.NFC.String(key)
+		case normalizeControl:
+			key = strings.Map(func(r rune) rune {
+				if unicode.IsControl(r) {
+					return -1
+				}
+				return r
+			}, key)
+		}
+	}
+	return key
+}
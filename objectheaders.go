@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Format a date header (Last-Modified, Expires...) as the HTTP date format of RFC 7231,
+// the only one the browsers send back in If-Modified-Since
+func httpDate(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// Object attributes sent as the headers of the HEAD and GET responses
+type objectHeaders struct {
+	contentType          *string
+	contentLength        int64
+	lastModified         time.Time
+	etag                 string
+	versionID            *string
+	cacheControl         *string
+	contentDisposition   *string
+	contentLanguage      *string
+	contentEncoding      string
+	metadata             map[string]*string
+	sseCustomerAlgorithm *string
+	sseCustomerKeyMD5    *string
+}
+
+// Get the response headers of a HEAD
+func headObjectHeaders(resp *s3.HeadObjectOutput) objectHeaders {
+	return objectHeaders{
+		contentType:          resp.ContentType,
+		contentLength:        aws.Int64Value(resp.ContentLength),
+		lastModified:         aws.TimeValue(resp.LastModified),
+		etag:                 aws.StringValue(resp.ETag),
+		versionID:            resp.VersionId,
+		cacheControl:         resp.CacheControl,
+		contentDisposition:   resp.ContentDisposition,
+		contentLanguage:      resp.ContentLanguage,
+		contentEncoding:      aws.StringValue(resp.ContentEncoding),
+		metadata:             resp.Metadata,
+		sseCustomerAlgorithm: resp.SSECustomerAlgorithm,
+		sseCustomerKeyMD5:    resp.SSECustomerKeyMD5,
+	}
+}
+
+// Get the response headers of a GET
+func getObjectHeaders(resp *s3.GetObjectOutput) objectHeaders {
+	return objectHeaders{
+		contentType:          resp.ContentType,
+		contentLength:        aws.Int64Value(resp.ContentLength),
+		lastModified:         aws.TimeValue(resp.LastModified),
+		etag:                 aws.StringValue(resp.ETag),
+		versionID:            resp.VersionId,
+		cacheControl:         resp.CacheControl,
+		contentDisposition:   resp.ContentDisposition,
+		contentLanguage:      resp.ContentLanguage,
+		contentEncoding:      aws.StringValue(resp.ContentEncoding),
+		metadata:             resp.Metadata,
+		sseCustomerAlgorithm: resp.SSECustomerAlgorithm,
+		sseCustomerKeyMD5:    resp.SSECustomerKeyMD5,
+	}
+}
+
+// Check if the stored gzip encoding of an object is decoded for a client which does not accept it
+func (h objectHeaders) decodedFor(r *http.Request) bool {
+	return h.contentEncoding == "gzip" && !acceptsGzipEncoding(r)
+}
+
+// Set the headers of an object response, built here only so that a HEAD always gets the headers of
+// the GET of the same request
+func (h objectHeaders) set(r *http.Request, header http.Header, key string) {
+	header.Set("Content-Type", configFrom(r.Context()).objectContentType(key, h.contentType))
+	header.Set("Content-Length", strconv.FormatInt(h.contentLength, 10))
+	header.Set("Last-Modified", httpDate(h.lastModified))
+	header.Set("Etag", h.etag)
+	header.Set("Accept-Ranges", "bytes")
+	setVersionHeader(header, h.versionID)
+	if isEncrypted(h.metadata) {
+		setEncryptedHeaders(header, h.metadata)
+	}
+	setSSECustomerHeaders(header, h.sseCustomerAlgorithm, h.sseCustomerKeyMD5)
+	setObjectMetadataHeaders(header, h.cacheControl, h.contentDisposition, h.contentLanguage, h.metadata)
+	configFrom(r.Context()).setExpiryHeaders(header, key)
+	if h.contentEncoding == "" {
+		return
+	}
+	// The object is stored compressed, the representation depends on the client encodings
+	addVary(header, "Accept-Encoding")
+	if h.decodedFor(r) {
+		// Decoded while it is sent, ranges are of the stored encoding
+		header.Del("Content-Length")
+		header.Set("Accept-Ranges", "none")
+		return
+	}
+	header.Set("Content-Encoding", h.contentEncoding)
+}
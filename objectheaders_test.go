@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestHTTPDate(t *testing.T) {
+	paris := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name string
+		time time.Time
+		want string
+	}{
+		{"utc", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), "Fri, 02 Jan 2026 03:04:05 GMT"},
+		{"converted to utc", time.Date(2026, 1, 2, 4, 4, 5, 0, paris), "Fri, 02 Jan 2026 03:04:05 GMT"},
+		{"previous day in utc", time.Date(2026, 1, 2, 0, 30, 0, 0, paris), "Thu, 01 Jan 2026 23:30:00 GMT"},
+		{"sub-second dropped", time.Date(2026, 1, 2, 3, 4, 5, 999999999, time.UTC), "Fri, 02 Jan 2026 03:04:05 GMT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := httpDate(tt.time)
+			if got != tt.want {
+				t.Errorf("httpDate(%v) = %q, want %q", tt.time, got, tt.want)
+			}
+			if _, err := time.Parse(http.TimeFormat, got); err != nil {
+				t.Errorf("httpDate(%v) = %q is not in http.TimeFormat: %v", tt.time, got, err)
+			}
+		})
+	}
+}
+
+func TestObjectHeadersLastModified(t *testing.T) {
+	lastModified := time.Date(2026, 1, 2, 4, 4, 5, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name    string
+		headers objectHeaders
+	}{
+		{"get", getObjectHeaders(&s3.GetObjectOutput{LastModified: aws.Time(lastModified), ContentLength: aws.Int64(11)})},
+		{"head", headObjectHeaders(&s3.HeadObjectOutput{LastModified: aws.Time(lastModified), ContentLength: aws.Int64(11)})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/hello.txt", nil)
+			r = r.WithContext(context.WithValue(r.Context(), ctxConfigKey{}, &webConfig{}))
+			header := http.Header{}
+			tt.headers.set(r, header, "hello.txt")
+			if got := header.Get("Last-Modified"); got != testLastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, testLastModified)
+			}
+		})
+	}
+}
+
+func TestLastModifiedHeaders(t *testing.T) {
+	router, _ := newTestServer(t, &webConfig{S3bucket: "bucket"})
+	tests := []struct {
+		name      string
+		method    string
+		byteRange string
+		status    int
+	}{
+		{"get", http.MethodGet, "", http.StatusOK},
+		{"head", http.MethodHead, "", http.StatusOK},
+		{"range", http.MethodGet, "bytes=0-4", http.StatusPartialContent},
+		{"multi-range", http.MethodGet, "bytes=0-0,6-10", http.StatusPartialContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.byteRange != "" {
+				header.Set("Range", tt.byteRange)
+			}
+			w := serveTestRequest(router, tt.method, "/hello.txt", header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := w.Header().Get("Last-Modified"); got != testLastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, testLastModified)
+			}
+		})
+	}
+}
+
+func TestListingLastModified(t *testing.T) {
+	router, _ := newTestServer(t, &webConfig{S3bucket: "bucket", EnableListing: true})
+	w := serveTestRequest(router, http.MethodGet, "/", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<td>"+testLastModified+"</td>") {
+		t.Errorf("listing has no last modification %q: %s", testLastModified, body)
+	}
+}
+
+func TestExpiresHeader(t *testing.T) {
+	config := &webConfig{TTL: []ttlRule{{Prefix: "static/", MaxAge: duration{time.Hour}}}}
+	tests := []struct {
+		name string
+		key  string
+		ttl  time.Duration
+	}{
+		{"matching rule", "static/app.js", time.Hour},
+		{"no rule", "index.html", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			before := time.Now().Truncate(time.Second)
+			config.setExpiryHeaders(header, tt.key)
+			expires := header.Get("Expires")
+			if tt.ttl == 0 {
+				if expires != "" {
+					t.Errorf("Expires = %q, want none", expires)
+				}
+				return
+			}
+			if !strings.HasSuffix(expires, " GMT") {
+				t.Errorf("Expires = %q, want a GMT date", expires)
+			}
+			got, err := time.Parse(http.TimeFormat, expires)
+			if err != nil {
+				t.Fatalf("Expires = %q is not in http.TimeFormat: %v", expires, err)
+			}
+			if want := before.Add(tt.ttl); got.Before(want) || got.After(want.Add(2*time.Second)) {
+				t.Errorf("Expires = %v, want about %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMultiRangeExpires(t *testing.T) {
+	router, _ := newTestServer(t, &webConfig{S3bucket: "bucket", TTL: []ttlRule{{Prefix: "", MaxAge: duration{time.Hour}}}})
+	w := serveTestRequest(router, http.MethodGet, "/hello.txt", http.Header{"Range": {"bytes=0-0,6-10"}})
+	if w.Code != http.StatusPartialContent || !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Fatalf("status = %d, Content-Type = %q, want a multipart 206: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if _, err := time.Parse(http.TimeFormat, w.Header().Get("Expires")); err != nil {
+		t.Errorf("Expires = %q is not in http.TimeFormat: %v", w.Header().Get("Expires"), err)
+	}
+}
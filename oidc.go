@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Clock skew accepted on the token lifetimes
+const jwtLeeway = time.Minute
+
+// Minimum delay between two fetches of the signing keys
+const jwksRefreshInterval = time.Minute
+
+// OIDC config type, the JWTs of the issuer are accepted as bearer tokens
+type oidcConfig struct {
+	Issuer string `json:"issuer" yaml:"issuer" toml:"issuer"`
+	// Client id of the server at the issuer, required as the issuer mints tokens for its other clients too
+	Audience string `json:"audience" yaml:"audience" toml:"audience"`
+	// URL of the signing keys, default is the jwks_uri of the issuer discovery document
+	JWKSURL string `json:"jwksUrl" yaml:"jwksUrl" toml:"jwksUrl"`
+	// Claim naming the client, default is "sub"
+	NameClaim string `json:"nameClaim" yaml:"nameClaim" toml:"nameClaim"`
+	// Claim holding the groups or roles granted by the permissions, default is "groups"
+	GroupsClaim string `json:"groupsClaim" yaml:"groupsClaim" toml:"groupsClaim"`
+	// Read and write permissions by key prefix
+	Permissions []oidcPermission `json:"permissions" yaml:"permissions" toml:"permissions"`
+}
+
+// OIDC permission type, the rule with the longest matching prefix applies
+type oidcPermission struct {
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	// Groups allowed to GET and HEAD, "*" for all the authenticated clients
+	Read []string `json:"read" yaml:"read" toml:"read"`
+	// Groups allowed to use the other methods, "*" for all the authenticated clients
+	Write []string `json:"write" yaml:"write" toml:"write"`
+}
+
+// Check if the OIDC authentication is enabled
+func (cfg oidcConfig) enabled() bool {
+	return cfg.Issuer != ""
+}
+
+// Set the OIDC defaults
+func (cfg *oidcConfig) validate() error {
+	if !cfg.enabled() {
+		return nil
+	}
+	if cfg.Audience == "" {
+		return fmt.Errorf("auth oidc needs an audience with the issuer")
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "sub"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	for i := range cfg.Permissions {
+		cfg.Permissions[i].Prefix = strings.TrimPrefix(cfg.Permissions[i].Prefix, "/")
+	}
+	return nil
+}
+
+// Check if the groups of a client allow a method on a path. Without permissions every
+// token is allowed, with permissions the paths matching no prefix are denied.
+func (cfg oidcConfig) allows(groups []string, method, path string) bool {
+	if len(cfg.Permissions) == 0 {
+		return true
+	}
+	var match *oidcPermission
+	for i, p := range cfg.Permissions {
+		if strings.HasPrefix(path, p.Prefix) && (match == nil || len(p.Prefix) > len(match.Prefix)) {
+			match = &cfg.Permissions[i]
+		}
+	}
+	if match == nil {
+		return false
+	}
+	allowed := match.Write
+	if method == http.MethodGet || method == http.MethodHead {
+		allowed = match.Read
+	}
+	for _, a := range allowed {
+		if a == "*" {
+			return true
+		}
+		for _, g := range groups {
+			if a == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Signing keys of the OIDC issuer
+type jwksCache struct {
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// Signing keys of the configured issuer
+var jwks = &jwksCache{}
+
+// Find a signing key, the keys are fetched again when the key id is unknown (key rotation)
+func (j *jwksCache) key(cfg oidcConfig, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if key := j.find(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(j.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	j.fetched = time.Now()
+	keys, err := fetchJWKS(cfg)
+	if err != nil {
+		log.Warnf("Unable to fetch the signing keys of %s: %v", cfg.Issuer, err)
+		return nil, err
+	}
+	j.keys = keys
+	if key := j.find(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Find a loaded key, a token without key id needs a single key
+func (j *jwksCache) find(kid string) crypto.PublicKey {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key
+		}
+	}
+	return j.keys[kid]
+}
+
+// JSON Web Key type (RFC 7517)
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Fetch a JSON document
+func getJSON(url string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Fetch the RSA and EC signing keys of the issuer
+func fetchJWKS(cfg oidcConfig) (map[string]crypto.PublicKey, error) {
+	url := cfg.JWKSURL
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		url = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(url, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Debugf("Signing key %s ignored: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// Decode a big integer of a key
+func decodeKeyInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Get the public key of a JSON Web Key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeKeyInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeKeyInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeKeyInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeKeyInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// Hashes of the supported signature algorithms
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// Verify the signature of a JWT
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(pub, digest, r, s) {
+				return nil
+			}
+			return fmt.Errorf("invalid signature")
+		}
+	}
+	return fmt.Errorf("algorithm %s does not match the signing key", alg)
+}
+
+// Verify a JWT of the issuer and get its claims
+func (cfg oidcConfig) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims map[string]interface{}
+	for i, v := range []interface{}{&header, &claims} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("malformed token: %v", err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("malformed token: %v", err)
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %v", err)
+	}
+	key, err := jwks.key(cfg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != cfg.Issuer {
+		return nil, fmt.Errorf("token issued by %q", iss)
+	}
+	if !containsClaim(claims["aud"], cfg.Audience) {
+		return nil, fmt.Errorf("token not issued for %s", cfg.Audience)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+// Get the values of a string or string array claim
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Check if a string or string array claim holds a value
+func containsClaim(claim interface{}, value string) bool {
+	for _, v := range claimValues(claim) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPI parameter type
+type openAPIParameter struct {
+	Name        string            `json:"name"`
+	In          string            `json:"in"`
+	Description string            `json:"description,omitempty"`
+	Required    bool              `json:"required"`
+	Schema      map[string]string `json:"schema"`
+}
+
+// OpenAPI content type
+type openAPIContent map[string]map[string]interface{}
+
+// OpenAPI request body type
+type openAPIRequestBody struct {
+	Required bool           `json:"required"`
+	Content  openAPIContent `json:"content"`
+}
+
+// OpenAPI response type
+type openAPIResponse struct {
+	Description string `json:"description"`
+}
+
+// OpenAPI operation type
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+// OpenAPI document type
+type openAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    map[string]string                      `json:"info"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+// Convert a gin path to an OpenAPI path template
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Build an operation id from the route method and path
+func openAPIOperationID(route routeDef) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool { return r == '/' || r == '_' || r == '.' || r == '-' }) {
+		part = strings.TrimLeft(part, ":*")
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// Generate the OpenAPI document from the route definitions
+func buildOpenAPI(routes []routeDef) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "S3WebServer", "version": Tag},
+		Paths:   map[string]map[string]openAPIOperation{},
+	}
+	for _, route := range routes {
+		op := openAPIOperation{
+			Summary:     route.Summary,
+			OperationID: openAPIOperationID(route),
+			Responses:   map[string]openAPIResponse{},
+		}
+		if route.Tag != "" {
+			op.Tags = []string{route.Tag}
+		}
+		for _, p := range route.Params {
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: p.Name, In: p.In, Description: p.Description, Required: p.Required || p.In == "path", Schema: map[string]string{"type": "string"}})
+		}
+		if route.Body != "" {
+			op.RequestBody = &openAPIRequestBody{Required: true, Content: openAPIContent{route.Body: {}}}
+		}
+		for status, description := range route.Responses {
+			op.Responses[status] = openAPIResponse{Description: description}
+		}
+		if len(op.Responses) == 0 {
+			op.Responses["default"] = openAPIResponse{Description: "Response"}
+		}
+		path := openAPIPath(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]openAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+// Serve the OpenAPI specification of the server
+func serveOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, buildOpenAPI(registeredRoutes))
+}
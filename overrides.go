@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Prefix of the environment variables overriding the configuration keys
+const envPrefix = "S3WS_"
+
+// Short environment variables of the most used keys
+var envAliases = map[string]string{
+	"S3WS_BUCKET": "s3bucket",
+	"S3WS_REGION": "awsRegion",
+}
+
+// Configuration key which can be overridden
+type configKey struct {
+	// Dotted key of the configuration files, e.g. accessLog.format
+	path  string
+	env   string
+	index []int
+}
+
+// Overridden configuration key and value
+type configOverride struct {
+	key   string
+	value string
+}
+
+// Overrides of the -set flags, in command line order
+var configFlags []configOverride
+
+// Register the -set flag overriding a configuration key
+func registerConfigFlags(fs *flag.FlagSet) {
+	fs.Func("set", "Override a config `key=value`, e.g. -set accessLog.format=json (repeatable)", func(s string) error {
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return fmt.Errorf("%q is not key=value", s)
+		}
+		configFlags = append(configFlags, configOverride{key: s[:i], value: s[i+1:]})
+		return nil
+	})
+}
+
+// Check if values of a type are decoded as a whole, like the durations, instead of key by key
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// List the configuration keys of a struct type, the nested structs have dotted keys
+func configKeys(t reflect.Type, prefix string, index []int) []configKey {
+	var keys []configKey
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Type.Kind() == reflect.Struct && !reflect.PtrTo(field.Type).Implements(yamlUnmarshalerType) {
+			keys = append(keys, configKeys(field.Type, path+".", fieldIndex)...)
+			continue
+		}
+		keys = append(keys, configKey{path: path, env: envName(path), index: fieldIndex})
+	}
+	return keys
+}
+
+// Get the environment variable of a key, e.g. S3WS_ACCESS_LOG_FORMAT for accessLog.format
+func envName(path string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for i, r := range path {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0 && path[i-1] != '.':
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// Set the value of a key, the strings are taken as is and the other values are decoded
+// as YAML, so that the lists and maps can be given in JSON (e.g. ["GET", "HEAD"])
+func setConfigValue(field reflect.Value, value string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+	v := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), v.Interface()); err != nil {
+		return err
+	}
+	field.Set(v.Elem())
+	return nil
+}
+
+// Apply the environment variables, then the -set flags, to the configuration read from the file
+func applyOverrides(cfg *webConfig) error {
+	keys := configKeys(reflect.TypeOf(*cfg), "", nil)
+	byPath := map[string]configKey{}
+	byEnv := map[string]configKey{}
+	for _, key := range keys {
+		byPath[strings.ToLower(key.path)] = key
+		byEnv[key.env] = key
+	}
+	root := reflect.ValueOf(cfg).Elem()
+	var overrides []configOverride
+	// The aliases are applied first, so that the full names win
+	aliases := make([]string, 0, len(envAliases))
+	for env := range envAliases {
+		aliases = append(aliases, env)
+	}
+	sort.Strings(aliases)
+	for _, env := range aliases {
+		if value, ok := os.LookupEnv(env); ok {
+			overrides = append(overrides, configOverride{key: envAliases[env], value: value})
+		}
+	}
+	for _, key := range keys {
+		if value, ok := os.LookupEnv(key.env); ok {
+			overrides = append(overrides, configOverride{key: key.path, value: value})
+		}
+	}
+	overrides = append(overrides, configFlags...)
+	for _, o := range overrides {
+		key, ok := byPath[strings.ToLower(o.key)]
+		if !ok {
+			return fmt.Errorf("unknown config key %s", o.key)
+		}
+		if err := setConfigValue(root.FieldByIndex(key.index), o.value); err != nil {
+			return fmt.Errorf("invalid value of config key %s: %v", key.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Check the AWS credentials, the region, the buckets and the read permissions before serving.
+// Returns the list of problems found, with a hint on how to fix each of them.
+func preflight(svc *s3.S3, config *webConfig) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+	defer cancel()
+
+	if _, err := svc.Config.Credentials.Get(); err != nil {
+		return []error{fmt.Errorf("no usable AWS credentials (%v): set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or run with an instance or task role", err)}
+	}
+	var problems []error
+	for _, bucket := range configuredBuckets(config) {
+		if err := preflightBucket(ctx, svc, bucket, config.AwsRegion); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if config.SoftDelete {
+		problems = append(problems, preflightSoftDelete(ctx, config)...)
+	}
+	return problems
+}
+
+// Check that a bucket exists in the region and can be listed and read
+func preflightBucket(ctx context.Context, svc *s3.S3, bucket, region string) error {
+	_, err := svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return preflightError(bucket, region, "HeadBucket", "", err)
+	}
+	list, err := svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), MaxKeys: aws.Int64(1)})
+	if err != nil {
+		return preflightError(bucket, region, "ListObjectsV2", "s3:ListBucket", err)
+	}
+	if len(list.Contents) == 0 {
+		log.Warnf("Bucket %s is empty, read permission not checked", bucket)
+		return nil
+	}
+	_, err = svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: list.Contents[0].Key})
+	if err != nil {
+		return preflightError(bucket, region, "HeadObject", "s3:GetObject", err)
+	}
+	return nil
+}
+
+// Hint of the access denied errors, a requester pays bucket refuses the requests without payer
+func requesterPaysHint() string {
+	if currentConfig.Load().RequesterPays {
+		return ""
+	}
+	return ", or set requesterPays if the bucket is a requester pays bucket"
+}
+
+// Explain a failed preflight call
+func preflightError(bucket, region, operation, permission string, err error) error {
+	status := 0
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status = reqErr.StatusCode()
+	}
+	switch {
+	case errorCode(err) == "BucketRegionError" || status == http.StatusMovedPermanently:
+		return fmt.Errorf("bucket %s is not in region %s: set awsRegion to the region of the bucket", bucket, region)
+	case status == http.StatusNotFound || errorCode(err) == "NoSuchBucket":
+		return fmt.Errorf("bucket %s does not exist: check s3bucket", bucket)
+	case status == http.StatusForbidden && permission == "":
+		return fmt.Errorf("access denied to bucket %s: check that the credentials belong to the bucket account and allow s3:ListBucket%s", bucket, requesterPaysHint())
+	case status == http.StatusForbidden:
+		return fmt.Errorf("access denied on %s of bucket %s: grant %s to the credentials%s", operation, bucket, permission, requesterPaysHint())
+	case errorCode(err) == "InvalidAccessKeyId" || errorCode(err) == "SignatureDoesNotMatch":
+		return fmt.Errorf("AWS credentials rejected by S3 (%s): check the access key and secret", errorCode(err))
+	}
+	return fmt.Errorf("%s on bucket %s failed: %v", operation, bucket, err)
+}
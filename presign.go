@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Longest lifetime of a SigV4 presigned URL
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// Presigned URLs config type
+type presignConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Lifetime of a presigned URL when not given on creation
+	DefaultExpiry duration `json:"defaultExpiry" yaml:"defaultExpiry" toml:"defaultExpiry"`
+	// Longest lifetime that can be asked
+	MaxExpiry duration `json:"maxExpiry" yaml:"maxExpiry" toml:"maxExpiry"`
+}
+
+// Set the presign defaults and check the values
+func (cfg *presignConfig) validate() error {
+	cfg.DefaultExpiry.Duration = cfg.DefaultExpiry.orDefault(15 * time.Minute)
+	cfg.MaxExpiry.Duration = cfg.MaxExpiry.orDefault(time.Hour)
+	if cfg.MaxExpiry.Duration > maxPresignExpiry {
+		return fmt.Errorf("presign maxExpiry cannot exceed %s", maxPresignExpiry)
+	}
+	return nil
+}
+
+// Presign request type
+type presignRequest struct {
+	Key string `json:"key" binding:"required"`
+	// GET or PUT, default is GET
+	Method    string   `json:"method"`
+	ExpiresIn duration `json:"expiresIn"`
+	// Content type of an upload, the client must send the same Content-Type
+	ContentType string `json:"contentType"`
+}
+
+// Presign response type
+type presignResponse struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Expires time.Time         `json:"expires"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Create a presigned S3 URL, so that the client downloads or uploads directly from or to S3
+func servePresign(c *gin.Context) {
+	config := configOf(c)
+	var req presignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid presign request: "+err.Error(), "")
+		return
+	}
+	cfg := config.Presign
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	key := strings.TrimPrefix(req.Key, "/")
+	expiry := req.ExpiresIn.orDefault(cfg.DefaultExpiry.Duration)
+	if (method != http.MethodGet && method != http.MethodPut) || key == "" || config.isHiddenKey(key) || expiry > cfg.MaxExpiry.Duration {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid presign request", "")
+		return
+	}
+	if !methodAllowed(method) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Method "+method+" not allowed", "")
+		return
+	}
+	if method == http.MethodPut {
+		key = config.normalizeUploadKey(key)
+	}
+	if !checkKeyAccess(c, method, key) {
+		return
+	}
+
+	bucket, objectKey := config.resolveObject(c.Request.Host, key)
+	// The encryption rules match the stored key, S3 would serve or store the content without the server encryption
+	if config.encryptionFor(objectKey) != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Objects under an encryption prefix cannot be presigned", "")
+		return
+	}
+	var r *request.Request
+	resp := presignResponse{Method: method}
+	if method == http.MethodGet {
+		r, _ = s3Session.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(objectKey)})
+	} else {
+		input := &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(objectKey)}
+		if req.ContentType != "" {
+			input.ContentType = aws.String(req.ContentType)
+		}
+		config.applyServerSideEncryption(nil, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+		r, _ = s3Session.PutObjectRequest(input)
+	}
+	// The client must send the signed headers, like the Content-Type and the encryption
+	url, signed, err := r.PresignRequest(expiry)
+	if err != nil {
+		writeInternalError(c, "PresignFailed", "Failed to presign "+key+": "+err.Error(), "")
+		return
+	}
+	for name, values := range signed {
+		if name = http.CanonicalHeaderKey(name); name != "Host" && len(values) > 0 {
+			if resp.Headers == nil {
+				resp.Headers = map[string]string{}
+			}
+			resp.Headers[name] = values[0]
+		}
+	}
+	resp.URL = url
+	resp.Expires = time.Now().Add(expiry).UTC()
+	requestLog(c).Infof("Presigned %s %s, expires %s", method, key, resp.Expires.Format(time.RFC3339))
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+// Config encrypting the objects under private/, stored under the key prefix site/
+func newEncryptionTestConfig() *webConfig {
+	return &webConfig{S3bucket: "bucket", KeyPrefix: "site/", Presign: presignConfig{Enabled: true},
+		Encryption: []encryptionRule{{Prefix: "site/private/", Key: base64.StdEncoding.EncodeToString(make([]byte, 32))}}}
+}
+
+func TestPresignEncryptedKey(t *testing.T) {
+	router, _ := newTestServer(t, newEncryptionTestConfig())
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"cleartext get", `{"key": "public/a.txt"}`, http.StatusOK},
+		{"encrypted get", `{"key": "private/a.txt"}`, http.StatusBadRequest},
+		{"encrypted put", `{"key": "private/a.txt", "method": "PUT"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestBody(router, http.MethodPost, "/_api/presign", nil, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPresignAccess(t *testing.T) {
+	router, _ := newTestServer(t, &webConfig{S3bucket: "bucket", Presign: presignConfig{Enabled: true},
+		Auth: authConfig{Tokens: []authToken{{Name: "alice", Token: "alice-token"}, {Name: "bob", Token: "bob-token"}}},
+		ACL:  []aclRule{{Pattern: "private/**", Users: []string{"alice"}}, {Pattern: "readonly/**", Methods: []string{"GET"}}},
+	})
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"allowed user", "alice-token", `{"key": "private/a.txt"}`, http.StatusOK},
+		{"other user", "bob-token", `{"key": "private/a.txt"}`, http.StatusForbidden},
+		{"read of a read-only key", "bob-token", `{"key": "readonly/a.txt"}`, http.StatusOK},
+		{"upload to a read-only key", "bob-token", `{"key": "readonly/a.txt", "method": "PUT"}`, http.StatusForbidden},
+		{"dot segment", "alice-token", `{"key": "readonly/../private/a.txt"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestBody(router, http.MethodPost, "/_api/presign", bearer(tt.token), tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
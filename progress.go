@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Request header giving the client id of an upload, to follow its progress
+const uploadIDHeader = "X-Upload-Id"
+
+// Valid client upload ids
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Retention of the finished uploads progress
+const uploadRetention = 10 * time.Minute
+
+// Upload states
+const (
+	uploadReceiving = "receiving"
+	uploadStoring   = "storing"
+	uploadCompleted = "completed"
+	uploadFailed    = "failed"
+)
+
+// Upload progress type
+type uploadProgress struct {
+	ID             string     `json:"id"`
+	Key            string     `json:"key"`
+	BytesReceived  int64      `json:"bytesReceived"`
+	TotalBytes     int64      `json:"totalBytes"` // -1 if unknown
+	PartsCompleted int64      `json:"partsCompleted"`
+	State          string     `json:"state"`
+	Started        time.Time  `json:"started"`
+	Finished       *time.Time `json:"finished,omitempty"`
+}
+
+// Tracker of the uploads in progress
+type uploadTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadProgress
+}
+
+// Uploads followed by the server
+var uploads = &uploadTracker{uploads: map[string]*uploadProgress{}}
+
+// Start following an upload if the client gave an upload id, nil otherwise
+func (t *uploadTracker) track(c *gin.Context, key string) *uploadProgress {
+	id := c.GetHeader(uploadIDHeader)
+	if !uploadIDPattern.MatchString(id) {
+		return nil
+	}
+	p := &uploadProgress{ID: id, Key: key, TotalBytes: c.Request.ContentLength, State: uploadReceiving, Started: time.Now().UTC()}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for other, upload := range t.uploads {
+		if upload.Finished != nil && time.Since(*upload.Finished) > uploadRetention {
+			delete(t.uploads, other)
+		}
+	}
+	t.uploads[id] = p
+	return p
+}
+
+// Get a snapshot of an upload progress
+func (t *uploadTracker) get(id string) (uploadProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.uploads[id]
+	if !ok {
+		return uploadProgress{}, false
+	}
+	snapshot := *p
+	snapshot.BytesReceived = atomic.LoadInt64(&p.BytesReceived)
+	snapshot.PartsCompleted = atomic.LoadInt64(&p.PartsCompleted)
+	return snapshot, true
+}
+
+// Change the state of an upload
+func (t *uploadTracker) setState(p *uploadProgress, state string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p.State = state
+	if state == uploadCompleted || state == uploadFailed {
+		finished := time.Now().UTC()
+		p.Finished = &finished
+	}
+}
+
+// Finish an upload according to the response status
+func (t *uploadTracker) finish(p *uploadProgress, status int) {
+	if status < http.StatusMultipleChoices {
+		t.setState(p, uploadCompleted)
+	} else {
+		t.setState(p, uploadFailed)
+	}
+}
+
+// Reader counting the bytes received of an upload
+type progressReader struct {
+	io.ReadCloser
+	progress *uploadProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.progress.BytesReceived, int64(n))
+	if err == io.EOF {
+		uploads.setState(r.progress, uploadStoring)
+	}
+	return n, err
+}
+
+// Serve the progress of an upload, as JSON or as a stream of server-sent events
+func serveUploadProgress(c *gin.Context) {
+	id := c.Param("id")
+	p, ok := uploads.get(id)
+	if !ok {
+		writeError(c, http.StatusNotFound, "NotFound", "Upload '"+id+"' not found", "")
+		return
+	}
+	if c.GetHeader("Accept") != "text/event-stream" {
+		c.JSON(http.StatusOK, p)
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		c.SSEvent("progress", p)
+		if p.State == uploadCompleted || p.State == uploadFailed {
+			return false
+		}
+		select {
+		case <-ticker.C:
+		case <-c.Request.Context().Done():
+			return false
+		}
+		p, _ = uploads.get(id)
+		return true
+	})
+}
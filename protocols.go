@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP/2 and HTTP/3 config type. With tls, HTTP/2 is negotiated by the clients.
+type protocolsConfig struct {
+	// Serve HTTP/2 without TLS (h2c), e.g. to a load balancer or a proxy talking HTTP/2 to its backends
+	H2C bool `json:"h2c" yaml:"h2c" toml:"h2c"`
+	// HTTP/3 over QUIC, with the certificates of tls
+	HTTP3 http3Config `json:"http3" yaml:"http3" toml:"http3"`
+}
+
+// HTTP/3 config type
+type http3Config struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// UDP port of the QUIC listener, default is port
+	Port string `json:"port" yaml:"port" toml:"port"`
+	// Delay during which the clients remember that HTTP/3 is available, sent in the Alt-Svc header
+	AltSvcMaxAge duration `json:"altSvcMaxAge" yaml:"altSvcMaxAge" toml:"altSvcMaxAge"`
+}
+
+// Set the protocols defaults and check them against the TLS config
+func (cfg *protocolsConfig) validate(config *webConfig) error {
+	if cfg.H2C && config.TLS.enabled() {
+		return fmt.Errorf("protocols h2c cannot be used with tls, HTTP/2 is negotiated by TLS")
+	}
+	if !cfg.HTTP3.Enabled {
+		return nil
+	}
+	if !config.TLS.enabled() {
+		return fmt.Errorf("protocols http3 needs tls")
+	}
+	if cfg.HTTP3.Port == "" {
+		cfg.HTTP3.Port = config.Port
+	}
+	cfg.HTTP3.AltSvcMaxAge.Duration = cfg.HTTP3.AltSvcMaxAge.orDefault(24 * time.Hour)
+	return nil
+}
+
+// Get the handler of the TCP listener, accepting the HTTP/2 cleartext connections if enabled
+func tcpHandler(router http.Handler, cfg protocolsConfig) http.Handler {
+	if !cfg.H2C {
+		return router
+	}
+	return h2c.NewHandler(router, &http2.Server{})
+}
+
+// Advertise HTTP/3 to the clients of the TCP listener, which switch to QUIC for their next requests
+func altSvcMiddleware(cfg http3Config) gin.HandlerFunc {
+	value := fmt.Sprintf(`h3=":%s"; ma=%d`, cfg.Port, int(cfg.AltSvcMaxAge.Seconds()))
+	return func(c *gin.Context) {
+		if c.Request.ProtoMajor < 3 {
+			c.Header("Alt-Svc", value)
+		}
+		c.Next()
+	}
+}
+
+// Start the QUIC listener serving HTTP/3, with the TLS config and the limits of the TCP listener
+func startHTTP3(handler http.Handler, tlsConfig *tls.Config, cfg http3Config, limits serverConfig) *http3.Server {
+	srv := &http3.Server{
+		Addr:           ":" + cfg.Port,
+		Handler:        handler,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig.Clone()),
+		MaxHeaderBytes: limits.MaxHeaderBytes,
+		QUICConfig:     &quic.Config{MaxIdleTimeout: limits.IdleTimeout.Duration},
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("http3 listen: %s\n", err)
+		}
+	}()
+	log.Infof("Serving HTTP/3 on UDP port %s", cfg.Port)
+	return srv
+}
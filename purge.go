@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Purge request type, at most one of key, prefix or glob; none purges all the cached objects
+type purgeRequest struct {
+	// Path of an object
+	Key string `json:"key"`
+	// Path prefix of the objects
+	Prefix string `json:"prefix"`
+	// Glob pattern of the object paths, as in headers
+	Glob string `json:"glob"`
+	// Host of the bucket mappings, default is the Host of the request
+	Host string `json:"host"`
+}
+
+// Purge report type
+type purgeReport struct {
+	Purged int `json:"purged"`
+	// Entries removed by cache, memory or disk
+	Caches map[string]int `json:"caches"`
+}
+
+// Get the function matching the cached objects of a purge request
+func (req purgeRequest) matcher(config *webConfig, host string) (func(bucket, key string) bool, bool) {
+	set := 0
+	for _, value := range []string{req.Key, req.Prefix, req.Glob} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, false
+	}
+	if req.Host != "" {
+		host = req.Host
+	}
+	switch {
+	case req.Key != "":
+		bucket, key := config.resolveObject(host, strings.TrimPrefix(req.Key, "/"))
+		return func(b, k string) bool { return b == bucket && k == key }, true
+	case req.Prefix != "":
+		bucket, prefix := config.resolveObject(host, strings.TrimPrefix(req.Prefix, "/"))
+		return func(b, k string) bool { return b == bucket && strings.HasPrefix(k, prefix) }, true
+	case req.Glob != "":
+		if validatePathPattern(req.Glob) != nil {
+			return nil, false
+		}
+		// The pattern applies to the paths, below the key prefix of the bucket
+		bucket, base := config.resolveObject(host, "")
+		glob := strings.TrimPrefix(req.Glob, "/")
+		return func(b, k string) bool {
+			return b == bucket && strings.HasPrefix(k, base) && matchPathPattern(glob, strings.TrimPrefix(k, base))
+		}, true
+	}
+	return func(string, string) bool { return true }, true
+}
+
+// Serve a purge of the cached objects, e.g. by a deploy pipeline after uploading new assets
+func servePurge(c *gin.Context) {
+	var req purgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid purge request: "+err.Error(), "")
+		return
+	}
+	match, ok := req.matcher(configOf(c), c.Request.Host)
+	if !ok {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid purge request, give one of key, prefix or a valid glob", "")
+		return
+	}
+	report := purgeReport{Caches: caches.purge(match)}
+	for _, n := range report.Caches {
+		report.Purged += n
+	}
+	requestLog(c).Infof("Purged %d cached entries (key %q, prefix %q, glob %q)", report.Purged, req.Key, req.Prefix, req.Glob)
+	c.JSON(http.StatusOK, report)
+}
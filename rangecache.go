@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Largest number of objects whose headers are kept for the range requests
+const maxRangeObjects = 10000
+
+// Range cache config type, the objects read with range requests (e.g. by the video players) are
+// cached in chunks
+type rangeCacheConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Size (in bytes) of the cached chunks
+	ChunkSize int64 `json:"chunkSize" yaml:"chunkSize" toml:"chunkSize"`
+	// Number of the next chunks read ahead in the background, 0 disables it
+	Prefetch int `json:"prefetch" yaml:"prefetch" toml:"prefetch"`
+	// Delay during which the ETag of an object is not checked in S3
+	MaxAge duration `json:"maxAge" yaml:"maxAge" toml:"maxAge"`
+}
+
+// Set the range cache defaults, the chunks are stored in the memory or the disk cache
+func (cfg *rangeCacheConfig) validate(config *webConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if !config.MemoryCache.Enabled && !config.DiskCache.Enabled {
+		return fmt.Errorf("rangeCache needs memoryCache or diskCache")
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 4 << 20
+	}
+	if cfg.ChunkSize > config.MemoryCache.MaxObjectSize && cfg.ChunkSize > config.DiskCache.MaxObjectSize {
+		return fmt.Errorf("rangeCache chunkSize is larger than the maxObjectSize of the caches")
+	}
+	if cfg.Prefetch < 0 {
+		return fmt.Errorf("rangeCache prefetch must not be negative")
+	}
+	cfg.MaxAge.Duration = cfg.MaxAge.orDefault(time.Minute)
+	return nil
+}
+
+// Headers of an object read by chunks
+type rangeObject struct {
+	headers objectHeaders
+	// Website redirect, served from S3
+	redirect bool
+	checked  time.Time
+}
+
+// A chunk being downloaded, shared by the requests and the prefetch asking for it
+type chunkFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Chunked cache of the objects read with range requests
+type rangeCache struct {
+	mu       sync.Mutex
+	objects  map[string]*rangeObject
+	inflight map[string]*chunkFetch
+}
+
+var chunkCache = &rangeCache{objects: map[string]*rangeObject{}, inflight: map[string]*chunkFetch{}}
+
+// Get the headers of an object, checked with a HEAD once older than maxAge
+func (rc *rangeCache) object(ctx context.Context, bucket, key string) (*rangeObject, error) {
+	id := cacheID(bucket, key)
+	rc.mu.Lock()
+	obj := rc.objects[id]
+	rc.mu.Unlock()
+	if obj != nil && time.Since(obj.checked) < configFrom(ctx).RangeCache.MaxAge.Duration {
+		return obj, nil
+	}
+	ctx, cancel := s3Context(ctx, configFrom(ctx).Timeouts.Head)
+	defer cancel()
+	resp, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		rc.invalidate(bucket, key)
+		return nil, err
+	}
+	obj = &rangeObject{headers: headObjectHeaders(resp), redirect: resp.WebsiteRedirectLocation != nil, checked: time.Now()}
+	rc.mu.Lock()
+	if len(rc.objects) >= maxRangeObjects {
+		for other := range rc.objects {
+			delete(rc.objects, other)
+			break
+		}
+	}
+	rc.objects[id] = obj
+	rc.mu.Unlock()
+	return obj, nil
+}
+
+// Forget the headers of an object, its chunks are keyed by ETag and are left to the LRU
+func (rc *rangeCache) invalidate(bucket, key string) {
+	rc.mu.Lock()
+	delete(rc.objects, cacheID(bucket, key))
+	rc.mu.Unlock()
+}
+
+// Forget the headers of the objects matching
+func (rc *rangeCache) purge(match func(bucket, key string) bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for id := range rc.objects {
+		// The bucket names have no /
+		if parts := strings.SplitN(id, "/", 2); len(parts) == 2 && match(parts[0], parts[1]) {
+			delete(rc.objects, id)
+		}
+	}
+}
+
+// Identifier of a chunk in the caches
+func chunkID(bucket, key, etag string, index int64) string {
+	return "chunks/" + cacheID(bucket, key) + "/" + etag + "/" + strconv.FormatInt(index, 10)
+}
+
+// Get a chunk of an object from the caches or from S3
+func (rc *rangeCache) chunk(ctx context.Context, bucket, key string, h objectHeaders, index int64) (io.ReadSeeker, func(), error) {
+	id := chunkID(bucket, key, h.etag, index)
+	if entry := caches.get(id); entry != nil {
+		if content, done, err := entry.open(); err == nil {
+			return content, done, nil
+		}
+	}
+	data, err := rc.fetch(ctx, bucket, key, h, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), func() {}, nil
+}
+
+// Download a chunk and add it to the caches, once for all the concurrent readers
+func (rc *rangeCache) fetch(ctx context.Context, bucket, key string, h objectHeaders, index int64) ([]byte, error) {
+	id := chunkID(bucket, key, h.etag, index)
+	rc.mu.Lock()
+	fetch, ok := rc.inflight[id]
+	if !ok {
+		fetch = &chunkFetch{done: make(chan struct{})}
+		rc.inflight[id] = fetch
+	}
+	rc.mu.Unlock()
+	if ok {
+		select {
+		case <-fetch.done:
+			return fetch.data, fetch.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.inflight, id)
+		rc.mu.Unlock()
+		close(fetch.done)
+	}()
+
+	// The download is shared, it is not stopped by the client asking first
+	ctx, cancel := s3Context(context.Background(), configFrom(ctx).Timeouts.Get)
+	defer cancel()
+	chunkSize := configFrom(ctx).RangeCache.ChunkSize
+	start := index * chunkSize
+	end := start + chunkSize - 1
+	if end >= h.contentLength {
+		end = h.contentLength - 1
+	}
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		IfMatch: aws.String(h.etag),
+	})
+	if err != nil {
+		if isPreconditionFailed(err) {
+			// Replaced since its HEAD
+			rc.invalidate(bucket, key)
+		}
+		fetch.err = err
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil && int64(len(data)) != end-start+1 {
+		err = fmt.Errorf("chunk %d of %s is %d bytes long, %d expected", index, key, len(data), end-start+1)
+	}
+	if err != nil {
+		fetch.err = err
+		return nil, err
+	}
+	caches.put(&cacheEntry{id: id, key: key, bucket: bucket, source: key, etag: h.etag, lastModified: h.lastModified}, data)
+	fetch.data = data
+	return data, nil
+}
+
+// Read the next chunks of an object ahead in the background, the ones cached or downloading are skipped
+func (rc *rangeCache) prefetch(bucket, key string, h objectHeaders, index int64) {
+	cfg := currentConfig.Load().RangeCache
+	chunkSize := cfg.ChunkSize
+	for i := index + 1; i <= index+int64(cfg.Prefetch) && i*chunkSize < h.contentLength; i++ {
+		id := chunkID(bucket, key, h.etag, i)
+		rc.mu.Lock()
+		_, downloading := rc.inflight[id]
+		rc.mu.Unlock()
+		if downloading || caches.get(id) != nil {
+			continue
+		}
+		go rc.fetch(context.Background(), bucket, key, h, i)
+	}
+}
+
+// Reader of the content of an object by chunks, for http.ServeContent
+type chunkReader struct {
+	ctx     context.Context
+	bucket  string
+	key     string
+	headers objectHeaders
+	offset  int64
+	// Current chunk
+	index   int64
+	content io.ReadSeeker
+	done    func()
+	read    int64
+	err     error
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.headers.contentLength
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.offset >= r.headers.contentLength {
+		return 0, io.EOF
+	}
+	chunkSize := configFrom(r.ctx).RangeCache.ChunkSize
+	index := r.offset / chunkSize
+	if r.content == nil || index != r.index {
+		r.close()
+		content, done, err := chunkCache.chunk(r.ctx, r.bucket, r.key, r.headers, index)
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.content, r.done, r.index = content, done, index
+		chunkCache.prefetch(r.bucket, r.key, r.headers, index)
+	}
+	if _, err := r.content.Seek(r.offset-index*chunkSize, io.SeekStart); err != nil {
+		r.err = err
+		return 0, err
+	}
+	n, err := r.content.Read(p)
+	r.offset += int64(n)
+	r.read += int64(n)
+	if err == io.EOF && r.offset < r.headers.contentLength {
+		// End of this chunk only
+		err = nil
+	}
+	return n, err
+}
+
+func (r *chunkReader) close() {
+	if r.done != nil {
+		r.done()
+		r.content, r.done = nil, nil
+	}
+}
+
+// Get the start of the first range of a Range header, -1 if it cannot be parsed
+func rangeStart(header string, size int64) int64 {
+	spec := strings.TrimSpace(strings.Split(strings.TrimPrefix(header, "bytes="), ",")[0])
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return -1
+	}
+	if dash == 0 {
+		suffix, err := strconv.ParseInt(spec[1:], 10, 64)
+		if err != nil {
+			return -1
+		}
+		if suffix > size {
+			return 0
+		}
+		return size - suffix
+	}
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// Serve a range request from the cached chunks of an object, the missing chunks are downloaded and
+// cached. Returns false if the object is not read by chunks (compressed, encrypted, redirected or
+// missing), the request is then served from S3.
+func serveRangeCached(c *gin.Context, bucket, key string) bool {
+	obj, err := chunkCache.object(c.Request.Context(), bucket, key)
+	if err != nil || obj.redirect {
+		return false
+	}
+	h := obj.headers
+	if h.contentLength == 0 || h.contentEncoding != "" || isEncrypted(h.metadata) || h.etag == "" {
+		return false
+	}
+	header := c.Writer.Header()
+	h.set(c.Request, header, key)
+	header.Del("Content-Length")
+	cache := "MISS"
+	if start := rangeStart(c.GetHeader("Range"), h.contentLength); start >= 0 && start < h.contentLength &&
+		caches.get(chunkID(bucket, key, h.etag, start/configOf(c).RangeCache.ChunkSize)) != nil {
+		cache = "HIT"
+	}
+	header.Set("X-Cache", cache)
+	content := &chunkReader{ctx: c.Request.Context(), bucket: bucket, key: key, headers: h, index: -1}
+	http.ServeContent(c.Writer, c.Request, "", h.lastModified, content)
+	content.close()
+	usage.addBytesOut(content.read)
+	if content.err != nil && !isCanceled(content.err) {
+		requestLog(c).Warnf("Range download of %s failed after %d bytes: %v", key, content.read, content.err)
+	}
+	return true
+}
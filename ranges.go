@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of ranges served in a multipart/byteranges response, more are served as the full content
+const maxRanges = 16
+
+// Valid byte range spec: first-last, first- or -suffix
+var rangeSpecPattern = regexp.MustCompile(`^(\d+-\d*|-\d+)$`)
+
+// Check if a Range header is a valid list of byte ranges, invalid headers are ignored (RFC 7233)
+func isValidRange(header string) bool {
+	if !strings.HasPrefix(header, "bytes=") {
+		return false
+	}
+	for _, spec := range strings.Split(strings.TrimPrefix(header, "bytes="), ",") {
+		spec = strings.TrimSpace(spec)
+		if !rangeSpecPattern.MatchString(spec) {
+			return false
+		}
+		bounds := strings.SplitN(spec, "-", 2)
+		if bounds[0] != "" && bounds[1] != "" {
+			first, err1 := strconv.ParseInt(bounds[0], 10, 64)
+			last, err2 := strconv.ParseInt(bounds[1], 10, 64)
+			if err1 != nil || err2 != nil || first > last {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Set the Content-Range header of a 416 response, with the current object size
+func setUnsatisfiedRange(c *gin.Context, bucket, key string) {
+	client, target := readTarget(c, bucket)
+	input := &s3.HeadObjectInput{Bucket: target, Key: aws.String(key), VersionId: requestedVersion(c)}
+	if applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5) != nil {
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	defer cancel()
+	if head, err := client.HeadObjectWithContext(ctx, input); err == nil && head.ContentLength != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", *head.ContentLength))
+	}
+}
+
+// Check if a Range header asks for several ranges
+func isMultiRange(header string) bool {
+	return strings.Contains(header, ",")
+}
+
+// Split a Range header with several byte ranges into single range headers, nil if it cannot be served as such
+func splitRanges(header string) []string {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil
+	}
+	specs := strings.Split(strings.TrimPrefix(header, "bytes="), ",")
+	if len(specs) < 2 || len(specs) > maxRanges {
+		return nil
+	}
+	ranges := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if !rangeSpecPattern.MatchString(spec) {
+			return nil
+		}
+		ranges = append(ranges, "bytes="+spec)
+	}
+	return ranges
+}
+
+// Generate a multipart boundary
+func newBoundary() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Serve several byte ranges as a multipart/byteranges response, one S3 ranged GET per range.
+// The parts are pinned to the ETag of the first one, so that they all come from the same object version.
+// Returns false if the full content must be served instead.
+func serveMultiRange(c *gin.Context, bucket, filePath string, params *s3.GetObjectInput, ranges []string, conditional bool) bool {
+	w := c.Writer
+	client, _ := readTarget(c, bucket)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+
+	boundary := newBoundary()
+	pinned := *params
+	wrote := false
+	var lastErr error
+	for _, byteRange := range ranges {
+		input := pinned
+		input.Range = aws.String(byteRange)
+		resp, err := client.GetObjectWithContext(ctx, &input)
+		if errorCode(err) == "InvalidRange" {
+			// Unsatisfiable ranges are left out
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			if wrote {
+				requestLog(c).Debugf("Multi-range download of %s interrupted: %v", filePath, err)
+				return true
+			}
+			if conditional && isPreconditionFailed(err) {
+				return false
+			}
+			handleHTTPException(c, filePath, err)
+			return true
+		}
+		if !wrote {
+			if resp.ContentRange == nil || resp.ContentEncoding != nil || isEncrypted(resp.Metadata) {
+				resp.Body.Close()
+				return false
+			}
+			w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+			w.Header().Set("Last-Modified", httpDate(aws.TimeValue(resp.LastModified)))
+			w.Header().Set("Etag", *resp.ETag)
+			w.Header().Set("Accept-Ranges", "bytes")
+			setSSECustomerHeaders(w.Header(), resp.SSECustomerAlgorithm, resp.SSECustomerKeyMD5)
+			configOf(c).setExpiryHeaders(w.Header(), filePath)
+			w.WriteHeader(http.StatusPartialContent)
+			pinned.IfMatch = resp.ETag
+			wrote = true
+		}
+		fmt.Fprintf(w, "\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, configOf(c).objectContentType(filePath, resp.ContentType), *resp.ContentRange)
+		n, err := io.Copy(w, resp.Body)
+		resp.Body.Close()
+		usage.addBytesOut(n)
+		if err != nil {
+			requestLog(c).Debugf("Multi-range download of %s interrupted after %d bytes: %v", filePath, n, err)
+			return true
+		}
+	}
+	if !wrote {
+		setUnsatisfiedRange(c, bucket, filePath)
+		handleHTTPException(c, filePath, lastErr)
+		return true
+	}
+	fmt.Fprintf(w, "\r\n--%s--\r\n", boundary)
+	return true
+}
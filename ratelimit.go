@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Interval between two removals of the idle client buckets
+const rateLimitSweepInterval = time.Minute
+
+// Rate limits config type, the limits are disabled when their rate is 0
+type rateLimitConfig struct {
+	// Requests per second of each client IP
+	RequestsPerIP float64 `json:"requestsPerIp" yaml:"requestsPerIp" toml:"requestsPerIp"`
+	// Requests per second of all the clients
+	Requests float64 `json:"requests" yaml:"requests" toml:"requests"`
+	// Requests sent above the rates before being limited, default is one second of requests
+	Burst float64 `json:"burst" yaml:"burst" toml:"burst"`
+	// Response bytes per second of each client IP
+	BandwidthPerIP float64 `json:"bandwidthPerIp" yaml:"bandwidthPerIp" toml:"bandwidthPerIp"`
+	// Response bytes per second of all the clients
+	Bandwidth float64 `json:"bandwidth" yaml:"bandwidth" toml:"bandwidth"`
+}
+
+// Check if a rate limit is set
+func (cfg rateLimitConfig) enabled() bool {
+	return cfg.RequestsPerIP > 0 || cfg.Requests > 0 || cfg.BandwidthPerIP > 0 || cfg.Bandwidth > 0
+}
+
+// Check the rate limits
+func (cfg rateLimitConfig) validate() error {
+	if cfg.RequestsPerIP < 0 || cfg.Requests < 0 || cfg.Burst < 0 || cfg.BandwidthPerIP < 0 || cfg.Bandwidth < 0 {
+		return fmt.Errorf("invalid rateLimit, the rates cannot be negative")
+	}
+	return nil
+}
+
+// Token bucket refilled at a constant rate up to its burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Create a full token bucket
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// Add the tokens earned since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// Get the delay before the bucket has a token, 0 if it has one
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Take tokens, the bucket goes in debt when a response is larger than the tokens left
+func (b *tokenBucket) take(n float64) {
+	b.tokens -= n
+}
+
+// Check if the bucket is full, an idle client bucket can be dropped
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Token buckets of a client, or of all the clients
+type clientLimits struct {
+	requests  *tokenBucket
+	bandwidth *tokenBucket
+}
+
+// Rate limiter of the requests and response bytes, per client IP and overall
+type rateLimiter struct {
+	mu        sync.Mutex
+	cfg       rateLimitConfig
+	burst     float64
+	global    clientLimits
+	clients   map[string]*clientLimits
+	lastSweep time.Time
+}
+
+// Create the rate limiter from the configuration
+func newRateLimiter(cfg rateLimitConfig) *rateLimiter {
+	now := time.Now()
+	rl := &rateLimiter{cfg: cfg, burst: cfg.Burst, clients: map[string]*clientLimits{}, lastSweep: now}
+	rl.global = rl.newLimits(cfg.Requests, cfg.Bandwidth, now)
+	return rl
+}
+
+// Create the token buckets of the rates set, the bandwidth burst is one second of bytes
+func (rl *rateLimiter) newLimits(requests, bandwidth float64, now time.Time) clientLimits {
+	var limits clientLimits
+	if requests > 0 {
+		burst := rl.burst
+		if burst < 1 {
+			burst = math.Max(1, math.Ceil(requests))
+		}
+		limits.requests = newTokenBucket(requests, burst, now)
+	}
+	if bandwidth > 0 {
+		limits.bandwidth = newTokenBucket(bandwidth, bandwidth, now)
+	}
+	return limits
+}
+
+// Get the token buckets of a client IP, the idle clients are dropped from time to time
+func (rl *rateLimiter) client(ip string, now time.Time) *clientLimits {
+	if now.Sub(rl.lastSweep) > rateLimitSweepInterval {
+		for key, limits := range rl.clients {
+			if (limits.requests == nil || limits.requests.full(now)) && (limits.bandwidth == nil || limits.bandwidth.full(now)) {
+				delete(rl.clients, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+	limits, ok := rl.clients[ip]
+	if !ok {
+		l := rl.newLimits(rl.cfg.RequestsPerIP, rl.cfg.BandwidthPerIP, now)
+		limits = &l
+		rl.clients[ip] = limits
+	}
+	return limits
+}
+
+// Check if the clients have their own limits
+func (rl *rateLimiter) perIP() bool {
+	return rl.cfg.RequestsPerIP > 0 || rl.cfg.BandwidthPerIP > 0
+}
+
+// Admit a request of a client, returns the delay before a retry if it is limited
+func (rl *rateLimiter) admit(ip string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	buckets := []*tokenBucket{rl.global.requests, rl.global.bandwidth}
+	requests := []*tokenBucket{rl.global.requests}
+	if rl.perIP() {
+		limits := rl.client(ip, now)
+		buckets = append(buckets, limits.requests, limits.bandwidth)
+		requests = append(requests, limits.requests)
+	}
+	var wait time.Duration
+	for _, b := range buckets {
+		if b != nil {
+			if w := b.wait(now); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, b := range requests {
+		if b != nil {
+			b.take(1)
+		}
+	}
+	return 0
+}
+
+// Charge the bytes of a response to the bandwidth of its client
+func (rl *rateLimiter) charge(ip string, bytes int) {
+	if bytes <= 0 || (rl.cfg.Bandwidth <= 0 && rl.cfg.BandwidthPerIP <= 0) {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if b := rl.global.bandwidth; b != nil {
+		b.refill(now)
+		b.take(float64(bytes))
+	}
+	if rl.perIP() {
+		if b := rl.client(ip, now).bandwidth; b != nil {
+			b.refill(now)
+			b.take(float64(bytes))
+		}
+	}
+}
+
+// Middleware answering 429 to the clients over the request or bandwidth rates.
+// A response larger than the bandwidth left is sent, the following requests wait for the debt to be paid back.
+func rateLimitMiddleware(rl *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if path := strings.TrimPrefix(c.Request.URL.Path, "/"); isHealthPath(path) {
+			return
+		}
+		ip := c.ClientIP()
+		if wait := rl.admit(ip); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(c, http.StatusTooManyRequests, "TooManyRequests", "Rate limit exceeded, please retry later", "")
+			c.Abort()
+			return
+		}
+		c.Next()
+		rl.charge(ip, c.Writer.Size())
+	}
+}
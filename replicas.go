@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Context key of the target chosen for the reads of a request
+const ctxReplica = "replica"
+
+// Replica routing modes
+const (
+	// The reads go to the target with the lowest observed latency
+	replicaRoutingLatency = "latency"
+	// The reads go to the primary bucket, unless the client asks for a region
+	replicaRoutingPrimary = "primary"
+)
+
+// Weight of a new latency sample in the moving average
+const latencyWeight = 0.2
+
+// Latency recorded for a failed S3 call, so that a failing target is avoided
+const failedCallLatency = 10 * time.Second
+
+// Replica config type, a copy of the buckets in another region
+type replicaConfig struct {
+	// Replica of s3bucket, e.g. the destination of a cross-region replication
+	Bucket string `json:"bucket" yaml:"bucket" toml:"bucket"`
+	// Replicas of the other buckets (bucket mappings, well-known), by bucket name
+	Buckets map[string]string `json:"buckets" yaml:"buckets" toml:"buckets"`
+	// Region of the replicas, default is awsRegion
+	Region string `json:"region" yaml:"region" toml:"region"`
+	// S3 endpoint of the replicas, default is the one of the region
+	Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+}
+
+// Check if replica buckets are configured
+func (cfg *replicaConfig) enabled() bool {
+	return cfg.Bucket != "" || len(cfg.Buckets) > 0
+}
+
+// Set the replica defaults
+func (cfg *replicaConfig) validate(config *webConfig) error {
+	if !cfg.enabled() {
+		return fmt.Errorf("a replica needs a bucket or buckets")
+	}
+	if cfg.Region == "" {
+		cfg.Region = config.AwsRegion
+	}
+	return nil
+}
+
+// Regional read replicas config type
+type replicasConfig struct {
+	Regions []replicaConfig `json:"regions" yaml:"regions" toml:"regions"`
+	// latency (default) or primary
+	Routing string `json:"routing" yaml:"routing" toml:"routing"`
+	// Request header naming the region a client prefers, default X-S3-Region
+	Header string `json:"header" yaml:"header" toml:"header"`
+	// Interval between two latency probes of the regions
+	ProbeInterval duration `json:"probeInterval" yaml:"probeInterval" toml:"probeInterval"`
+}
+
+// Set the replicas defaults and check the regions
+func (cfg *replicasConfig) validate(config *webConfig) error {
+	if len(cfg.Regions) == 0 {
+		return nil
+	}
+	switch cfg.Routing {
+	case "":
+		cfg.Routing = replicaRoutingLatency
+	case replicaRoutingLatency, replicaRoutingPrimary:
+	default:
+		return fmt.Errorf("replicas routing must be latency or primary")
+	}
+	if cfg.Header == "" {
+		cfg.Header = "X-S3-Region"
+	}
+	cfg.ProbeInterval.Duration = cfg.ProbeInterval.orDefault(30 * time.Second)
+	regions := map[string]bool{config.AwsRegion: true}
+	for i := range cfg.Regions {
+		if err := cfg.Regions[i].validate(config); err != nil {
+			return err
+		}
+		if regions[cfg.Regions[i].Region] {
+			return fmt.Errorf("replicas region %s is set twice", cfg.Regions[i].Region)
+		}
+		regions[cfg.Regions[i].Region] = true
+	}
+	return nil
+}
+
+// A region serving the reads, the primary one or a replica, with its observed latency
+type replicaTarget struct {
+	region string
+	client *s3.S3
+	// Replica buckets by primary bucket, nil for the primary region
+	buckets map[string]string
+
+	mu      sync.Mutex
+	latency time.Duration
+	samples int64
+	errors  int64
+}
+
+// Create the client of a replica, with the retry policy and the connections of the primary one.
+// The failures of the replicas do not count for the circuit breaker.
+func newReplicaTarget(sess *session.Session, cfg replicaConfig, config *webConfig) *replicaTarget {
+	awsConfig := &aws.Config{
+		Region:                  aws.String(cfg.Region),
+		EnforceShouldRetryCheck: aws.Bool(true),
+		S3ForcePathStyle:        aws.Bool(config.ForcePathStyle),
+		DisableSSL:              aws.Bool(config.DisableSSL),
+		HTTPClient:              s3Session.Config.HTTPClient,
+		// The keys are sent as validated, as with the primary client
+		DisableRestProtocolURICleaning: aws.Bool(true),
+	}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+	client := s3.New(sess, request.WithRetryer(awsConfig, newS3Retryer(config.Retry)))
+	registerStoredEncoding(client)
+	if config.RequesterPays {
+		registerRequesterPays(client)
+	}
+	buckets := map[string]string{}
+	for bucket, replica := range cfg.Buckets {
+		buckets[bucket] = replica
+	}
+	if cfg.Bucket != "" {
+		buckets[config.S3bucket] = cfg.Bucket
+	}
+	return &replicaTarget{region: cfg.Region, client: client, buckets: buckets}
+}
+
+// Get the bucket of the target holding a primary bucket, false if it has no replica of it
+func (t *replicaTarget) bucket(bucket string) (string, bool) {
+	if t.buckets == nil {
+		return bucket, true
+	}
+	replica, ok := t.buckets[bucket]
+	return replica, ok
+}
+
+// Record the latency of the S3 reads of the target, up to the response headers
+func (t *replicaTarget) register(client *s3.S3) {
+	client.Handlers.Complete.PushBack(func(r *request.Request) {
+		switch r.Operation.Name {
+		case "GetObject", "HeadObject", "ListObjectsV2":
+		default:
+			return
+		}
+		if isCanceled(r.Error) {
+			return
+		}
+		latency := time.Since(r.AttemptTime)
+		if r.Error != nil && (isTransientError(r) || isDeadlineExceeded(r.Error)) {
+			latency = failedCallLatency
+		}
+		t.observe(latency, r.Error != nil)
+	})
+}
+
+// Add a latency sample to the moving average
+func (t *replicaTarget) observe(latency time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.samples == 0 {
+		t.latency = latency
+	} else {
+		t.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(t.latency))
+	}
+	t.samples++
+	if failed {
+		t.errors++
+	}
+}
+
+// Get the average latency, 0 if not measured yet
+func (t *replicaTarget) averageLatency() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latency
+}
+
+// Regional targets of the reads, the primary one first
+type readReplicas struct {
+	cfg     replicasConfig
+	targets []*replicaTarget
+}
+
+// Read replicas, nil if not configured
+var replicas *readReplicas
+
+// Create the targets of the reads and start probing their latency
+func newReadReplicas(sess *session.Session, cfg replicasConfig, config *webConfig) *readReplicas {
+	primary := &replicaTarget{region: config.AwsRegion, client: s3Session}
+	primary.register(s3Session)
+	rr := &readReplicas{cfg: cfg, targets: []*replicaTarget{primary}}
+	for _, replica := range cfg.Regions {
+		target := newReplicaTarget(sess, replica, config)
+		target.register(target.client)
+		rr.targets = append(rr.targets, target)
+	}
+	go rr.probe(config.S3bucket)
+	return rr
+}
+
+// Measure the latency of each target with a small listing, so that the unused regions are still measured
+func (rr *readReplicas) probe(primaryBucket string) {
+	for {
+		for _, target := range rr.targets {
+			bucket, ok := target.bucket(primaryBucket)
+			if !ok {
+				for _, replica := range target.buckets {
+					bucket = replica
+					break
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), currentConfig.Load().Timeouts.List.orDefault(backgroundTimeout))
+			_, err := target.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), MaxKeys: aws.Int64(1)})
+			cancel()
+			if err != nil {
+				log.Debugf("Latency probe of region %s failed: %v", target.region, err)
+			}
+		}
+		time.Sleep(rr.cfg.ProbeInterval.Duration)
+	}
+}
+
+// Choose the target of the reads of a bucket: the region asked in the request header, else the primary
+// one or the lowest observed latency. Only the targets holding the bucket are candidates.
+func (rr *readReplicas) route(c *gin.Context, bucket string) *replicaTarget {
+	if preferred := c.GetHeader(rr.cfg.Header); preferred != "" {
+		for _, target := range rr.targets {
+			if _, ok := target.bucket(bucket); ok && target.region == preferred {
+				return target
+			}
+		}
+	}
+	best := rr.targets[0]
+	if rr.cfg.Routing == replicaRoutingPrimary {
+		return best
+	}
+	for _, target := range rr.targets[1:] {
+		if _, ok := target.bucket(bucket); !ok {
+			continue
+		}
+		// A region not measured yet is not chosen
+		if latency := target.averageLatency(); latency > 0 && (best.averageLatency() == 0 || latency < best.averageLatency()) {
+			best = target
+		}
+	}
+	return best
+}
+
+// Get the target of the reads of a request, chosen once so that all its S3 calls go to the same region
+func (rr *readReplicas) target(c *gin.Context, bucket string) *replicaTarget {
+	if target, ok := c.Get(ctxReplica); ok {
+		if t := target.(*replicaTarget); t != nil {
+			if _, ok := t.bucket(bucket); ok {
+				return t
+			}
+		}
+		return rr.targets[0]
+	}
+	t := rr.route(c, bucket)
+	c.Set(ctxReplica, t)
+	c.Header(rr.cfg.Header, t.region)
+	return t
+}
+
+// Replica state type
+type replicaState struct {
+	Region    string  `json:"region"`
+	Primary   bool    `json:"primary"`
+	LatencyMs float64 `json:"latencyMs"`
+	Samples   int64   `json:"samples"`
+	Errors    int64   `json:"errors"`
+}
+
+// Serve the observed latency of the regions, the lowest first
+func serveReplicaState(c *gin.Context) {
+	states := []replicaState{}
+	if replicas != nil {
+		for i, target := range replicas.targets {
+			target.mu.Lock()
+			states = append(states, replicaState{
+				Region:    target.region,
+				Primary:   i == 0,
+				LatencyMs: float64(target.latency) / float64(time.Millisecond),
+				Samples:   target.samples,
+				Errors:    target.errors,
+			})
+			target.mu.Unlock()
+		}
+	}
+	sort.SliceStable(states, func(i, j int) bool { return states[i].LatencyMs < states[j].LatencyMs })
+	c.JSON(http.StatusOK, states)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Header of the request ID, honored from the client or generated
+const requestIDHeader = "X-Request-ID"
+
+// Context key of the request ID
+const ctxRequestID = "requestID"
+
+// Maximum length of an incoming request ID, longer ones are replaced
+const maxRequestIDLength = 128
+
+// Context key of the trace of a request in the S3 calls contexts
+type requestTraceKey struct{}
+
+// S3 calls of a request, the last AWS request IDs are logged with the request
+type requestTrace struct {
+	mu          sync.Mutex
+	id          string
+	s3RequestID string
+	s3HostID    string
+}
+
+// Record the AWS request IDs of a S3 call
+func (t *requestTrace) record(requestID, hostID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.s3RequestID, t.s3HostID = requestID, hostID
+}
+
+// Last AWS request IDs of the request
+func (t *requestTrace) last() (string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.s3RequestID, t.s3HostID
+}
+
+// Get the trace of the request of a context
+func traceFrom(ctx context.Context) *requestTrace {
+	trace, _ := ctx.Value(requestTraceKey{}).(*requestTrace)
+	return trace
+}
+
+// Get the trace of a request, nil if the request ID middleware did not run
+func requestTraceOf(c *gin.Context) *requestTrace {
+	return traceFrom(c.Request.Context())
+}
+
+// Check that an incoming request ID is short and printable, so that it can be logged safely
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Generate a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Honor or generate the request ID, return it in the response and attach it to the S3 calls
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	c.Set(ctxRequestID, id)
+	c.Header(requestIDHeader, id)
+	trace := &requestTrace{id: id}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestTraceKey{}, trace))
+	c.Next()
+}
+
+// Logger of a request, the lines carry its request ID
+func requestLog(c *gin.Context) *log.Entry {
+	return log.WithField("requestId", c.GetString(ctxRequestID))
+}
+
+// Add the handler recording the AWS request IDs of the S3 calls in the trace of their request
+func registerRequestTracing(svc *s3.S3) {
+	svc.Handlers.Complete.PushBack(func(r *request.Request) {
+		trace := traceFrom(r.Context())
+		if trace == nil {
+			return
+		}
+		hostID, status := "", 0
+		if r.HTTPResponse != nil {
+			hostID, status = r.HTTPResponse.Header.Get("X-Amz-Id-2"), r.HTTPResponse.StatusCode
+		}
+		trace.record(r.RequestID, hostID)
+		log.WithFields(log.Fields{"requestId": trace.id, "s3RequestId": r.RequestID, "s3HostId": hostID}).
+			Debugf("S3 %s %s: %d", r.Operation.Name, r.HTTPRequest.URL.Path, status)
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/gin-gonic/gin"
+)
+
+// S3 error codes of transient failures
+var transientErrorCodes = map[string]bool{
+	"SlowDown":            true,
+	"RequestTimeout":      true,
+	"InternalError":       true,
+	"ServiceUnavailable":  true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+}
+
+// S3 retry policy config type
+type retryConfig struct {
+	// Maximum number of retries of a S3 call
+	MaxRetries *int `json:"maxRetries" yaml:"maxRetries" toml:"maxRetries"`
+	// Delay before the first retry, doubled on each retry
+	BaseDelay duration `json:"baseDelay" yaml:"baseDelay" toml:"baseDelay"`
+	// Maximum delay between two retries
+	MaxDelay duration `json:"maxDelay" yaml:"maxDelay" toml:"maxDelay"`
+}
+
+// Retryer of S3 calls, using exponential backoff with full jitter
+type s3Retryer struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// Create the S3 retryer from the configuration
+func newS3Retryer(cfg retryConfig) *s3Retryer {
+	maxRetries := 3
+	if cfg.MaxRetries != nil {
+		maxRetries = *cfg.MaxRetries
+	}
+	return &s3Retryer{
+		maxRetries: maxRetries,
+		baseDelay:  cfg.BaseDelay.orDefault(100 * time.Millisecond),
+		maxDelay:   cfg.MaxDelay.orDefault(5 * time.Second),
+	}
+}
+
+func (s *s3Retryer) MaxRetries() int {
+	return s.maxRetries
+}
+
+// Retry throttling, 5xx and transient S3 errors
+func (s *s3Retryer) ShouldRetry(r *request.Request) bool {
+	retry := isTransientError(r)
+	if retry && r.RetryCount >= s.maxRetries {
+		retries.exhausted()
+	}
+	return retry
+}
+
+// Get the delay before the next attempt
+func (s *s3Retryer) RetryRules(r *request.Request) time.Duration {
+	retries.retried(errorCode(r.Error))
+	delay := s.maxDelay
+	if r.RetryCount < 30 {
+		if d := s.baseDelay << uint(r.RetryCount); d > 0 && d < s.maxDelay {
+			delay = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// Check if a failed S3 call may succeed when retried
+func isTransientError(r *request.Request) bool {
+	if r.Error == nil {
+		return false
+	}
+	if r.HTTPResponse != nil && r.HTTPResponse.StatusCode >= 500 {
+		return true
+	}
+	if transientErrorCodes[errorCode(r.Error)] {
+		return true
+	}
+	return request.IsErrorThrottle(r.Error) || request.IsErrorRetryable(r.Error)
+}
+
+// Get the AWS error code of an error
+func errorCode(err error) string {
+	if awsError, ok := err.(awserr.Error); ok {
+		return awsError.Code()
+	}
+	return "Unknown"
+}
+
+// Retry metrics type
+type retryMetrics struct {
+	mu        sync.Mutex
+	Retries   int64            `json:"retries"`
+	Exhausted int64            `json:"exhausted"`
+	ByCode    map[string]int64 `json:"byCode"`
+}
+
+// Retry metrics since the server started
+var retries = &retryMetrics{ByCode: map[string]int64{}}
+
+// Count a retry of a failed S3 call
+func (m *retryMetrics) retried(code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Retries++
+	m.ByCode[code]++
+}
+
+// Count a S3 call failing after all retries
+func (m *retryMetrics) exhausted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Exhausted++
+}
+
+// Serve the retry metrics
+func serveRetryMetrics(c *gin.Context) {
+	retries.mu.Lock()
+	defer retries.mu.Unlock()
+	c.JSON(http.StatusOK, retries)
+}
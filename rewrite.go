@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rewrite rule config type, the paths matching Pattern are replaced by Replacement ($1, ${name} expand the groups)
+type rewriteRule struct {
+	Pattern     string `json:"pattern" yaml:"pattern" toml:"pattern"`
+	Replacement string `json:"replacement" yaml:"replacement" toml:"replacement"`
+	re          *regexp.Regexp
+}
+
+// Compile the rewrite rules
+func validateRewrites(rules []rewriteRule) error {
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid rewrite pattern %s: %v", rules[i].Pattern, err)
+		}
+		rules[i].re = re
+	}
+	return nil
+}
+
+// Rewrite a path (without leading /) with the first matching rule
+func (config *webConfig) rewritePath(path string) string {
+	for _, rule := range config.Rewrites {
+		if rule.re.MatchString(path) {
+			return strings.TrimPrefix(rule.re.ReplaceAllString(path, rule.Replacement), "/")
+		}
+	}
+	return path
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path prefixes reserved for the server endpoints, never forwarded to the bucket
+var reservedPrefixes = []string{"_admin/", "_api/"}
+
+// Route parameter type
+type routeParam struct {
+	Name        string
+	In          string // path, query or header
+	Description string
+	Required    bool
+}
+
+// Route definition type, describes an endpoint for both the router and the OpenAPI document
+type routeDef struct {
+	Method    string
+	Path      string // gin path syntax
+	Tag       string
+	Summary   string
+	Params    []routeParam
+	Body      string            // request body content type, if any
+	Responses map[string]string // status code => description
+	// Handler of the route, nil if the route is served by the object handler (NoRoute)
+	Handler gin.HandlerFunc
+}
+
+// Routes of the server, in registration order
+var registeredRoutes []routeDef
+
+// Object key path parameter
+var keyParam = routeParam{Name: "key", In: "path", Description: "Object key", Required: true}
+
+// Object version query parameter
+var versionParam = routeParam{Name: versionIDParam, In: "query", Description: "Version of the object, default is the current version"}
+
+// Methods of the object routes
+var objectMethods = []string{"GET", "HEAD", "PUT", "POST", "DELETE"}
+
+// Check the allowed methods of the object routes and put them in upper case
+func validateAllowedMethods(methods []string) error {
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+		if !containsString(objectMethods, methods[i]) {
+			return fmt.Errorf("invalid allowedMethods %s (support only %s)", method, strings.Join(objectMethods, ", "))
+		}
+	}
+	return nil
+}
+
+// Get the allowed methods of the object routes, all of them if allowedMethods is not set
+func allowedMethods() []string {
+	if len(currentConfig.Load().AllowedMethods) == 0 {
+		return objectMethods
+	}
+	return currentConfig.Load().AllowedMethods
+}
+
+// Check if a method of the object routes is allowed
+func methodAllowed(method string) bool {
+	return containsString(allowedMethods(), method)
+}
+
+// Routes served by the object handler
+func objectRoutes() []routeDef {
+	return []routeDef{
+		{Method: "GET", Path: "/*key", Tag: "object", Summary: "Download an object",
+			Params: []routeParam{keyParam, versionParam,
+				{Name: "format", In: "query", Description: "zip or tar.gz, download the objects under a prefix ending with / as an archive; jpeg, png, gif or webp, convert an image"},
+				{Name: "w", In: "query", Description: "Width of the resized image"},
+				{Name: "h", In: "query", Description: "Height of the resized image"},
+				{Name: "fit", In: "query", Description: "contain, cover or fill, how the image is resized"},
+				{Name: "q", In: "query", Description: "JPEG quality of the transformed image"}},
+			Responses: map[string]string{"200": "Object content", "304": "Object not modified", "404": "Object not found", "412": "Precondition failed"}},
+		{Method: "HEAD", Path: "/*key", Tag: "object", Summary: "Get object headers", Params: []routeParam{keyParam, versionParam},
+			Responses: map[string]string{"200": "Object headers", "304": "Object not modified", "404": "Object not found"}},
+		{Method: "PUT", Path: "/*key", Tag: "object", Summary: "Upload an object", Body: "application/octet-stream",
+			Params: []routeParam{keyParam, {Name: uploadIDHeader, In: "header", Description: "Client id of the upload, to follow its progress"},
+				{Name: "extract", In: "query", Description: "Store the files of a .zip, .tar.gz or .tgz archive under the directory of the key"}},
+			Responses: map[string]string{"200": "Object replaced", "201": "Object created at Location"}},
+		{Method: "POST", Path: "/*key", Tag: "object", Summary: "Upload the files of a form under a path", Body: "multipart/form-data",
+			Params: []routeParam{{Name: "key", In: "path", Description: "Path of the uploaded files", Required: true},
+				{Name: "extract", In: "query", Description: "Store the files of the .zip, .tar.gz or .tgz archives under the path"}},
+			Responses: map[string]string{"201": "Created objects", "400": "Invalid form"}},
+		{Method: "DELETE", Path: "/*key", Tag: "object", Summary: "Delete an object, a version of an object, or all the objects under a prefix ending with /",
+			Params:    []routeParam{keyParam, versionParam, {Name: "dryRun", In: "query", Description: "List the objects of a prefix delete without deleting them"}},
+			Responses: map[string]string{"200": "Report of the prefix delete", "204": "Object deleted"}},
+	}
+}
+
+// Check if the admin endpoints are served on the public port: without the admin port, and only
+// behind the authentication, as they change the server state
+func publicAdminRoutes() bool {
+	return !currentConfig.Load().Admin.enabled() && currentConfig.Load().Auth.enabled()
+}
+
+// Routes of the admin endpoints, served on the admin port if enabled
+func adminRoutes() []routeDef {
+	routes := []routeDef{
+		{Method: "GET", Path: "/_admin/inventory", Tag: "admin", Summary: "Bucket inventory report", Handler: serveInventory,
+			Params:    []routeParam{{Name: "prefix", In: "query", Description: "Only count objects under this prefix"}},
+			Responses: map[string]string{"200": "Inventory report"}},
+		{Method: "GET", Path: "/_admin/cost", Tag: "admin", Summary: "Estimated monthly S3 cost", Handler: serveCost,
+			Responses: map[string]string{"200": "Cost report"}},
+		{Method: "GET", Path: "/_admin/retries", Tag: "admin", Summary: "S3 retry metrics", Handler: serveRetryMetrics,
+			Responses: map[string]string{"200": "Retry counts by error code"}},
+		{Method: "GET", Path: "/_admin/circuit", Tag: "admin", Summary: "Circuit breaker state", Handler: serveCircuitState,
+			Responses: map[string]string{"200": "Circuit breaker state"}},
+		{Method: "GET", Path: "/_admin/replicas", Tag: "admin", Summary: "Observed latency of the read replicas", Handler: serveReplicaState,
+			Responses: map[string]string{"200": "Regions by latency"}},
+		{Method: "GET", Path: "/_admin/chaos", Tag: "admin", Summary: "Chaos mode settings", Handler: serveGetChaos,
+			Responses: map[string]string{"200": "Chaos mode settings"}},
+		{Method: "PUT", Path: "/_admin/chaos", Tag: "admin", Summary: "Change the chaos mode settings", Handler: servePutChaos, Body: "application/json",
+			Responses: map[string]string{"200": "New chaos mode settings", "400": "Invalid settings"}},
+		{Method: "POST", Path: "/_admin/restore", Tag: "admin", Summary: "Restore a deleted object or a version of an object", Handler: serveRestore, Body: "application/json",
+			Responses: map[string]string{"200": "Restored version", "404": "Object not found", "409": "Object is not deleted"}},
+		{Method: "GET", Path: "/_admin/metrics", Tag: "admin", Summary: "Metrics in the Prometheus text format", Handler: serveMetrics,
+			Responses: map[string]string{"200": "Metrics"}},
+		{Method: "POST", Path: "/_admin/reload", Tag: "admin", Summary: "Reload the configuration", Handler: serveReload,
+			Responses: map[string]string{"200": "Applied sections and sections needing a restart", "400": "Invalid configuration"}},
+		{Method: "POST", Path: "/_admin/purge", Tag: "admin", Summary: "Purge the cached objects of a key, a prefix or a glob pattern", Handler: servePurge, Body: "application/json",
+			Responses: map[string]string{"200": "Number of purged entries by cache", "400": "Invalid purge request"}},
+		{Method: "GET", Path: "/_admin/stats", Tag: "admin", Summary: "Goroutines, heap, connections and bucket throughput", Handler: serveStats,
+			Responses: map[string]string{"200": "Runtime statistics"}},
+		{Method: "GET", Path: "/_admin/debug/pprof/*profile", Tag: "admin", Summary: "Go runtime profiles", Handler: servePprof,
+			Responses: map[string]string{"200": "Profile"}},
+		{Method: "POST", Path: "/_admin/debug/pprof/*profile", Tag: "admin", Summary: "Go symbol lookup", Handler: servePprof,
+			Responses: map[string]string{"200": "Symbols"}},
+		{Method: "GET", Path: "/_admin/debug/vars", Tag: "admin", Summary: "Expvar variables", Handler: serveExpvar,
+			Responses: map[string]string{"200": "Variables"}},
+	}
+	if currentConfig.Load().Shares.Enabled {
+		routes = append(routes,
+			routeDef{Method: "POST", Path: "/_admin/shares", Tag: "admin", Summary: "Create a share link to an object", Handler: serveCreateShare, Body: "application/json",
+				Responses: map[string]string{"201": "Share link created", "404": "Object not found"}})
+	}
+	return routes
+}
+
+// Routes served by dedicated handlers
+func serverRoutes() []routeDef {
+	routes := []routeDef{
+		{Method: "GET", Path: "/" + livenessPath, Tag: "health", Summary: "Liveness probe", Handler: serveLiveness,
+			Responses: map[string]string{"200": "Server is alive"}},
+		{Method: "GET", Path: "/" + readinessPath, Tag: "health", Summary: "Readiness probe, checks that the buckets are reachable", Handler: serveReadiness,
+			Responses: map[string]string{"200": "Server is ready", "503": "S3 is not reachable"}},
+		{Method: "POST", Path: "/_api/copy", Tag: "api", Summary: "Copy or move an object to another key", Handler: serveCopy, Body: "application/json",
+			Responses: map[string]string{"200": "Copied object", "400": "Invalid copy request", "403": "Access denied", "404": "Object not found", "412": "Destination already exists"}},
+		{Method: "GET", Path: "/_api/uploads/:id", Tag: "api", Summary: "Upload progress", Handler: serveUploadProgress,
+			Params:    []routeParam{{Name: "id", In: "path", Description: "Upload id given in the X-Upload-Id header"}},
+			Responses: map[string]string{"200": "Upload progress, as JSON or server-sent events", "404": "Upload not found"}},
+		{Method: "GET", Path: "/_api/versions", Tag: "api", Summary: "List the object versions of a prefix", Handler: serveVersions,
+			Params: []routeParam{{Name: "prefix", In: "query", Description: "Only list the versions of the keys under this prefix"},
+				{Name: "maxKeys", In: "query", Description: "Maximum number of versions, at most 1000"},
+				{Name: "keyMarker", In: "query", Description: "nextKeyMarker of the previous page"},
+				{Name: "versionIdMarker", In: "query", Description: "nextVersionIdMarker of the previous page"}},
+			Responses: map[string]string{"200": "Versions and delete markers, newest first for each key"}},
+		{Method: "GET", Path: "/_api/openapi.json", Tag: "api", Summary: "OpenAPI specification", Handler: serveOpenAPI,
+			Responses: map[string]string{"200": "OpenAPI 3 document"}},
+	}
+	if publicAdminRoutes() {
+		routes = append(routes, adminRoutes()...)
+	}
+	if currentConfig.Load().Shares.Enabled {
+		routes = append(routes,
+			routeDef{Method: "GET", Path: "/s/:token", Tag: "object", Summary: "Download a shared object", Handler: serveShare,
+				Params:    []routeParam{{Name: "token", In: "path", Description: "Share token"}},
+				Responses: map[string]string{"200": "Object content", "404": "Share link not found", "410": "Share link expired"}})
+	}
+	if currentConfig.Load().Presign.Enabled {
+		routes = append(routes,
+			routeDef{Method: "POST", Path: "/_api/presign", Tag: "api", Summary: "Create a presigned S3 URL to download or upload an object", Handler: servePresign, Body: "application/json",
+				Responses: map[string]string{"200": "Presigned URL", "400": "Invalid presign request", "403": "Access denied"}})
+	}
+	if currentConfig.Load().Tus.Enabled {
+		idParam := routeParam{Name: "id", In: "path", Description: "Upload id, end of the Location given on creation"}
+		routes = append(routes,
+			routeDef{Method: "OPTIONS", Path: tusPath, Tag: "tus", Summary: "Supported tus version and extensions", Handler: tusHandler(serveTusOptions),
+				Responses: map[string]string{"204": "Tus-Version, Tus-Extension and Tus-Max-Size headers"}},
+			routeDef{Method: "POST", Path: tusPath, Tag: "tus", Summary: "Create a resumable upload of Upload-Length bytes", Handler: tusHandler(serveTusCreate),
+				Params: []routeParam{{Name: "Upload-Length", In: "header", Description: "Size of the object", Required: true},
+					{Name: "Upload-Metadata", In: "header", Description: "key (object path) or filename, and contentType or filetype, base64 encoded"}},
+				Responses: map[string]string{"201": "Upload created at Location", "400": "Invalid upload", "413": "Upload too large"}},
+			routeDef{Method: "HEAD", Path: tusPath + ":id", Tag: "tus", Summary: "Offset of a resumable upload", Handler: tusHandler(serveTusHead),
+				Params: []routeParam{idParam}, Responses: map[string]string{"200": "Upload-Offset and Upload-Length headers", "404": "Upload not found"}},
+			routeDef{Method: "PATCH", Path: tusPath + ":id", Tag: "tus", Summary: "Send the bytes of a resumable upload from Upload-Offset", Handler: tusHandler(serveTusPatch),
+				Body: "application/offset+octet-stream", Params: []routeParam{idParam, {Name: "Upload-Offset", In: "header", Description: "Offset of the bytes sent", Required: true}},
+				Responses: map[string]string{"204": "New Upload-Offset", "404": "Upload not found", "409": "Upload-Offset mismatch", "423": "Upload receiving another PATCH"}},
+			routeDef{Method: "DELETE", Path: tusPath + ":id", Tag: "tus", Summary: "Terminate a resumable upload", Handler: tusHandler(serveTusDelete),
+				Params: []routeParam{idParam}, Responses: map[string]string{"204": "Upload terminated", "404": "Upload not found"}})
+	}
+	if currentConfig.Load().MultipartAPI {
+		keyQuery := routeParam{Name: "key", In: "query", Description: "Key of the upload, as returned on creation", Required: true}
+		uploadParam := routeParam{Name: "uploadId", In: "path", Description: "Id of the multipart upload"}
+		routes = append(routes,
+			routeDef{Method: "POST", Path: "/_api/multipart", Tag: "multipart", Summary: "Initiate a multipart upload", Handler: serveMultipartCreate, Body: "application/json",
+				Responses: map[string]string{"201": "Key and uploadId", "400": "Invalid request", "403": "Access denied"}},
+			routeDef{Method: "GET", Path: "/_api/multipart/:uploadId", Tag: "multipart", Summary: "List the uploaded parts", Handler: serveMultipartParts,
+				Params: []routeParam{uploadParam, keyQuery}, Responses: map[string]string{"200": "Parts", "404": "Upload not found"}},
+			routeDef{Method: "PUT", Path: "/_api/multipart/:uploadId/:partNumber", Tag: "multipart", Summary: "Upload a part", Handler: serveMultipartPart, Body: "application/octet-stream",
+				Params:    []routeParam{uploadParam, {Name: "partNumber", In: "path", Description: "Number of the part, from 1 to 10000"}, keyQuery},
+				Responses: map[string]string{"200": "Part number and ETag", "404": "Upload not found", "413": "Part too large"}},
+			routeDef{Method: "POST", Path: "/_api/multipart/:uploadId", Tag: "multipart", Summary: "Complete a multipart upload", Handler: serveMultipartComplete, Body: "application/json",
+				Params: []routeParam{uploadParam, keyQuery}, Responses: map[string]string{"200": "Created object", "400": "Invalid parts", "404": "Upload not found"}},
+			routeDef{Method: "DELETE", Path: "/_api/multipart/:uploadId", Tag: "multipart", Summary: "Abort a multipart upload", Handler: serveMultipartAbort,
+				Params: []routeParam{uploadParam, keyQuery}, Responses: map[string]string{"204": "Upload aborted", "404": "Upload not found"}})
+	}
+	if currentConfig.Load().ListAPI || currentConfig.Load().UI.Enabled {
+		routes = append(routes,
+			routeDef{Method: "GET", Path: "/_api/list", Tag: "api", Summary: "List a prefix of the bucket", Handler: serveList,
+				Params: []routeParam{
+					{Name: "prefix", In: "query", Description: "Prefix to list, ending with /"},
+					{Name: "delimiter", In: "query", Description: "Delimiter of the sub-prefixes, default is /, empty lists all the objects below the prefix"},
+					{Name: "maxKeys", In: "query", Description: "Most entries of the page, from 1 to 1000"},
+					{Name: "continuationToken", In: "query", Description: "nextContinuationToken of the previous page"}},
+				Responses: map[string]string{"200": "Sub-prefixes and objects under the prefix", "400": "Invalid maxKeys", "403": "Access denied"}})
+	}
+	if currentConfig.Load().SearchAPI {
+		routes = append(routes,
+			routeDef{Method: "GET", Path: "/_api/search", Tag: "api", Summary: "Search the object keys", Handler: serveSearch,
+				Params: []routeParam{
+					{Name: "q", In: "query", Description: "Glob pattern of the paths, or regular expression with regex=true"},
+					{Name: "regex", In: "query", Description: "q is a regular expression"},
+					{Name: "prefix", In: "query", Description: "Prefix searched, default is the whole bucket"},
+					{Name: "minSize", In: "query", Description: "Smallest size in bytes"},
+					{Name: "maxSize", In: "query", Description: "Largest size in bytes"},
+					{Name: "modifiedAfter", In: "query", Description: "RFC 3339 date the objects were modified after"},
+					{Name: "modifiedBefore", In: "query", Description: "RFC 3339 date the objects were modified before"},
+					{Name: "limit", In: "query", Description: "Most results, default is 1000"}},
+				Responses: map[string]string{"200": "Matching objects", "400": "Invalid filter", "403": "Access denied"}})
+	}
+	if currentConfig.Load().SelectAPI {
+		routes = append(routes,
+			routeDef{Method: "POST", Path: "/_api/select", Tag: "api", Summary: "Query an object with S3 Select", Handler: serveSelect, Body: "application/json",
+				Responses: map[string]string{"200": "Records of the results", "400": "Invalid query", "403": "Access denied", "404": "Object not found"}})
+	}
+	if currentConfig.Load().UI.Enabled {
+		routes = append(routes,
+			routeDef{Method: "GET", Path: "/" + uiPrefix + "*file", Tag: "ui", Summary: "File browser UI", Handler: serveUI,
+				Params:    []routeParam{{Name: "file", In: "path", Description: "UI asset"}},
+				Responses: map[string]string{"200": "UI asset"}})
+	}
+	return routes
+}
+
+// Register all routes in the router
+func registerRoutes(router *gin.Engine) {
+	if currentConfig.Load().UI.Enabled {
+		reservedPrefixes = append(reservedPrefixes, uiPrefix)
+	}
+	for _, route := range serverRoutes() {
+		router.Handle(route.Method, route.Path, route.Handler)
+		registeredRoutes = append(registeredRoutes, route)
+	}
+	if !publicAdminRoutes() {
+		router.Any("/_admin/*path", serveAdminElsewhere)
+	}
+	for _, route := range objectRoutes() {
+		if methodAllowed(route.Method) {
+			registeredRoutes = append(registeredRoutes, route)
+		}
+	}
+	router.NoRoute(methodHandler)
+}
+
+// Check if a path (without leading /) is reserved for the server
+func isReservedPath(path string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Default and largest number of results of a search
+const (
+	defaultSearchLimit = 1000
+	maxSearchLimit     = 100000
+)
+
+// Search filters of the objects under a prefix
+type searchFilter struct {
+	glob           string
+	regex          *regexp.Regexp
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+}
+
+// Parse the search filters of the query, returns an error message for the invalid ones
+func parseSearchFilter(c *gin.Context) (*searchFilter, string) {
+	q := c.Query("q")
+	if q == "" {
+		return nil, "q is required"
+	}
+	filter := &searchFilter{glob: strings.TrimPrefix(q, "/"), maxSize: -1}
+	if regex, _ := strconv.ParseBool(c.Query("regex")); regex {
+		re, err := regexp.Compile(q)
+		if err != nil {
+			return nil, "invalid regex: " + err.Error()
+		}
+		filter.regex = re
+	} else if err := validatePathPattern(filter.glob); err != nil {
+		return nil, "invalid pattern: " + err.Error()
+	}
+	for name, size := range map[string]*int64{"minSize": &filter.minSize, "maxSize": &filter.maxSize} {
+		if s := c.Query(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return nil, name + " must be a size in bytes"
+			}
+			*size = n
+		}
+	}
+	for name, date := range map[string]*time.Time{"modifiedAfter": &filter.modifiedAfter, "modifiedBefore": &filter.modifiedBefore} {
+		if s := c.Query(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, name + " must be a RFC 3339 date"
+			}
+			*date = t
+		}
+	}
+	return filter, ""
+}
+
+// Check if an object matches the filters, by its path as seen by the client
+func (f *searchFilter) matches(p string, obj *s3.Object) bool {
+	size := aws.Int64Value(obj.Size)
+	if size < f.minSize || (f.maxSize >= 0 && size > f.maxSize) {
+		return false
+	}
+	modified := aws.TimeValue(obj.LastModified)
+	if (!f.modifiedAfter.IsZero() && !modified.After(f.modifiedAfter)) || (!f.modifiedBefore.IsZero() && !modified.Before(f.modifiedBefore)) {
+		return false
+	}
+	if f.regex != nil {
+		return f.regex.MatchString(p)
+	}
+	return matchPathPattern(f.glob, p)
+}
+
+// Serve a search of the object keys under a prefix. All the pages of the listing are read and
+// the matching objects are streamed as they are found, so the status is sent with the first page:
+// a listing failing later ends the results with an error.
+func serveSearch(c *gin.Context) {
+	filter, invalid := parseSearchFilter(c)
+	if filter == nil {
+		writeError(c, http.StatusBadRequest, "InvalidArgument", invalid, "")
+		return
+	}
+	limit := defaultSearchLimit
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeError(c, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit), "")
+			return
+		}
+		limit = n
+	}
+	prefix := strings.TrimPrefix(c.Query("prefix"), "/")
+	if !checkKeyAccess(c, http.MethodGet, prefix) {
+		return
+	}
+	bucket, keyPrefix := configOf(c).resolveObject(c.Request.Host, prefix)
+
+	w := c.Writer
+	found := 0
+	truncated := false
+	started := false
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(keyPrefix)}
+	err := listObjectsPages(c.Request.Context(), input, func(page *s3.ListObjectsV2Output) bool {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			prefixJSON, _ := json.Marshal(prefix)
+			fmt.Fprintf(w, `{"prefix":%s,"objects":[`, prefixJSON)
+		}
+		for _, obj := range page.Contents {
+			objectPath := prefix + strings.TrimPrefix(aws.StringValue(obj.Key), keyPrefix)
+			if configOf(c).isHiddenKey(objectPath) || !filter.matches(objectPath, obj) || !aclAllows(c, http.MethodGet, objectPath) {
+				continue
+			}
+			if found == limit {
+				truncated = true
+				return false
+			}
+			b, _ := json.Marshal(listObject{Key: objectPath, Size: aws.Int64Value(obj.Size), ETag: aws.StringValue(obj.ETag), LastModified: aws.TimeValue(obj.LastModified)})
+			if found > 0 {
+				w.WriteString(",")
+			}
+			w.Write(b)
+			found++
+		}
+		w.Flush()
+		return c.Request.Context().Err() == nil
+	})
+	if !started {
+		handleHTTPException(c, prefix, err)
+		return
+	}
+	fmt.Fprintf(w, `],"truncated":%t`, truncated)
+	if err != nil && !isCanceled(err) {
+		requestLog(c).Warnf("Search of %s failed after %d results: %v", prefix, found, err)
+		message, _ := json.Marshal("listing failed: " + err.Error())
+		fmt.Fprintf(w, `,"error":%s`, message)
+	}
+	w.WriteString("}")
+}
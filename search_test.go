@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSearchAccess(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket", SearchAPI: true,
+		Auth: authConfig{OIDC: issuer.config(
+			oidcPermission{Prefix: "", Read: []string{"*"}},
+			oidcPermission{Prefix: "docs/secret/", Read: []string{"admin"}},
+		)},
+	})
+	for _, key := range []string{"docs/a.txt", "docs/secret/b.txt"} {
+		fake.put("bucket/"+key, testContent)
+	}
+	w := serveTestRequest(router, http.MethodGet, "/_api/search?q=*.txt&prefix=docs/", bearer(issuer.token(t, "staff")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var result struct {
+		Objects []listObject `json:"objects"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("invalid results %s: %v", w.Body.String(), err)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].Key != "docs/a.txt" {
+		t.Errorf("objects = %v, want docs/a.txt only", result.Objects)
+	}
+}
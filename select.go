@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Trailer of a select response reporting a failure after the first records
+const selectErrorTrailer = "X-Select-Error"
+
+// S3 Select request type
+type selectRequest struct {
+	Key        string       `json:"key" binding:"required"`
+	Expression string       `json:"expression" binding:"required"`
+	Input      selectInput  `json:"input"`
+	Output     selectOutput `json:"output"`
+}
+
+// Serialization of the queried object, the format and the compression default to the ones of the key extension
+type selectInput struct {
+	// csv, json or parquet
+	Format string `json:"format"`
+	// none, gzip or bzip2
+	Compression string `json:"compression"`
+	// CSV first line: use (column names), ignore or none
+	FileHeaderInfo  string `json:"fileHeaderInfo"`
+	FieldDelimiter  string `json:"fieldDelimiter"`
+	RecordDelimiter string `json:"recordDelimiter"`
+	QuoteCharacter  string `json:"quoteCharacter"`
+	Comments        string `json:"comments"`
+	// JSON layout: document or lines
+	JSONType string `json:"jsonType"`
+}
+
+// Serialization of the results, default is JSON lines
+type selectOutput struct {
+	// csv or json
+	Format          string `json:"format"`
+	FieldDelimiter  string `json:"fieldDelimiter"`
+	RecordDelimiter string `json:"recordDelimiter"`
+}
+
+// Get the input serialization of S3, returns an error message for the invalid options
+func (in selectInput) serialization(key string) (*s3.InputSerialization, string) {
+	ext := strings.ToLower(path.Ext(key))
+	compression := strings.ToUpper(in.Compression)
+	if compression == "" {
+		compression = s3.CompressionTypeNone
+		switch ext {
+		case ".gz":
+			compression = s3.CompressionTypeGzip
+		case ".bz2":
+			compression = s3.CompressionTypeBzip2
+		}
+	}
+	if compression != s3.CompressionTypeNone {
+		ext = strings.ToLower(path.Ext(strings.TrimSuffix(key, path.Ext(key))))
+	}
+	if !containsString(s3.CompressionType_Values(), compression) {
+		return nil, "input compression must be none, gzip or bzip2"
+	}
+	format := strings.ToLower(in.Format)
+	if format == "" {
+		format = strings.TrimPrefix(ext, ".")
+	}
+	serialization := &s3.InputSerialization{CompressionType: aws.String(compression)}
+	switch format {
+	case "csv":
+		csv := &s3.CSVInput{FileHeaderInfo: aws.String(s3.FileHeaderInfoNone)}
+		if in.FileHeaderInfo != "" {
+			csv.FileHeaderInfo = aws.String(strings.ToUpper(in.FileHeaderInfo))
+			if !containsString(s3.FileHeaderInfo_Values(), *csv.FileHeaderInfo) {
+				return nil, "input fileHeaderInfo must be use, ignore or none"
+			}
+		}
+		csv.FieldDelimiter = optionalString(in.FieldDelimiter)
+		csv.RecordDelimiter = optionalString(in.RecordDelimiter)
+		csv.QuoteCharacter = optionalString(in.QuoteCharacter)
+		csv.Comments = optionalString(in.Comments)
+		serialization.CSV = csv
+	case "json", "jsonl", "ndjson":
+		jsonType := strings.ToUpper(in.JSONType)
+		if jsonType == "" {
+			jsonType = s3.JSONTypeDocument
+			if format != "json" {
+				jsonType = s3.JSONTypeLines
+			}
+		}
+		if !containsString(s3.JSONType_Values(), jsonType) {
+			return nil, "input jsonType must be document or lines"
+		}
+		serialization.JSON = &s3.JSONInput{Type: aws.String(jsonType)}
+	case "parquet":
+		serialization.Parquet = &s3.ParquetInput{}
+	default:
+		return nil, "input format must be csv, json or parquet"
+	}
+	return serialization, ""
+}
+
+// Get the output serialization of S3 and the content type of the results
+func (out selectOutput) serialization() (*s3.OutputSerialization, string, string) {
+	switch strings.ToLower(out.Format) {
+	case "csv":
+		csv := &s3.CSVOutput{FieldDelimiter: optionalString(out.FieldDelimiter), RecordDelimiter: optionalString(out.RecordDelimiter)}
+		return &s3.OutputSerialization{CSV: csv}, "text/csv; charset=utf-8", ""
+	case "", "json":
+		lines := &s3.JSONOutput{RecordDelimiter: optionalString(out.RecordDelimiter)}
+		return &s3.OutputSerialization{JSON: lines}, "application/x-ndjson", ""
+	}
+	return nil, "", "output format must be csv or json"
+}
+
+// Get a S3 string parameter, nil if empty
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// Query an object with S3 Select and stream the records of the results. The status is sent with
+// the first records, a failure of the query afterwards is reported in the X-Select-Error trailer.
+func serveSelect(c *gin.Context) {
+	var req selectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid select request: "+err.Error(), "")
+		return
+	}
+	key := strings.TrimPrefix(req.Key, "/")
+	if key == "" || strings.HasSuffix(key, "/") || configOf(c).isHiddenKey(key) {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid select key", "")
+		return
+	}
+	if !methodAllowed(http.MethodGet) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Method GET not allowed", "")
+		return
+	}
+	if !checkKeyAccess(c, http.MethodGet, key) {
+		return
+	}
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	if configOf(c).encryptionFor(objectKey) != nil {
+		// S3 only sees the ciphertext
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Objects under an encryption prefix cannot be selected", "")
+		return
+	}
+	inputSerialization, invalid := req.Input.serialization(key)
+	if inputSerialization == nil {
+		writeError(c, http.StatusBadRequest, "InvalidArgument", invalid, "")
+		return
+	}
+	outputSerialization, contentType, invalid := req.Output.serialization()
+	if outputSerialization == nil {
+		writeError(c, http.StatusBadRequest, "InvalidArgument", invalid, "")
+		return
+	}
+	input := &s3.SelectObjectContentInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(objectKey),
+		Expression:          aws.String(req.Expression),
+		ExpressionType:      aws.String(s3.ExpressionTypeSql),
+		InputSerialization:  inputSerialization,
+		OutputSerialization: outputSerialization,
+	}
+	if err := applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5); err != nil {
+		writeSSECustomerError(c, err)
+		return
+	}
+
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.SelectObjectContentWithContext(ctx, input)
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	stream := resp.GetStream()
+	defer stream.Close()
+
+	w := c.Writer
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Trailer", selectErrorTrailer)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	var sent int64
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *s3.RecordsEvent:
+			start()
+			n, _ := w.Write(e.Payload)
+			sent += int64(n)
+			w.Flush()
+		case *s3.StatsEvent:
+			requestLog(c).Debugf("Select of %s scanned %d bytes, returned %d bytes", key, aws.Int64Value(e.Details.BytesScanned), aws.Int64Value(e.Details.BytesReturned))
+		}
+	}
+	usage.addBytesOut(sent)
+	err = stream.Err()
+	if !started {
+		if handleHTTPException(c, key, err) == nil {
+			start()
+		}
+		return
+	}
+	if err != nil && !isCanceled(err) {
+		requestLog(c).Warnf("Select of %s failed after %d bytes: %v", key, sent, err)
+		// A header value is a single line
+		w.Header().Set(selectErrorTrailer, strings.Join(strings.Fields(err.Error()), " "))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// HTTP server limits config type. The read and write timeouts cover the whole upload or download,
+// zero keeps the large transfers possible; the slow clients are bounded by the header and idle ones.
+type serverConfig struct {
+	// Delay to read a whole request, body included
+	ReadTimeout duration `json:"readTimeout" yaml:"readTimeout" toml:"readTimeout"`
+	// Delay to read the request headers
+	ReadHeaderTimeout duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout" toml:"readHeaderTimeout"`
+	// Delay to write a whole response, from the end of the request headers
+	WriteTimeout duration `json:"writeTimeout" yaml:"writeTimeout" toml:"writeTimeout"`
+	// Delay before an idle keep-alive connection is closed
+	IdleTimeout duration `json:"idleTimeout" yaml:"idleTimeout" toml:"idleTimeout"`
+	// Largest size (in bytes) of the request headers
+	MaxHeaderBytes int `json:"maxHeaderBytes" yaml:"maxHeaderBytes" toml:"maxHeaderBytes"`
+	// Largest number of open connections, the next ones wait to be accepted (0 is unlimited)
+	MaxConnections int `json:"maxConnections" yaml:"maxConnections" toml:"maxConnections"`
+}
+
+// Set the server limits defaults
+func (cfg *serverConfig) validate() error {
+	cfg.ReadHeaderTimeout.Duration = cfg.ReadHeaderTimeout.orDefault(10 * time.Second)
+	cfg.IdleTimeout.Duration = cfg.IdleTimeout.orDefault(2 * time.Minute)
+	if cfg.MaxHeaderBytes <= 0 {
+		cfg.MaxHeaderBytes = 1 << 20
+	}
+	if cfg.MaxConnections < 0 {
+		return fmt.Errorf("server maxConnections must not be negative")
+	}
+	return nil
+}
+
+// Create the HTTP server of the TCP listener with the configured limits, its connections are counted in the stats
+func newHTTPServer(addr string, handler http.Handler, cfg serverConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ConnState:         connections.track,
+	}
+}
+
+// Listen on the server address and serve, HTTPS with the TLS config of the server if set.
+// The connections over maxConnections wait in the listen backlog, the QUIC ones are not counted.
+func listenAndServe(srv *http.Server, cfg serverConfig) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if cfg.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, cfg.MaxConnections)
+	}
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
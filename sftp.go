@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Size of the ranges read from S3 for the SFTP downloads, and number of ranges kept by a download
+	sftpReadBlockSize = 4 * 1024 * 1024
+	sftpReadBlocks    = 4
+	// Most bytes of the out of order writes of an SFTP upload waiting for the missing ones
+	sftpMaxPendingWrites = 64 * 1024 * 1024
+)
+
+// SFTP gateway config type, disabled without listen address
+type sftpConfig struct {
+	// Address of the SFTP listener (e.g. ":2022")
+	Listen string `json:"listen" yaml:"listen" toml:"listen"`
+	// PEM file of the host private key, default is a key generated at startup
+	HostKey string `json:"hostKey" yaml:"hostKey" toml:"hostKey"`
+	// Public keys of the clients by user name, in the authorized_keys format.
+	// The auth users log in with their password too.
+	AuthorizedKeys map[string][]string `json:"authorizedKeys" yaml:"authorizedKeys" toml:"authorizedKeys"`
+
+	signer ssh.Signer
+	keys   map[string][]ssh.PublicKey
+}
+
+// Check if the SFTP gateway is enabled
+func (cfg sftpConfig) enabled() bool {
+	return cfg.Listen != ""
+}
+
+// Load the host key and parse the client keys
+func (cfg *sftpConfig) validate(auth authConfig) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	if len(auth.Users) == 0 && len(cfg.AuthorizedKeys) == 0 {
+		return fmt.Errorf("sftp needs auth users or authorizedKeys")
+	}
+	cfg.keys = map[string][]ssh.PublicKey{}
+	for user, lines := range cfg.AuthorizedKeys {
+		for _, line := range lines {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return fmt.Errorf("invalid sftp authorized key of %s: %v", user, err)
+			}
+			cfg.keys[user] = append(cfg.keys[user], key)
+		}
+	}
+	if cfg.HostKey == "" {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("unable to generate the sftp host key: %v", err)
+		}
+		cfg.signer, err = ssh.NewSignerFromKey(private)
+		return err
+	}
+	pem, err := ioutil.ReadFile(cfg.HostKey)
+	if err != nil {
+		return fmt.Errorf("unable to read the sftp host key: %v", err)
+	}
+	if cfg.signer, err = ssh.ParsePrivateKey(pem); err != nil {
+		return fmt.Errorf("invalid sftp host key %s: %v", cfg.HostKey, err)
+	}
+	return nil
+}
+
+// SSH server config of the gateway, the clients log in with the auth users passwords or their keys
+func (cfg sftpConfig) serverConfig(auth authConfig) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			for _, user := range auth.Users {
+				if user.Name == conn.User() && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), password) == nil {
+					return &ssh.Permissions{}, nil
+				}
+			}
+			return nil, fmt.Errorf("invalid password of %s", conn.User())
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range cfg.keys[conn.User()] {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return &ssh.Permissions{}, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown key of %s", conn.User())
+		},
+	}
+	config.AddHostKey(cfg.signer)
+	return config
+}
+
+// Start the SFTP gateway, the returned listener is closed on shutdown
+func startSFTP(cfg sftpConfig, auth authConfig) (net.Listener, error) {
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HostKey == "" {
+		log.Warnf("SFTP host key generated, its fingerprint changes on each start: %s", ssh.FingerprintSHA256(cfg.signer.PublicKey()))
+	}
+	log.Infof("SFTP gateway listening on %s", cfg.Listen)
+	config := cfg.serverConfig(auth)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				return
+			}
+			go serveSFTPConn(conn, config)
+		}
+	}()
+	return listener, nil
+}
+
+// Serve the sftp subsystem sessions of an SSH connection
+func serveSFTPConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Debugf("SFTP handshake of %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	log.Debugf("SFTP client %s connected from %s", sshConn.User(), conn.RemoteAddr())
+	go ssh.DiscardRequests(requests)
+	handler := &sftpHandler{identity: &authIdentity{Name: sshConn.User()}}
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Debugf("SFTP channel of %s refused: %v", sshConn.User(), err)
+			continue
+		}
+		go func() {
+			// Only the sftp subsystem is served, no shell nor command
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: handler, FilePut: handler, FileCmd: handler, FileList: handler})
+				if err := server.Serve(); err != nil && err != io.EOF {
+					log.Debugf("SFTP session of %s ended: %v", sshConn.User(), err)
+				}
+				server.Close()
+			}
+		}()
+	}
+}
+
+// File operations of an SFTP client, translated to S3 calls
+type sftpHandler struct {
+	identity *authIdentity
+}
+
+// Get the object path of an SFTP path, without leading /
+func sftpPath(filepath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath), "/")
+}
+
+// Resolve the bucket and the key of an object path, as the requests without host.
+// The rewrite rules only apply to the HTTP requests.
+func sftpResolve(objectPath string) (bucket, key string) {
+	config := currentConfig.Load()
+	bucket, key = config.S3bucket, objectPath
+	if m := config.findBucketMapping("", objectPath); m != nil {
+		bucket, key = m.resolve(objectPath)
+	}
+	return bucket, config.KeyPrefix + key
+}
+
+// Check if the client can use a method on an object path, as the HTTP clients with the same name
+func (h *sftpHandler) allows(method, objectPath string) error {
+	if objectPath == "" && method != http.MethodGet {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if currentConfig.Load().isHiddenKey(objectPath) {
+		return os.ErrNotExist
+	}
+	if !methodAllowed(method) || !currentConfig.Load().aclAllowsIdentity(h.identity, method, objectPath) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+// Translate an S3 error to an SFTP status
+func sftpError(err error) error {
+	if isNotFoundError(err) {
+		return os.ErrNotExist
+	}
+	if errorCode(err) == "AccessDenied" {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return err
+}
+
+// Open a file for reading
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	objectPath := sftpPath(r.Filepath)
+	if err := h.allows(http.MethodGet, objectPath); err != nil {
+		return nil, err
+	}
+	bucket, key := sftpResolve(objectPath)
+	ctx, cancel := s3Context(r.Context(), configFrom(r.Context()).Timeouts.Head)
+	defer cancel()
+	head, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	log.Debugf("SFTP %s reads %s", h.identity.Name, objectPath)
+	if isEncrypted(head.Metadata) {
+		// The encrypted objects are decrypted as a whole, as for the archives
+		body, size, err := openArchiveEntry(r.Context(), bucket, key)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		defer body.Close()
+		plaintext, err := ioutil.ReadAll(body)
+		usage.addBytesOut(size)
+		return bytes.NewReader(plaintext), err
+	}
+	return &s3ReaderAt{bucket: bucket, key: key, size: aws.Int64Value(head.ContentLength), blocks: map[int64][]byte{}}, nil
+}
+
+// Open a file for writing, the object is stored on close
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	objectPath := configFrom(r.Context()).normalizeUploadKey(sftpPath(r.Filepath))
+	if err := h.allows(http.MethodPut, objectPath); err != nil {
+		return nil, err
+	}
+	if r.Pflags().Append {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	bucket, key := sftpResolve(objectPath)
+	pr, pw := io.Pipe()
+	w := &s3WriterAt{pipe: pw, pending: map[int64][]byte{}, done: make(chan error, 1)}
+	go func() {
+		counted := &countingReader{Reader: pr}
+		params := &s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: counted, ContentType: aws.String(configFrom(r.Context()).objectContentType(objectPath, nil))}
+		configFrom(r.Context()).applyServerSideEncryption(nil, &params.ServerSideEncryption, &params.SSEKMSKeyId, &params.BucketKeyEnabled)
+		_, err := storeObject(context.Background(), params, -1, nil)
+		usage.addBytesIn(counted.n)
+		// Unblock the writes if the upload failed
+		pr.CloseWithError(err)
+		if err == nil {
+			log.Infof("SFTP %s stored %s (%d bytes)", h.identity.Name, objectPath, counted.n)
+		}
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// Run a file command
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	objectPath := sftpPath(r.Filepath)
+	ctx, cancel := s3Context(r.Context(), configFrom(r.Context()).Timeouts.Put)
+	defer cancel()
+	switch r.Method {
+	case "Setstat":
+		// Modes, owners and times are not kept by S3
+		return nil
+	case "Rename", "PosixRename":
+		return h.rename(ctx, objectPath, configFrom(r.Context()).normalizeUploadKey(sftpPath(r.Target)), r.Method == "PosixRename")
+	case "Remove":
+		if err := h.allows(http.MethodDelete, objectPath); err != nil {
+			return err
+		}
+		bucket, key := sftpResolve(objectPath)
+		if err := h.checkSoftDelete(ctx, bucket); err != nil {
+			return err
+		}
+		if _, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+			return sftpError(err)
+		}
+		if _, err := s3Session.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+			return sftpError(err)
+		}
+		caches.invalidate(bucket, key)
+		log.Infof("SFTP %s deleted %s", h.identity.Name, objectPath)
+		return nil
+	case "Mkdir":
+		// A directory is kept by an empty "<dir>/" object until it has files
+		if err := h.allows(http.MethodPut, objectPath+"/"); err != nil {
+			return err
+		}
+		bucket, key := sftpResolve(objectPath + "/")
+		_, err := s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: bytes.NewReader(nil)})
+		return sftpError(err)
+	case "Rmdir":
+		if err := h.allows(http.MethodDelete, objectPath+"/"); err != nil {
+			return err
+		}
+		bucket, key := sftpResolve(objectPath + "/")
+		resp, err := s3Session.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(key), MaxKeys: aws.Int64(2)})
+		if err != nil {
+			return sftpError(err)
+		}
+		if len(resp.Contents) == 0 {
+			return os.ErrNotExist
+		}
+		if len(resp.Contents) > 1 || aws.StringValue(resp.Contents[0].Key) != key {
+			return fmt.Errorf("directory %s is not empty", objectPath)
+		}
+		_, err = s3Session.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		return sftpError(err)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Refuse the deletes that would not only add a delete marker in soft delete mode
+func (h *sftpHandler) checkSoftDelete(ctx context.Context, bucket string) error {
+	if !configFrom(ctx).SoftDelete {
+		return nil
+	}
+	enabled, err := versioningEnabled(ctx, bucket)
+	if err != nil {
+		return sftpError(err)
+	}
+	if !enabled {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+// Rename a file with a copy and a delete, the directories cannot be renamed.
+// Without overwrite, an existing target is refused as the SFTP rename requires.
+func (h *sftpHandler) rename(ctx context.Context, source, target string, overwrite bool) error {
+	if source == "" || target == "" || source == target {
+		return sftp.ErrSSHFxFailure
+	}
+	for _, check := range []struct{ method, path string }{{http.MethodGet, source}, {http.MethodDelete, source}, {http.MethodPut, target}} {
+		if err := h.allows(check.method, check.path); err != nil {
+			return err
+		}
+	}
+	srcBucket, srcKey := sftpResolve(source)
+	dstBucket, dstKey := sftpResolve(target)
+	if configFrom(ctx).encryptionFor(srcKey) != configFrom(ctx).encryptionFor(dstKey) {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if err := h.checkSoftDelete(ctx, srcBucket); err != nil {
+		return err
+	}
+	if !overwrite {
+		_, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(dstBucket), Key: aws.String(dstKey)})
+		if err == nil {
+			return sftp.ErrSSHFxFailure
+		}
+		if !isNotFoundError(err) {
+			return sftpError(err)
+		}
+	}
+	if _, _, err := copyObject(ctx, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+		if isNotFoundError(err) {
+			// Directories are prefixes, they would need all their objects copied
+			return h.renameError(ctx, srcBucket, srcKey)
+		}
+		return sftpError(err)
+	}
+	caches.invalidate(dstBucket, dstKey)
+	if _, err := s3Session.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(srcBucket), Key: aws.String(srcKey)}); err != nil {
+		return sftpError(err)
+	}
+	caches.invalidate(srcBucket, srcKey)
+	log.Infof("SFTP %s renamed %s to %s", h.identity.Name, source, target)
+	return nil
+}
+
+// Get the error of the rename of a missing object: unsupported for a directory, else not found
+func (h *sftpHandler) renameError(ctx context.Context, bucket, key string) error {
+	if isDir, err := sftpPrefixExists(ctx, bucket, key+"/"); err == nil && isDir {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return os.ErrNotExist
+}
+
+// Check if objects exist under a prefix
+func sftpPrefixExists(ctx context.Context, bucket, prefix string) (bool, error) {
+	resp, err := s3Session.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix), MaxKeys: aws.Int64(1)})
+	if err != nil {
+		return false, err
+	}
+	return len(resp.Contents) > 0, nil
+}
+
+// List a directory or stat a file
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	objectPath := sftpPath(r.Filepath)
+	ctx, cancel := s3Context(r.Context(), configFrom(r.Context()).Timeouts.List)
+	defer cancel()
+	switch r.Method {
+	case "List":
+		prefix := objectPath
+		if prefix != "" {
+			prefix += "/"
+		}
+		if err := h.allows(http.MethodGet, prefix); err != nil {
+			return nil, err
+		}
+		bucket, keyPrefix := sftpResolve(prefix)
+		result, err := listDirectory(ctx, bucket, keyPrefix)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		var files sftpLister
+		dirs := map[string]bool{}
+		for _, p := range result.Prefixes {
+			dirs[p] = true
+			files = append(files, &sftpFileInfo{name: path.Base(p), dir: true})
+		}
+		for _, object := range result.Objects {
+			// A directory marker is listed once, as its prefix
+			if strings.HasSuffix(object.Key, "/") {
+				if !dirs[object.Key] {
+					dirs[object.Key] = true
+					files = append(files, &sftpFileInfo{name: path.Base(object.Key), modTime: object.LastModified, dir: true})
+				}
+				continue
+			}
+			if currentConfig.Load().aclAllowsIdentity(h.identity, http.MethodGet, prefix+strings.TrimPrefix(object.Key, keyPrefix)) {
+				files = append(files, &sftpFileInfo{name: path.Base(object.Key), size: object.Size, modTime: object.LastModified})
+			}
+		}
+		return files, nil
+	case "Stat":
+		if objectPath == "" {
+			return sftpLister{&sftpFileInfo{name: "/", dir: true}}, nil
+		}
+		if configFrom(r.Context()).isHiddenKey(objectPath) {
+			return nil, os.ErrNotExist
+		}
+		bucket, key := sftpResolve(objectPath)
+		head, err := s3Session.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err == nil {
+			size := aws.Int64Value(head.ContentLength)
+			if n, ok := plaintextLength(head.Metadata); ok {
+				size = n
+			}
+			return sftpLister{&sftpFileInfo{name: path.Base(objectPath), size: size, modTime: aws.TimeValue(head.LastModified)}}, nil
+		}
+		if !isNotFoundError(err) {
+			return nil, sftpError(err)
+		}
+		isDir, err := sftpPrefixExists(ctx, bucket, key+"/")
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		if !isDir {
+			return nil, os.ErrNotExist
+		}
+		return sftpLister{&sftpFileInfo{name: path.Base(objectPath), dir: true}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// File listing of an SFTP client
+type sftpLister []os.FileInfo
+
+func (l sftpLister) ListAt(files []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(files, l[offset:])
+	if n < len(files) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// File of an SFTP listing, an object or a prefix
+type sftpFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (f *sftpFileInfo) Name() string       { return f.name }
+func (f *sftpFileInfo) Size() int64        { return f.size }
+func (f *sftpFileInfo) ModTime() time.Time { return f.modTime }
+func (f *sftpFileInfo) IsDir() bool        { return f.dir }
+func (f *sftpFileInfo) Sys() interface{}   { return nil }
+
+func (f *sftpFileInfo) Mode() os.FileMode {
+	if f.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// Reader of an object by ranges of sftpReadBlockSize bytes. The clients send their reads
+// concurrently and out of order, the last ranges read are kept to serve them.
+type s3ReaderAt struct {
+	mu     sync.Mutex
+	bucket string
+	key    string
+	size   int64
+	blocks map[int64][]byte
+	order  []int64
+}
+
+func (r *s3ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		block, err := r.block(off / sftpReadBlockSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], block[off%sftpReadBlockSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// Get a range of the object, read from S3 if it is not kept
+func (r *s3ReaderAt) block(index int64) ([]byte, error) {
+	if b, ok := r.blocks[index]; ok {
+		return b, nil
+	}
+	start := index * sftpReadBlockSize
+	end := start + sftpReadBlockSize - 1
+	if end >= r.size {
+		end = r.size - 1
+	}
+	ctx, cancel := s3Context(context.Background(), currentConfig.Load().Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(r.bucket), Key: aws.String(r.key), Range: aws.String(fmt.Sprintf("bytes=%d-%d", start, end))})
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	usage.addBytesOut(int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != end-start+1 {
+		// The object changed since it was opened
+		return nil, io.ErrUnexpectedEOF
+	}
+	if len(r.order) >= sftpReadBlocks {
+		delete(r.blocks, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.blocks[index] = b
+	r.order = append(r.order, index)
+	return b, nil
+}
+
+// Writer streaming an upload to S3. The clients send their writes concurrently
+// and out of order, the writes after a missing one wait in memory.
+type s3WriterAt struct {
+	mu      sync.Mutex
+	pipe    *io.PipeWriter
+	offset  int64
+	pending map[int64][]byte
+	waiting int
+	done    chan error
+}
+
+func (w *s3WriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if off < w.offset {
+		return 0, fmt.Errorf("rewrites are not supported")
+	}
+	if off > w.offset {
+		if w.waiting+len(p) > sftpMaxPendingWrites {
+			return 0, fmt.Errorf("too many out of order writes")
+		}
+		w.pending[off] = append([]byte(nil), p...)
+		w.waiting += len(p)
+		return len(p), nil
+	}
+	if _, err := w.pipe.Write(p); err != nil {
+		return 0, err
+	}
+	w.offset += int64(len(p))
+	for {
+		next, ok := w.pending[w.offset]
+		if !ok {
+			return len(p), nil
+		}
+		delete(w.pending, w.offset)
+		w.waiting -= len(next)
+		if _, err := w.pipe.Write(next); err != nil {
+			return 0, err
+		}
+		w.offset += int64(len(next))
+	}
+}
+
+// Complete the upload, the error of the upload is returned to the client
+func (w *s3WriterAt) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 {
+		w.pipe.CloseWithError(fmt.Errorf("missing writes"))
+		<-w.done
+		return fmt.Errorf("upload with missing writes")
+	}
+	w.pipe.Close()
+	return <-w.done
+}
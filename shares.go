@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Share links config type
+type sharesConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Key prefix of the share records in the bucket, never served directly
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	// Lifetime of a share link when not given on creation
+	DefaultExpiry duration `json:"defaultExpiry" yaml:"defaultExpiry" toml:"defaultExpiry"`
+}
+
+// Get the key prefix of the share records
+func (cfg sharesConfig) prefix() string {
+	if cfg.Prefix == "" {
+		return "_shares/"
+	}
+	return cfg.Prefix
+}
+
+// Check if a key is a share record, which must not be served as an object
+func (cfg sharesConfig) hides(key string) bool {
+	return cfg.Enabled && strings.HasPrefix(key, cfg.prefix())
+}
+
+// Share record type, stored as a JSON object in the bucket
+type shareRecord struct {
+	Key          string    `json:"key"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int       `json:"maxDownloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+}
+
+// Share creation request type
+type shareRequest struct {
+	Key          string   `json:"key" binding:"required"`
+	ExpiresIn    duration `json:"expiresIn"`
+	MaxDownloads int      `json:"maxDownloads"`
+}
+
+// Share creation response type
+type shareResponse struct {
+	Token   string    `json:"token"`
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lock of the record of a share link, with the number of requests holding or waiting for it
+type shareLock struct {
+	sync.Mutex
+	users int
+}
+
+// Locks of the share records in use, so that the download count updates of a link are serialized
+var shareLocks = struct {
+	sync.Mutex
+	tokens map[string]*shareLock
+}{tokens: map[string]*shareLock{}}
+
+// Lock the record of a share link, the returned function unlocks it
+func lockShare(token string) func() {
+	shareLocks.Lock()
+	lock, ok := shareLocks.tokens[token]
+	if !ok {
+		lock = &shareLock{}
+		shareLocks.tokens[token] = lock
+	}
+	lock.users++
+	shareLocks.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		shareLocks.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(shareLocks.tokens, token)
+		}
+		shareLocks.Unlock()
+	}
+}
+
+// Generate a new share token
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Get the object key of a share record
+func (config *webConfig) shareRecordKey(token string) string {
+	return config.Shares.prefix() + token + ".json"
+}
+
+// Load a share record, nil if the token is unknown
+func loadShare(c *gin.Context, token string) (*shareRecord, error) {
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(configOf(c).S3bucket),
+		Key:    aws.String(configOf(c).shareRecordKey(token)),
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	record := &shareRecord{}
+	return record, json.Unmarshal(data, record)
+}
+
+// Save a share record
+func saveShare(c *gin.Context, token string, record *shareRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	_, err = s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(configOf(c).S3bucket),
+		Key:         aws.String(configOf(c).shareRecordKey(token)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Create a share link to an object
+func serveCreateShare(c *gin.Context) {
+	var req shareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid share request: "+err.Error(), "")
+		return
+	}
+	key := strings.TrimPrefix(req.Key, "/")
+	if req.MaxDownloads < 0 || configOf(c).isHiddenKey(key) {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid share request", "")
+		return
+	}
+	// The client can only share the objects it can read
+	if !checkKeyAccess(c, http.MethodGet, key) {
+		return
+	}
+	if bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key); !objectExists(c, bucket, objectKey) {
+		writeError(c, http.StatusNotFound, "NotFound", "Path '"+key+"' not found", "")
+		return
+	}
+	token, err := newShareToken()
+	if err != nil {
+		writeInternalError(c, "InternalError", "Failed to generate a share token: "+err.Error(), "")
+		return
+	}
+	now := time.Now().UTC()
+	record := &shareRecord{
+		Key:          key,
+		Created:      now,
+		Expires:      now.Add(req.ExpiresIn.orDefault(configOf(c).Shares.DefaultExpiry.orDefault(24 * time.Hour))),
+		MaxDownloads: req.MaxDownloads,
+	}
+	if err := saveShare(c, token, record); err != nil {
+		handleHTTPException(c, key, err)
+		return
+	}
+	requestLog(c).Infof("Share %s created for %s, expires %s", token, key, record.Expires.Format(time.RFC3339))
+	c.JSON(http.StatusCreated, shareResponse{Token: token, URL: "/s/" + token, Expires: record.Expires})
+}
+
+// Serve the object of a share link, counting the download. The download is counted before the
+// object is served, so that the concurrent requests cannot get past the limit.
+func serveShare(c *gin.Context) {
+	token := c.Param("token")
+	unlock := lockShare(token)
+	record, err := loadShare(c, token)
+	if err != nil || record == nil {
+		unlock()
+		if err != nil {
+			handleHTTPException(c, token, err)
+			return
+		}
+		writeError(c, http.StatusNotFound, "NotFound", "Share link not found", "")
+		return
+	}
+	if time.Now().After(record.Expires) || (record.MaxDownloads > 0 && record.Downloads >= record.MaxDownloads) {
+		unlock()
+		writeError(c, http.StatusGone, "Gone", "Share link has expired", "")
+		return
+	}
+	// Every request counts, the range requests too, so that the ranges cannot get the object past the limit
+	record.Downloads++
+	err = saveShare(c, token, record)
+	unlock()
+	if err != nil {
+		handleHTTPException(c, token, err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=\""+strings.Replace(path.Base(record.Key), "\"", "", -1)+"\"")
+	c.Header("Cache-Control", "private, no-store")
+	bucket, key := configOf(c).resolveObject(c.Request.Host, record.Key)
+	serveGetS3File(c, bucket, key)
+	// A failed request (S3 error, SSE-C key mismatch, ...) gives its download back
+	if status := c.Writer.Status(); status != http.StatusOK && status != http.StatusPartialContent {
+		releaseShareDownload(c, token)
+	}
+}
+
+// Give back the download counted by a failed request of a share link
+func releaseShareDownload(c *gin.Context, token string) {
+	unlock := lockShare(token)
+	defer unlock()
+	record, err := loadShare(c, token)
+	if err == nil && record != nil && record.Downloads > 0 {
+		record.Downloads--
+		err = saveShare(c, token, record)
+	}
+	if err != nil {
+		requestLog(c).Warnf("Failed to give back the download of share %s: %v", token, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server with share links, the token "secret" authenticates the admin client
+func newShareTestServer(t *testing.T, config *webConfig) (*gin.Engine, *fakeS3) {
+	t.Helper()
+	config.S3bucket = "bucket"
+	config.Shares.Enabled = true
+	config.Auth.Tokens = append(config.Auth.Tokens, authToken{Name: "admin", Token: "secret"})
+	return newTestServer(t, config)
+}
+
+// Create a share link, returns its URL
+func createTestShare(t *testing.T, router *gin.Engine, body string) string {
+	t.Helper()
+	w := serveTestBody(router, http.MethodPost, "/_admin/shares", bearer("secret"), body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("share creation status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var share shareResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &share); err != nil {
+		t.Fatalf("invalid share %s: %v", w.Body.String(), err)
+	}
+	return share.URL
+}
+
+func TestShareRangeDownloads(t *testing.T) {
+	router, _ := newShareTestServer(t, &webConfig{})
+	url := createTestShare(t, router, `{"key": "hello.txt", "maxDownloads": 2}`)
+	tests := []struct {
+		byteRange string
+		status    int
+	}{
+		{"bytes=0-0", http.StatusPartialContent},
+		{"bytes=1-", http.StatusPartialContent},
+		{"bytes=1-", http.StatusGone},
+		{"", http.StatusGone},
+	}
+	for i, tt := range tests {
+		header := http.Header{}
+		if tt.byteRange != "" {
+			header.Set("Range", tt.byteRange)
+		}
+		if w := serveTestRequest(router, http.MethodGet, url, header); w.Code != tt.status {
+			t.Fatalf("download %d (%q) status = %d, want %d: %s", i+1, tt.byteRange, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
+
+func TestCreateShareAccess(t *testing.T) {
+	router, fake := newShareTestServer(t, &webConfig{ACL: []aclRule{{Pattern: "private/**", Users: []string{"alice"}}}})
+	fake.put("bucket/private/a.txt", testContent)
+	fake.put("bucket/_shares/x.json", "{}")
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"readable key", "hello.txt", http.StatusCreated},
+		{"key denied by the acl", "private/a.txt", http.StatusForbidden},
+		{"hidden key", "_shares/x.json", http.StatusBadRequest},
+		{"dot segment", "private/../hello.txt", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveTestBody(router, http.MethodPost, "/_admin/shares", bearer("secret"), `{"key": "`+tt.key+`"}`)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateShareOIDCAccess(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newShareTestServer(t, &webConfig{Auth: authConfig{OIDC: issuer.config(
+		oidcPermission{Prefix: "", Read: []string{"*"}},
+		oidcPermission{Prefix: "_admin/", Write: []string{"*"}},
+		oidcPermission{Prefix: "docs/secret/", Read: []string{"admin"}},
+	)}})
+	fake.put("bucket/docs/secret/a.txt", testContent)
+	w := serveTestBody(router, http.MethodPost, "/_admin/shares", bearer(issuer.token(t, "staff")), `{"key": "docs/secret/a.txt"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+	if w = serveTestBody(router, http.MethodPost, "/_admin/shares", bearer(issuer.token(t, "admin")), `{"key": "docs/secret/a.txt"}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func TestShareFailedDownload(t *testing.T) {
+	router, fake := newShareTestServer(t, &webConfig{})
+	fake.put("bucket/report.txt", testContent)
+	url := createTestShare(t, router, `{"key": "report.txt", "maxDownloads": 1}`)
+	fake.mu.Lock()
+	delete(fake.objects, "bucket/report.txt")
+	fake.mu.Unlock()
+	if w := serveTestRequest(router, http.MethodGet, url, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("status of a missing object = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body.String())
+	}
+	fake.put("bucket/report.txt", testContent)
+	if w := serveTestRequest(router, http.MethodGet, url, nil); w.Code != http.StatusOK || w.Body.String() != testContent {
+		t.Fatalf("status = %d, want %d with the object: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w := serveTestRequest(router, http.MethodGet, url, nil); w.Code != http.StatusGone {
+		t.Fatalf("status after the limit = %d, want %d: %s", w.Code, http.StatusGone, w.Body.String())
+	}
+}
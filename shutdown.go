@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Graceful shutdown config type
+type shutdownConfig struct {
+	// Delay during which /readyz fails before the server stops accepting requests, so that the
+	// load balancers stop sending new ones
+	Delay duration `json:"delay" yaml:"delay" toml:"delay"`
+	// Maximum delay waiting for the in-flight requests to complete, they are then interrupted
+	Timeout duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// Set the shutdown defaults
+func (cfg *shutdownConfig) validate() error {
+	cfg.Timeout.Duration = cfg.Timeout.orDefault(30 * time.Second)
+	return nil
+}
+
+// Requests being served and draining state of the server
+type drainState struct {
+	inflight int64
+	draining int32
+}
+
+// Drain state of the server
+var drain = &drainState{}
+
+// Count the in-flight requests
+func inflightMiddleware(c *gin.Context) {
+	atomic.AddInt64(&drain.inflight, 1)
+	defer atomic.AddInt64(&drain.inflight, -1)
+	c.Next()
+}
+
+// Number of requests being served
+func (d *drainState) active() int64 {
+	return atomic.LoadInt64(&d.inflight)
+}
+
+// Check if the server is shutting down
+func (d *drainState) isDraining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+// Shut the server down: fail the readiness probe during the delay, stop accepting the requests,
+// then wait for the in-flight ones up to the timeout before closing their connections.
+// The HTTP/3 server, if any, is shut down within the same timeout.
+func shutdownServer(srv *http.Server, quicServer *http3.Server, cfg shutdownConfig) {
+	atomic.StoreInt32(&drain.draining, 1)
+	if cfg.Delay.Duration > 0 {
+		log.Infof("Draining, waiting %s before refusing the requests", cfg.Delay.Duration)
+		srv.SetKeepAlivesEnabled(false)
+		time.Sleep(cfg.Delay.Duration)
+	}
+	log.Infof("Waiting up to %s for %d in-flight requests", cfg.Timeout.Duration, drain.active())
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout.Duration)
+	defer cancel()
+	if quicServer != nil {
+		// GOAWAY to the QUIC clients, their idle connections are closed below
+		go quicServer.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnf("Shutdown timeout, interrupting %d in-flight requests", drain.active())
+		srv.Close()
+	}
+	if quicServer != nil {
+		for drain.active() > 0 && ctx.Err() == nil {
+			time.Sleep(100 * time.Millisecond)
+		}
+		quicServer.Close()
+	}
+}
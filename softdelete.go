@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Delay during which the versioning status of a bucket is reused
+const versioningCacheDuration = time.Minute
+
+// Versioning status of a bucket
+type versioningStatus struct {
+	enabled bool
+	checked time.Time
+}
+
+// Versioning status of the buckets by name
+var (
+	versioningMu       sync.Mutex
+	versioningStatuses = map[string]versioningStatus{}
+)
+
+// Check if the versioning of a bucket is enabled, a suspended versioning deletes the objects permanently
+func versioningEnabled(ctx context.Context, bucket string) (bool, error) {
+	versioningMu.Lock()
+	status, ok := versioningStatuses[bucket]
+	versioningMu.Unlock()
+	if ok && time.Since(status.checked) < versioningCacheDuration {
+		return status.enabled, nil
+	}
+	resp, err := s3Session.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return false, err
+	}
+	status = versioningStatus{enabled: aws.StringValue(resp.Status) == s3.BucketVersioningStatusEnabled, checked: time.Now()}
+	versioningMu.Lock()
+	versioningStatuses[bucket] = status
+	versioningMu.Unlock()
+	return status.enabled, nil
+}
+
+// Check that the buckets are versioned, else the soft delete mode refuses the deletes
+func preflightSoftDelete(ctx context.Context, config *webConfig) []error {
+	var problems []error
+	for _, bucket := range configuredBuckets(config) {
+		enabled, err := versioningEnabled(ctx, bucket)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("bucket %s: unable to check the versioning for the soft delete mode (%v): allow s3:GetBucketVersioning", bucket, err))
+		} else if !enabled {
+			problems = append(problems, fmt.Errorf("bucket %s: versioning is not enabled, the deletes are refused in soft delete mode", bucket))
+		}
+	}
+	return problems
+}
+
+// Check that a DELETE only adds a delete marker in soft delete mode.
+// Returns false if the delete is refused and the response has been written.
+func checkSoftDelete(c *gin.Context, bucket, key string) bool {
+	if !configOf(c).SoftDelete {
+		return true
+	}
+	if requestedVersion(c) != nil {
+		writeError(c, http.StatusForbidden, "SoftDelete", "Versions cannot be deleted in soft delete mode", "")
+		return false
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	defer cancel()
+	enabled, err := versioningEnabled(ctx, bucket)
+	if err != nil {
+		handleHTTPException(c, key, err)
+		return false
+	}
+	if !enabled {
+		writeError(c, http.StatusConflict, "VersioningDisabled", "Deletes need the bucket versioning in soft delete mode", "")
+		return false
+	}
+	return true
+}
+
+// Restore request body type, without versionId the object is undeleted
+type restoreRequest struct {
+	Key       string `json:"key" binding:"required"`
+	VersionID string `json:"versionId"`
+}
+
+// Restore response body type
+type restoreResponse struct {
+	Key       string `json:"key"`
+	VersionID string `json:"versionId"`
+}
+
+// Restore an object: remove its delete marker so that its previous version is current again,
+// or make a copy of one of its versions the current version
+func serveRestore(c *gin.Context) {
+	var req restoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid restore request: "+err.Error(), "")
+		return
+	}
+	key := strings.TrimPrefix(req.Key, "/")
+	if configOf(c).isHiddenKey(key) {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Invalid restore request", "")
+		return
+	}
+	bucket, objectKey := configOf(c).resolveObject(c.Request.Host, key)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	var versionID string
+	var err error
+	if req.VersionID != "" {
+		versionID, err = copyVersion(ctx, bucket, objectKey, req.VersionID)
+	} else {
+		versionID, err = removeDeleteMarker(ctx, bucket, objectKey)
+	}
+	if err == errNotDeleted {
+		writeError(c, http.StatusConflict, "NotDeleted", "Path '"+key+"' is not deleted", "")
+		return
+	}
+	if handleHTTPException(c, key, err) != nil {
+		return
+	}
+	caches.invalidate(bucket, objectKey)
+	requestLog(c).Infof("Restored %s to version %s", key, versionID)
+	c.JSON(http.StatusOK, restoreResponse{Key: key, VersionID: versionID})
+}
+
+// Error of a restore of an object whose current version is not a delete marker
+var errNotDeleted = errors.New("object is not deleted")
+
+// Copy a version of an object as its current version, returns the new version
+func copyVersion(ctx context.Context, bucket, key, versionID string) (string, error) {
+	source := copySource(bucket, key) + "?versionId=" + url.QueryEscape(versionID)
+	input := &s3.CopyObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), CopySource: aws.String(source)}
+	configFrom(ctx).applyServerSideEncryption(nil, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+	resp, err := s3Session.CopyObjectWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.VersionId), nil
+}
+
+// Remove the delete marker of a deleted object, returns the version it restores
+func removeDeleteMarker(ctx context.Context, bucket, key string) (string, error) {
+	resp, err := s3Session.ListObjectVersionsWithContext(ctx, &s3.ListObjectVersionsInput{Bucket: aws.String(bucket), Prefix: aws.String(key)})
+	if err != nil {
+		return "", err
+	}
+	var marker *s3.DeleteMarkerEntry
+	for _, m := range resp.DeleteMarkers {
+		if aws.StringValue(m.Key) == key && aws.BoolValue(m.IsLatest) {
+			marker = m
+		}
+	}
+	var previous *s3.ObjectVersion
+	for _, v := range resp.Versions {
+		if aws.StringValue(v.Key) != key {
+			continue
+		}
+		if aws.BoolValue(v.IsLatest) {
+			return "", errNotDeleted
+		}
+		if previous == nil || aws.TimeValue(v.LastModified).After(aws.TimeValue(previous.LastModified)) {
+			previous = v
+		}
+	}
+	if previous == nil {
+		return "", awserr.New("NotFound", "no version of "+key, nil)
+	}
+	if marker == nil {
+		return "", errNotDeleted
+	}
+	_, err = s3Session.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), VersionId: marker.VersionId})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(previous.VersionId), nil
+}
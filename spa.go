@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context key set once a request has fallen back to the SPA index page
+const ctxSPAFallback = "spaFallback"
+
+// Get the key (without leading /) of the index page of the single-page application
+func (config *webConfig) spaIndex() string {
+	if index := config.SPAIndex; index != "" {
+		return index
+	}
+	if config.Homepage != "" {
+		return config.Homepage
+	}
+	return "index.html"
+}
+
+// Serve the index page of the single-page application instead of a missing object, for the paths
+// without file extension handled by the client-side router. Returns true if the index page is served.
+func serveSPAIndex(c *gin.Context, serve func(c *gin.Context, bucket, key string)) bool {
+	requested := c.GetString(ctxOriginalPath)
+	if !configOf(c).SPAMode || c.GetBool(ctxSPAFallback) || path.Ext(path.Base(requested)) != "" {
+		return false
+	}
+	c.Set(ctxSPAFallback, true)
+	index := configOf(c).spaIndex()
+	c.Request.URL.Path = "/" + index
+	bucket, key := configOf(c).resolveObject(c.Request.Host, index)
+	serve(c, bucket, key)
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Server-side encryption config type, applied by S3 to the objects stored by the server
+type sseConfig struct {
+	// AES256 (SSE-S3) or aws:kms (SSE-KMS), default is the encryption of the bucket
+	Algorithm string `json:"algorithm" yaml:"algorithm" toml:"algorithm"`
+	// KMS key id or ARN of SSE-KMS, default is the aws/s3 managed key
+	KMSKeyID string `json:"kmsKeyId" yaml:"kmsKeyId" toml:"kmsKeyId"`
+	// Use a S3 bucket key, to reduce the KMS requests
+	BucketKey bool `json:"bucketKey" yaml:"bucketKey" toml:"bucketKey"`
+}
+
+// Check the server-side encryption settings
+func (cfg sseConfig) validate() error {
+	switch cfg.Algorithm {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("Unknown serverSideEncryption algorithm %s (support only %s or %s)", cfg.Algorithm, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if (cfg.KMSKeyID != "" || cfg.BucketKey) && cfg.Algorithm != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("serverSideEncryption kmsKeyId and bucketKey need the %s algorithm", s3.ServerSideEncryptionAwsKms)
+	}
+	return nil
+}
+
+// Set the configured server-side encryption on the fields of a S3 write input.
+// Objects encrypted with a customer-provided key (SSE-C) cannot use another encryption.
+func (config *webConfig) applyServerSideEncryption(sseCustomerAlgorithm *string, algorithm, kmsKeyID **string, bucketKey **bool) {
+	cfg := config.ServerSideEncryption
+	if cfg.Algorithm == "" || sseCustomerAlgorithm != nil {
+		return
+	}
+	*algorithm = aws.String(cfg.Algorithm)
+	if cfg.KMSKeyID != "" {
+		*kmsKeyID = aws.String(cfg.KMSKeyID)
+	}
+	if cfg.BucketKey {
+		*bucketKey = aws.Bool(true)
+	}
+}
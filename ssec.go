@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/gin-gonic/gin"
+)
+
+// SSE-C (customer-provided key) headers, forwarded to S3
+const (
+	sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+// Copy the SSE-C headers of the request to the fields of a S3 input.
+// The SDK expects the raw key and encodes it again, so the base64 header value is decoded.
+func applySSECustomer(c *gin.Context, algorithm, key, keyMD5 **string) error {
+	encoded := c.GetHeader(sseCustomerKeyHeader)
+	if encoded == "" && c.GetHeader(sseCustomerAlgorithmHeader) == "" {
+		return nil
+	}
+	if encoded == "" || c.GetHeader(sseCustomerAlgorithmHeader) == "" {
+		return fmt.Errorf("both %s and %s headers are required", sseCustomerAlgorithmHeader, sseCustomerKeyHeader)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%s header is not valid base64", sseCustomerKeyHeader)
+	}
+	*algorithm = aws.String(c.GetHeader(sseCustomerAlgorithmHeader))
+	*key = aws.String(string(raw))
+	if md5 := c.GetHeader(sseCustomerKeyMD5Header); md5 != "" {
+		*keyMD5 = aws.String(md5)
+	}
+	return nil
+}
+
+// Reject a request with invalid SSE-C headers
+func writeSSECustomerError(c *gin.Context, err error) {
+	writeError(c, http.StatusBadRequest, "InvalidArgument", err.Error(), "")
+}
+
+// Echo the SSE-C algorithm and key MD5 returned by S3
+func setSSECustomerHeaders(header http.Header, algorithm, keyMD5 *string) {
+	if algorithm != nil {
+		header.Set(sseCustomerAlgorithmHeader, *algorithm)
+	}
+	if keyMD5 != nil {
+		header.Set(sseCustomerKeyMD5Header, *keyMD5)
+	}
+}
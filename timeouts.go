@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 operation timeouts config type, a zero value means no deadline
+type timeoutsConfig struct {
+	Head   duration `json:"head" yaml:"head" toml:"head"`
+	Get    duration `json:"get" yaml:"get" toml:"get"`
+	Put    duration `json:"put" yaml:"put" toml:"put"`
+	Delete duration `json:"delete" yaml:"delete" toml:"delete"`
+	List   duration `json:"list" yaml:"list" toml:"list"`
+	// Connection to the S3 endpoint, SDK defaults when zero
+	Connect      duration `json:"connect" yaml:"connect" toml:"connect"`
+	TLSHandshake duration `json:"tlsHandshake" yaml:"tlsHandshake" toml:"tlsHandshake"`
+	// Delay until the response headers once the request is sent, the body is bounded by the operation deadline
+	ResponseHeader duration `json:"responseHeader" yaml:"responseHeader" toml:"responseHeader"`
+	// Delay before an idle connection to S3 is closed
+	IdleConnection duration `json:"idleConnection" yaml:"idleConnection" toml:"idleConnection"`
+	// Number of idle connections kept to S3, default 100
+	MaxIdleConnections int `json:"maxIdleConnections" yaml:"maxIdleConnections" toml:"maxIdleConnections"`
+}
+
+// Create the HTTP client of the S3 calls from the transport of the session (holding its CA bundle, if any)
+func newS3HTTPClient(cfg timeoutsConfig, base *http.Client) *http.Client {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if base != nil {
+		if t, isTransport := base.Transport.(*http.Transport); isTransport {
+			transport, ok = t, true
+		}
+	}
+	if !ok {
+		return base
+	}
+	transport = transport.Clone()
+	dialer := &net.Dialer{Timeout: cfg.Connect.orDefault(30 * time.Second), KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = cfg.TLSHandshake.orDefault(10 * time.Second)
+	transport.ResponseHeaderTimeout = cfg.ResponseHeader.Duration
+	transport.IdleConnTimeout = cfg.IdleConnection.orDefault(90 * time.Second)
+	// A proxy calls a single host, the default of 2 idle connections per host reconnects all the time
+	transport.MaxIdleConns = cfg.MaxIdleConnections
+	if transport.MaxIdleConns <= 0 {
+		transport.MaxIdleConns = 100
+	}
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+	return &http.Client{Transport: transport}
+}
+
+// Get a context for a S3 call with the operation deadline, if any
+func s3Context(parent context.Context, timeout duration) (context.Context, context.CancelFunc) {
+	if timeout.Duration <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout.Duration)
+}
+
+// List objects page by page, each ListObjectsV2 call having its own deadline.
+// fn is called for each page and returns false to stop the listing.
+func listObjectsPages(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output) bool) error {
+	for {
+		pageCtx, cancel := s3Context(ctx, configFrom(ctx).Timeouts.List)
+		page, err := s3Session.ListObjectsV2WithContext(pageCtx, input)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if !fn(page) || !aws.BoolValue(page.IsTruncated) || page.NextContinuationToken == nil {
+			return nil
+		}
+		next := *input
+		next.ContinuationToken = page.NextContinuationToken
+		input = &next
+	}
+}
+
+// Default deadline of the S3 calls done in background
+const backgroundTimeout = 30 * time.Second
+
+// Check if a S3 call failed because its deadline or a timeout of the connection was exceeded
+func isDeadlineExceeded(err error) bool {
+	awsError, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if awsError.Code() == request.CanceledErrorCode {
+		return awsError.OrigErr() == context.DeadlineExceeded
+	}
+	netError, ok := awsError.OrigErr().(net.Error)
+	return ok && netError.Timeout()
+}
+
+// Check if a S3 call was canceled by the client closing its connection
+func isCanceled(err error) bool {
+	awsError, ok := err.(awserr.Error)
+	return ok && awsError.Code() == request.CanceledErrorCode && awsError.OrigErr() == context.Canceled
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Default directory of the Let's Encrypt certificates
+const defaultAutocertCacheDir = "certs"
+
+// TLS config type
+type tlsConfig struct {
+	// Certificate and private key files (PEM)
+	CertFile string `json:"certFile" yaml:"certFile" toml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile" toml:"keyFile"`
+	// Certificates obtained from Let's Encrypt
+	Autocert autocertConfig `json:"autocert" yaml:"autocert" toml:"autocert"`
+}
+
+// Let's Encrypt config type
+type autocertConfig struct {
+	// Domains the certificates are requested for, enables autocert
+	Domains []string `json:"domains" yaml:"domains" toml:"domains"`
+	// Directory storing the certificates between restarts
+	CacheDir string `json:"cacheDir" yaml:"cacheDir" toml:"cacheDir"`
+	// Contact email of the Let's Encrypt account
+	Email string `json:"email" yaml:"email" toml:"email"`
+	// Port answering the HTTP-01 challenges and redirecting to HTTPS (e.g. "80"), TLS-ALPN-01 only if not set
+	HTTPPort string `json:"httpPort" yaml:"httpPort" toml:"httpPort"`
+}
+
+// Check if the server terminates TLS
+func (cfg tlsConfig) enabled() bool {
+	return cfg.CertFile != "" || len(cfg.Autocert.Domains) > 0
+}
+
+// Set the TLS defaults and check the values
+func (cfg *tlsConfig) validate() error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("tls needs both certFile and keyFile")
+	}
+	if cfg.CertFile != "" && len(cfg.Autocert.Domains) > 0 {
+		return fmt.Errorf("tls certFile and autocert domains cannot be used together")
+	}
+	if cfg.Autocert.CacheDir == "" {
+		cfg.Autocert.CacheDir = defaultAutocertCacheDir
+	}
+	return nil
+}
+
+// Get the TLS config of the server with the configured certificates, and start answering the HTTP-01
+// challenges if enabled
+func serverTLSConfig(cfg tlsConfig) (*tls.Config, error) {
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Autocert.Domains...),
+		Cache:      autocert.DirCache(cfg.Autocert.CacheDir),
+		Email:      cfg.Autocert.Email,
+	}
+	if cfg.Autocert.HTTPPort != "" {
+		go func() {
+			if err := http.ListenAndServe(":"+cfg.Autocert.HTTPPort, manager.HTTPHandler(nil)); err != nil {
+				log.Errorf("HTTP challenge listener stopped: %v", err)
+			}
+		}()
+	}
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	log.Infof("Serving certificates from Let's Encrypt for %v", cfg.Autocert.Domains)
+	return tlsConfig, nil
+}
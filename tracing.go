@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer of the server spans
+const tracerName = "s3webserver"
+
+// OpenTelemetry tracing config type, the spans are exported with OTLP over HTTP
+type tracingConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// host:port of the OTLP collector, default is OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318
+	Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+	// Export over plain HTTP
+	Insecure bool `json:"insecure" yaml:"insecure" toml:"insecure"`
+	// Headers of the export requests, e.g. the API key of a hosted collector
+	Headers map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+	// Ratio of the traces sampled, the sampling decision of the caller is honored
+	SampleRatio float64 `json:"sampleRatio" yaml:"sampleRatio" toml:"sampleRatio"`
+	ServiceName string  `json:"serviceName" yaml:"serviceName" toml:"serviceName"`
+}
+
+// Set the tracing defaults and check the sample ratio
+func (cfg *tracingConfig) validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sampleRatio %v, must be between 0 and 1", cfg.SampleRatio)
+	}
+	if cfg.SampleRatio == 0 {
+		cfg.SampleRatio = 1
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "s3webserver"
+	}
+	return nil
+}
+
+// Set up the OTLP exporter and the tracer provider, returns the function flushing the spans on shutdown
+func setupTracing(cfg tracingConfig) (func(context.Context) error, error) {
+	var opts []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+			semconv.ServiceVersionKey.String(Tag))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start a server span for each request, continuing the trace of the caller
+func tracingMiddleware(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	name := c.Request.Method + " " + route
+	if route == "" {
+		name = c.Request.Method
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, c.Request)...),
+		trace.WithAttributes(attribute.String("http.request_id", c.GetString(ctxRequestID))))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	if code := c.GetString(ctxErrorCode); code != "" {
+		span.SetAttributes(attribute.String("error.code", code))
+	}
+}
+
+// Context key of the span of a S3 call, started by its first attempt
+type s3SpanKey struct{}
+
+// Add the handlers tracing the S3 calls as client spans of their request, the retries are span events
+func registerS3Tracing(svc *s3.S3) {
+	svc.Handlers.Send.PushFront(func(r *request.Request) {
+		if span, ok := r.Context().Value(s3SpanKey{}).(trace.Span); ok {
+			span.AddEvent("retry", trace.WithAttributes(attribute.Int("aws.retry_count", r.RetryCount)))
+			return
+		}
+		ctx, span := otel.Tracer(tracerName).Start(r.Context(), "S3."+r.Operation.Name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.RPCSystemKey.String("aws-api"),
+				semconv.RPCServiceKey.String("S3"),
+				semconv.RPCMethodKey.String(r.Operation.Name),
+				semconv.HTTPMethodKey.String(r.HTTPRequest.Method),
+				semconv.HTTPURLKey.String(r.HTTPRequest.URL.String())))
+		r.SetContext(context.WithValue(ctx, s3SpanKey{}, span))
+	})
+	svc.Handlers.Complete.PushBack(func(r *request.Request) {
+		span, ok := r.Context().Value(s3SpanKey{}).(trace.Span)
+		if !ok {
+			return
+		}
+		defer span.End()
+		span.SetAttributes(attribute.String("aws.request_id", r.RequestID), attribute.Int("aws.retry_count", r.RetryCount))
+		if r.HTTPResponse != nil {
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(r.HTTPResponse.StatusCode))
+		}
+		if r.Error != nil && !isCanceled(r.Error) && (r.HTTPResponse == nil || r.HTTPResponse.StatusCode >= http.StatusInternalServerError) {
+			span.RecordError(r.Error)
+			span.SetStatus(codes.Error, errorCode(r.Error))
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TTL rule config type, applied to the keys starting with Prefix
+type ttlRule struct {
+	Prefix string   `json:"prefix" yaml:"prefix" toml:"prefix"`
+	MaxAge duration `json:"maxAge" yaml:"maxAge" toml:"maxAge"`
+}
+
+// Find the TTL of a key, from the rule with the longest matching prefix
+func (config *webConfig) ttlFor(key string) (time.Duration, bool) {
+	var match *ttlRule
+	for i, rule := range config.TTL {
+		if strings.HasPrefix(key, rule.Prefix) && (match == nil || len(rule.Prefix) > len(match.Prefix)) {
+			match = &config.TTL[i]
+		}
+	}
+	if match == nil {
+		return 0, false
+	}
+	return match.MaxAge.Duration, true
+}
+
+// Set the Expires and Cache-Control headers computed from the TTL rules
+func (config *webConfig) setExpiryHeaders(header http.Header, key string) {
+	ttl, ok := config.ttlFor(key)
+	if !ok {
+		return
+	}
+	header.Set("Expires", httpDate(time.Now().Add(ttl)))
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", fmt.Sprintf("max-age=%d", int64(ttl.Seconds())))
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+)
+
+// Version of the tus protocol (https://tus.io) supported by the server
+const tusVersion = "1.0.0"
+
+// Path of the tus endpoint
+const tusPath = "/_api/tus/"
+
+// Resumable uploads config type
+type tusConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Key prefix of the upload records and of their incomplete parts in the bucket, never served directly
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+}
+
+// Get the key prefix of the upload records
+func (cfg tusConfig) prefix() string {
+	if cfg.Prefix == "" {
+		return "_tus/"
+	}
+	return cfg.Prefix
+}
+
+// Check if a key is an upload record, which must not be served as an object
+func (cfg tusConfig) hides(key string) bool {
+	return cfg.Enabled && strings.HasPrefix(key, cfg.prefix())
+}
+
+// Uploaded part of a resumable upload
+type tusPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// Resumable upload record type, stored as a JSON object in the bucket. The bytes received which do
+// not fill a part yet are stored in a .part object next to the record, until the next PATCH.
+type tusUpload struct {
+	Path        string    `json:"path"`
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	UploadID    string    `json:"uploadId"`
+	Length      int64     `json:"length"`
+	PartSize    int64     `json:"partSize"`
+	Parts       []tusPart `json:"parts"`
+	Tail        int64     `json:"tail"`
+	ContentType string    `json:"contentType,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// Get the number of bytes received by an upload
+func (u *tusUpload) offset() int64 {
+	offset := u.Tail
+	for _, part := range u.Parts {
+		offset += part.Size
+	}
+	return offset
+}
+
+// Get the object keys of the record and of the incomplete part of an upload
+func (config *webConfig) tusRecordKey(id string) string {
+	return config.Tus.prefix() + id + ".json"
+}
+
+func (config *webConfig) tusTailKey(id string) string {
+	return config.Tus.prefix() + id + ".part"
+}
+
+// Ids of the uploads receiving a PATCH, a concurrent PATCH of the same upload is refused
+var (
+	tusBusyMu sync.Mutex
+	tusBusy   = map[string]bool{}
+)
+
+// Mark an upload as busy, returns false if it already is
+func lockTusUpload(id string) bool {
+	tusBusyMu.Lock()
+	defer tusBusyMu.Unlock()
+	if tusBusy[id] {
+		return false
+	}
+	tusBusy[id] = true
+	return true
+}
+
+func unlockTusUpload(id string) {
+	tusBusyMu.Lock()
+	delete(tusBusy, id)
+	tusBusyMu.Unlock()
+}
+
+// Load an upload record, nil if the id is unknown
+func loadTusUpload(c *gin.Context, id string) (*tusUpload, error) {
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Get)
+	defer cancel()
+	resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(configOf(c).S3bucket),
+		Key:    aws.String(configOf(c).tusRecordKey(id)),
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	upload := &tusUpload{}
+	return upload, json.Unmarshal(data, upload)
+}
+
+// Save an upload record
+func saveTusUpload(ctx context.Context, id string, upload *tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s3Context(ctx, configFrom(ctx).Timeouts.Put)
+	defer cancel()
+	_, err = s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(configFrom(ctx).S3bucket),
+		Key:         aws.String(configFrom(ctx).tusRecordKey(id)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Delete the record and the incomplete part of an upload
+func deleteTusUpload(c *gin.Context, id string) error {
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Delete)
+	defer cancel()
+	_, err := s3Session.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(configOf(c).S3bucket),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String(configOf(c).tusRecordKey(id))}, {Key: aws.String(configOf(c).tusTailKey(id))}}},
+	})
+	return err
+}
+
+// Parse the Upload-Metadata header: comma separated keys, each followed by its base64 value
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			if b, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+				value = string(b)
+			}
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
+
+// Wrap a tus handler with the check of the protocol version of the requests
+func tusHandler(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			writeError(c, http.StatusPreconditionFailed, "UnsupportedVersion", "Tus-Resumable "+tusVersion+" is required", "")
+			return
+		}
+		handler(c)
+	}
+}
+
+// Describe the tus support of the server
+func serveTusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination")
+	if max := configOf(c).MaxUploadSize; max > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Create a resumable upload. The object path is given by the key metadata, or else by the filename metadata.
+func serveTusCreate(c *gin.Context) {
+	config := configOf(c)
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Upload-Length is required", "")
+		return
+	}
+	if max := config.MaxUploadSize; max > 0 && length > max {
+		writeUploadTooLarge(c)
+		return
+	}
+	metadata := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	objectPath := metadata["key"]
+	if objectPath == "" {
+		objectPath = metadata["filename"]
+	}
+	objectPath = config.normalizeUploadKey(strings.TrimPrefix(objectPath, "/"))
+	if objectPath == "" || strings.HasSuffix(objectPath, "/") || config.isHiddenKey(objectPath) {
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Upload-Metadata needs a key or a filename", "")
+		return
+	}
+	if !methodAllowed(http.MethodPut) {
+		writeError(c, http.StatusForbidden, "AccessDenied", "Method PUT not allowed", "")
+		return
+	}
+	if !checkKeyAccess(c, http.MethodPut, objectPath) {
+		return
+	}
+	bucket, key := config.resolveObject(c.Request.Host, objectPath)
+	if config.encryptionFor(key) != nil {
+		// The encryption needs the whole content at once
+		writeError(c, http.StatusBadRequest, "InvalidRequest", "Objects under an encryption prefix cannot be uploaded with tus", "")
+		return
+	}
+	id, err := newShareToken()
+	if err != nil {
+		writeInternalError(c, "InternalError", "Failed to generate an upload id: "+err.Error(), "")
+		return
+	}
+
+	contentType := metadata["contentType"]
+	if contentType == "" {
+		contentType = metadata["filetype"]
+	}
+	if contentType == "" {
+		contentType = config.objectContentType(objectPath, nil)
+	}
+	// The parts grow for the uploads too large for the 10000 parts limit
+	partSize := config.Upload.PartSize
+	if n := (length + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; n > partSize {
+		partSize = n
+	}
+	input := &s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(key), ContentType: aws.String(contentType)}
+	config.applyServerSideEncryption(nil, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+	ctx, cancel := s3Context(c.Request.Context(), config.Timeouts.Put)
+	defer cancel()
+	resp, err := s3Session.CreateMultipartUploadWithContext(ctx, input)
+	if handleHTTPException(c, objectPath, err) != nil {
+		return
+	}
+	upload := &tusUpload{
+		Path:        objectPath,
+		Bucket:      bucket,
+		Key:         key,
+		UploadID:    aws.StringValue(resp.UploadId),
+		Length:      length,
+		PartSize:    partSize,
+		Parts:       []tusPart{},
+		ContentType: contentType,
+		Created:     time.Now().UTC(),
+	}
+	if err := saveTusUpload(c.Request.Context(), id, upload); err != nil {
+		handleHTTPException(c, objectPath, err)
+		return
+	}
+	if length == 0 && !completeTusUpload(c, id, upload) {
+		return
+	}
+	requestLog(c).Infof("Resumable upload %s created for %s, %d bytes", id, objectPath, length)
+	c.Header("Location", tusPath+id)
+	c.Status(http.StatusCreated)
+}
+
+// Load the upload of the id of a request.
+// Returns nil if the upload is unknown or failed to load and the response has been written.
+func requestedTusUpload(c *gin.Context) *tusUpload {
+	upload, err := loadTusUpload(c, c.Param("id"))
+	if err != nil {
+		handleHTTPException(c, c.Param("id"), err)
+		return nil
+	}
+	if upload == nil {
+		writeError(c, http.StatusNotFound, "NotFound", "Upload not found", "")
+	}
+	return upload
+}
+
+// Give the offset of an upload, for the client to resume it
+func serveTusHead(c *gin.Context) {
+	upload := requestedTusUpload(c)
+	if upload == nil {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.offset(), 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+// Receive the bytes of an upload from its current offset. The full parts are uploaded to S3 as they
+// fill up and the rest is stored until the next PATCH, so an interrupted PATCH keeps what it received.
+func serveTusPatch(c *gin.Context) {
+	id := c.Param("id")
+	if c.ContentType() != "application/offset+octet-stream" {
+		writeError(c, http.StatusUnsupportedMediaType, "InvalidRequest", "Content-Type must be application/offset+octet-stream", "")
+		return
+	}
+	if !lockTusUpload(id) {
+		writeError(c, http.StatusLocked, "UploadLocked", "Upload is receiving another PATCH", "")
+		return
+	}
+	defer unlockTusUpload(id)
+	upload := requestedTusUpload(c)
+	if upload == nil {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.offset() {
+		writeError(c, http.StatusConflict, "OffsetMismatch", "Upload-Offset must be "+strconv.FormatInt(upload.offset(), 10), "")
+		return
+	}
+
+	// The bytes received are kept when the client is gone, to be resumed
+	ctx, cancel := s3Context(context.Background(), configOf(c).Timeouts.Put)
+	defer cancel()
+	body := &countingReader{Reader: io.LimitReader(c.Request.Body, upload.Length-offset)}
+	var data io.Reader = body
+	if upload.Tail > 0 {
+		resp, err := s3Session.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(configOf(c).S3bucket), Key: aws.String(configOf(c).tusTailKey(id))})
+		if handleHTTPException(c, id, err) != nil {
+			return
+		}
+		defer resp.Body.Close()
+		data = io.MultiReader(io.LimitReader(resp.Body, upload.Tail), body)
+	}
+	buf := make([]byte, upload.PartSize)
+	var uploadErr error
+	for {
+		n, readErr := io.ReadFull(data, buf)
+		uploaded := upload.offset() - upload.Tail
+		if n == len(buf) || (n > 0 && uploaded+int64(n) == upload.Length) {
+			number := int64(len(upload.Parts) + 1)
+			resp, err := s3Session.UploadPartWithContext(ctx, &s3.UploadPartInput{
+				Bucket:     aws.String(upload.Bucket),
+				Key:        aws.String(upload.Key),
+				UploadId:   aws.String(upload.UploadID),
+				PartNumber: aws.Int64(number),
+				Body:       bytes.NewReader(buf[:n]),
+			})
+			if err != nil {
+				uploadErr = err
+				break
+			}
+			upload.Parts = append(upload.Parts, tusPart{Number: number, ETag: aws.StringValue(resp.ETag), Size: int64(n)})
+			upload.Tail = 0
+		} else {
+			// Less than a part, kept for the next PATCH
+			if n > 0 {
+				_, err := s3Session.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: aws.String(configOf(c).S3bucket), Key: aws.String(configOf(c).tusTailKey(id)), Body: bytes.NewReader(buf[:n])})
+				if err != nil {
+					uploadErr = err
+					break
+				}
+			}
+			upload.Tail = int64(n)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	usage.addBytesIn(body.n)
+	requestLog(c).Debugf("Resumable upload %s received %d bytes, at offset %d", id, body.n, upload.offset())
+	if err := saveTusUpload(context.Background(), id, upload); err != nil && uploadErr == nil {
+		uploadErr = err
+	}
+	if handleHTTPException(c, upload.Path, uploadErr) != nil {
+		return
+	}
+	if upload.offset() == upload.Length && !completeTusUpload(c, id, upload) {
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.offset(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// Complete the multipart upload of an upload whose bytes were all received, and delete its record.
+// Returns false if the completion failed and the response has been written.
+func completeTusUpload(c *gin.Context, id string, upload *tusUpload) bool {
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Put)
+	defer cancel()
+	var err error
+	if len(upload.Parts) == 0 {
+		// An empty object has no part
+		s3Session.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(upload.Bucket), Key: aws.String(upload.Key), UploadId: aws.String(upload.UploadID)})
+		input := &s3.PutObjectInput{Bucket: aws.String(upload.Bucket), Key: aws.String(upload.Key), Body: bytes.NewReader(nil), ContentType: aws.String(upload.ContentType)}
+		configOf(c).applyServerSideEncryption(nil, &input.ServerSideEncryption, &input.SSEKMSKeyId, &input.BucketKeyEnabled)
+		_, err = s3Session.PutObjectWithContext(ctx, input)
+	} else {
+		parts := make([]*s3.CompletedPart, len(upload.Parts))
+		for i, part := range upload.Parts {
+			parts[i] = &s3.CompletedPart{ETag: aws.String(part.ETag), PartNumber: aws.Int64(part.Number)}
+		}
+		_, err = s3Session.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(upload.Bucket),
+			Key:             aws.String(upload.Key),
+			UploadId:        aws.String(upload.UploadID),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if handleHTTPException(c, upload.Path, err) != nil {
+		return false
+	}
+	caches.invalidate(upload.Bucket, upload.Key)
+	if err := deleteTusUpload(c, id); err != nil {
+		requestLog(c).Warnf("Unable to delete the record of the upload %s: %v", id, err)
+	}
+	requestLog(c).Infof("Resumable upload %s of %s completed", id, upload.Path)
+	return true
+}
+
+// Terminate an upload, the parts received are deleted
+func serveTusDelete(c *gin.Context) {
+	id := c.Param("id")
+	if !lockTusUpload(id) {
+		writeError(c, http.StatusLocked, "UploadLocked", "Upload is receiving a PATCH", "")
+		return
+	}
+	defer unlockTusUpload(id)
+	upload := requestedTusUpload(c)
+	if upload == nil {
+		return
+	}
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Delete)
+	defer cancel()
+	_, err := s3Session.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(upload.Bucket), Key: aws.String(upload.Key), UploadId: aws.String(upload.UploadID)})
+	if err != nil && errorCode(err) != "NoSuchUpload" {
+		handleHTTPException(c, upload.Path, err)
+		return
+	}
+	if err := deleteTusUpload(c, id); err != nil {
+		handleHTTPException(c, upload.Path, err)
+		return
+	}
+	requestLog(c).Infof("Resumable upload %s of %s terminated", id, upload.Path)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path prefix of the file browser UI
+const uiPrefix = "_ui/"
+
+// Static assets of the file browser UI
+//
+//go:embed ui
+var uiAssets embed.FS
+
+// File browser UI config type
+type uiConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Title   string `json:"title" yaml:"title" toml:"title"`
+	// Theme colors, any CSS color
+	PrimaryColor    string `json:"primaryColor" yaml:"primaryColor" toml:"primaryColor"`
+	BackgroundColor string `json:"backgroundColor" yaml:"backgroundColor" toml:"backgroundColor"`
+	TextColor       string `json:"textColor" yaml:"textColor" toml:"textColor"`
+	// URL of the logo shown in the header
+	Logo string `json:"logo" yaml:"logo" toml:"logo"`
+	// URL of an extra stylesheet loaded after the default one
+	CustomCSS string `json:"customCss" yaml:"customCss" toml:"customCss"`
+}
+
+// Settings of the UI given to the browser
+type uiSettings struct {
+	Title     string `json:"title"`
+	Logo      string `json:"logo,omitempty"`
+	CustomCSS string `json:"customCss,omitempty"`
+	Shares    bool   `json:"shares"`
+	// Actions served by allowedMethods
+	Upload bool `json:"upload"`
+	Rename bool `json:"rename"`
+	Delete bool `json:"delete"`
+}
+
+// Get a theme value, or its default
+func themeValue(value, def string) string {
+	if value == "" {
+		return def
+	}
+	// Keep the value inside its CSS declaration
+	return strings.NewReplacer(";", "", "}", "", "<", "").Replace(value)
+}
+
+// Serve the file browser UI
+func serveUI(c *gin.Context) {
+	cfg := configOf(c).UI
+	file := strings.TrimPrefix(c.Param("file"), "/")
+	switch file {
+	case "":
+		file = "index.html"
+	case "settings.json":
+		title := cfg.Title
+		if title == "" {
+			title = configOf(c).S3bucket
+		}
+		c.JSON(http.StatusOK, uiSettings{
+			Title:     title,
+			Logo:      cfg.Logo,
+			CustomCSS: cfg.CustomCSS,
+			Shares:    configOf(c).Shares.Enabled,
+			Upload:    methodAllowed(http.MethodPut),
+			Rename:    methodAllowed(http.MethodPut) && methodAllowed(http.MethodDelete),
+			Delete:    methodAllowed(http.MethodDelete),
+		})
+		return
+	case "theme.css":
+		c.Data(http.StatusOK, "text/css; charset=utf-8", []byte(fmt.Sprintf(":root {\n  --primary: %s;\n  --background: %s;\n  --text: %s;\n}\n",
+			themeValue(cfg.PrimaryColor, "#2f6fb3"), themeValue(cfg.BackgroundColor, "#ffffff"), themeValue(cfg.TextColor, "#222222"))))
+		return
+	}
+	data, err := fs.ReadFile(uiAssets, path.Join("ui", path.Clean("/"+file)))
+	if err != nil {
+		writeError(c, http.StatusNotFound, "NotFound", "Path '"+uiPrefix+file+"' not found", "")
+		return
+	}
+	contentType := "application/octet-stream"
+	switch path.Ext(file) {
+	case ".html":
+		contentType = "text/html; charset=utf-8"
+	case ".js":
+		contentType = "application/javascript"
+	case ".css":
+		contentType = "text/css; charset=utf-8"
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, contentType, data)
+}
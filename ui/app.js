@@ -0,0 +1,341 @@
+// File browser of the S3WebServer bucket
+(function () {
+  "use strict";
+
+  var settings = { shares: false };
+  var previewLimit = 1 << 20;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function el(tag, text) {
+    var e = document.createElement(tag);
+    if (text !== undefined) {
+      e.textContent = text;
+    }
+    return e;
+  }
+
+  // URL of an object key, each segment is escaped
+  function objectURL(key) {
+    return "/" + key.split("/").map(encodeURIComponent).join("/");
+  }
+
+  function currentPrefix() {
+    return decodeURIComponent(location.hash.replace(/^#\/?/, ""));
+  }
+
+  function baseName(key) {
+    var parts = key.replace(/\/$/, "").split("/");
+    return parts[parts.length - 1];
+  }
+
+  function formatSize(size) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var i = 0;
+    while (size >= 1024 && i < units.length - 1) {
+      size /= 1024;
+      i++;
+    }
+    return (i === 0 ? size : size.toFixed(1)) + " " + units[i];
+  }
+
+  function renderBreadcrumbs(prefix) {
+    var nav = $("breadcrumbs");
+    nav.textContent = "";
+    var root = el("a", settings.title || "/");
+    root.href = "#";
+    nav.appendChild(root);
+    var path = "";
+    prefix.split("/").filter(Boolean).forEach(function (part) {
+      path += part + "/";
+      nav.appendChild(document.createTextNode(" / "));
+      var a = el("a", part);
+      a.href = "#" + encodeURIComponent(path);
+      nav.appendChild(a);
+    });
+  }
+
+  function actionButton(label, handler) {
+    var b = el("button", label);
+    b.addEventListener("click", handler);
+    return b;
+  }
+
+  function renderEntries(listing) {
+    var body = $("entries");
+    body.textContent = "";
+    listing.prefixes.forEach(function (prefix) {
+      var tr = el("tr");
+      var name = el("td");
+      var a = el("a", baseName(prefix) + "/");
+      a.href = "#" + encodeURIComponent(prefix);
+      name.appendChild(a);
+      tr.appendChild(name);
+      tr.appendChild(el("td", ""));
+      tr.appendChild(el("td", ""));
+      var actions = el("td");
+      if (settings["delete"]) {
+        actions.appendChild(actionButton("Delete", function () {
+          remove(prefix);
+        }));
+      }
+      tr.appendChild(actions);
+      body.appendChild(tr);
+    });
+    listing.objects.forEach(function (obj) {
+      var tr = el("tr");
+      var name = el("td");
+      var a = el("a", baseName(obj.key));
+      a.href = objectURL(obj.key);
+      a.setAttribute("download", baseName(obj.key));
+      name.appendChild(a);
+      tr.appendChild(name);
+      tr.appendChild(el("td", formatSize(obj.size))).className = "size";
+      tr.appendChild(el("td", new Date(obj.lastModified).toLocaleString())).className = "date";
+      var actions = el("td");
+      actions.appendChild(actionButton("Preview", function () {
+        preview(obj);
+      }));
+      actions.appendChild(document.createTextNode(" "));
+      actions.appendChild(actionButton("Download", function () {
+        download(obj.key);
+      }));
+      if (settings.shares) {
+        actions.appendChild(document.createTextNode(" "));
+        actions.appendChild(actionButton("Share", function () {
+          share(obj.key);
+        }));
+      }
+      if (settings.rename) {
+        actions.appendChild(document.createTextNode(" "));
+        actions.appendChild(actionButton("Rename", function () {
+          rename(obj.key);
+        }));
+      }
+      if (settings["delete"]) {
+        actions.appendChild(document.createTextNode(" "));
+        actions.appendChild(actionButton("Delete", function () {
+          remove(obj.key);
+        }));
+      }
+      tr.appendChild(actions);
+      body.appendChild(tr);
+    });
+  }
+
+  // Fetch all the pages of a listing
+  function list(prefix, token, listing) {
+    var url = "/_api/list?prefix=" + encodeURIComponent(prefix);
+    if (token) {
+      url += "&continuationToken=" + encodeURIComponent(token);
+    }
+    return fetch(url)
+      .then(function (resp) {
+        if (!resp.ok) {
+          throw new Error("listing failed with status " + resp.status);
+        }
+        return resp.json();
+      })
+      .then(function (page) {
+        if (listing) {
+          page.prefixes = listing.prefixes.concat(page.prefixes);
+          page.objects = listing.objects.concat(page.objects);
+        }
+        return page.nextContinuationToken ? list(prefix, page.nextContinuationToken, page) : page;
+      });
+  }
+
+  function load() {
+    var prefix = currentPrefix();
+    renderBreadcrumbs(prefix);
+    list(prefix)
+      .then(renderEntries)
+      .catch(function (err) {
+        $("entries").textContent = "";
+        var tr = el("tr");
+        tr.appendChild(el("td", err.message));
+        $("entries").appendChild(tr);
+      });
+  }
+
+  function preview(obj) {
+    var body = $("preview-body");
+    body.textContent = "";
+    $("preview-name").textContent = baseName(obj.key);
+    var url = objectURL(obj.key);
+    var ext = obj.key.split(".").pop().toLowerCase();
+    var e;
+    if (["png", "jpg", "jpeg", "gif", "svg", "webp"].indexOf(ext) >= 0) {
+      e = el("img");
+      e.src = url;
+    } else if (["mp4", "webm", "ogg", "mp3", "wav"].indexOf(ext) >= 0) {
+      e = el("video");
+      e.src = url;
+      e.controls = true;
+    } else if (ext === "pdf") {
+      e = el("iframe");
+      e.src = url;
+    } else if (obj.size <= previewLimit) {
+      e = el("pre", "Loading...");
+      fetch(url).then(function (resp) {
+        return resp.text();
+      }).then(function (text) {
+        e.textContent = text;
+      });
+    } else {
+      e = el("p", "No preview for this file.");
+    }
+    body.appendChild(e);
+    $("preview").hidden = false;
+  }
+
+  function share(key) {
+    fetch("/_admin/shares", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ key: key })
+    }).then(function (resp) {
+      return resp.json().then(function (data) {
+        if (!resp.ok) {
+          throw new Error(data.message || "share failed");
+        }
+        return data;
+      });
+    }).then(function (data) {
+      window.prompt("Share link, valid until " + new Date(data.expires).toLocaleString(), location.origin + data.url);
+    }).catch(function (err) {
+      window.alert(err.message);
+    });
+  }
+
+  // Error message of a failed API response
+  function failure(resp, action) {
+    return resp.json().catch(function () {
+      return {};
+    }).then(function (data) {
+      throw new Error(data.message || action + " failed with status " + resp.status);
+    });
+  }
+
+  function download(key) {
+    var a = el("a");
+    a.href = objectURL(key);
+    a.setAttribute("download", baseName(key));
+    document.body.appendChild(a);
+    a.click();
+    a.remove();
+  }
+
+  function rename(key) {
+    var name = window.prompt("New name of " + baseName(key), baseName(key));
+    if (!name || name === baseName(key)) {
+      return;
+    }
+    var destination = key.slice(0, key.length - baseName(key).length) + name;
+    fetch("/_api/copy", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ source: key, destination: destination, move: true, overwrite: false })
+    }).then(function (resp) {
+      if (!resp.ok) {
+        return failure(resp, "rename");
+      }
+      load();
+    }).catch(function (err) {
+      window.alert(err.message);
+    });
+  }
+
+  // Delete an object, or all the objects under a prefix ending with /
+  function remove(key) {
+    var what = /\/$/.test(key) ? "the folder " + key + " and all its files" : key;
+    if (!window.confirm("Delete " + what + "?")) {
+      return;
+    }
+    fetch(objectURL(key), { method: "DELETE" }).then(function (resp) {
+      if (!resp.ok) {
+        return failure(resp, "delete");
+      }
+      return resp.text().then(function (text) {
+        // A prefix delete reports the keys it could not delete
+        var report = text ? JSON.parse(text) : {};
+        if (report.errors && report.errors.length) {
+          window.alert(report.errors.length + " files could not be deleted");
+        }
+        load();
+      });
+    }).catch(function (err) {
+      window.alert(err.message);
+    });
+  }
+
+  function upload(file) {
+    var key = currentPrefix() + file.name;
+    var item = el("li", key);
+    var bar = el("progress");
+    bar.max = file.size || 1;
+    bar.value = 0;
+    item.appendChild(bar);
+    $("uploads").appendChild(item);
+    var xhr = new XMLHttpRequest();
+    xhr.open("PUT", objectURL(key));
+    xhr.upload.onprogress = function (e) {
+      bar.value = e.loaded;
+    };
+    xhr.onload = function () {
+      if (xhr.status >= 300) {
+        item.appendChild(document.createTextNode(" failed (" + xhr.status + ")"));
+        return;
+      }
+      item.remove();
+      load();
+    };
+    xhr.onerror = function () {
+      item.appendChild(document.createTextNode(" failed"));
+    };
+    xhr.send(file);
+  }
+
+  var drop = $("drop");
+  drop.addEventListener("dragover", function (e) {
+    e.preventDefault();
+    drop.classList.add("dragging");
+  });
+  drop.addEventListener("dragleave", function () {
+    drop.classList.remove("dragging");
+  });
+  drop.addEventListener("drop", function (e) {
+    e.preventDefault();
+    drop.classList.remove("dragging");
+    if (settings.upload === false) {
+      return;
+    }
+    Array.prototype.forEach.call(e.dataTransfer.files, upload);
+  });
+  $("close").addEventListener("click", function () {
+    $("preview").hidden = true;
+    $("preview-body").textContent = "";
+  });
+  window.addEventListener("hashchange", load);
+
+  fetch("settings.json").then(function (resp) {
+    return resp.json();
+  }).then(function (s) {
+    settings = s;
+    document.title = s.title;
+    $("hint").hidden = !s.upload;
+    $("title").textContent = s.title;
+    if (s.logo) {
+      $("logo").src = s.logo;
+      $("logo").hidden = false;
+    }
+    if (s.customCss) {
+      var link = el("link");
+      link.rel = "stylesheet";
+      link.href = s.customCss;
+      document.head.appendChild(link);
+    }
+  }).finally(load);
+})();
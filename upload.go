@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Upload config type
+type uploadConfig struct {
+	// Size (in bytes) of the parts of the multipart uploads, at least 5 MiB
+	PartSize int64 `json:"partSize" yaml:"partSize" toml:"partSize"`
+	// Number of parts uploaded in parallel for each upload
+	Concurrency int `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+}
+
+// Set the upload defaults and check the values
+func (cfg *uploadConfig) validate() error {
+	if cfg.PartSize == 0 {
+		cfg.PartSize = s3manager.DefaultUploadPartSize
+	}
+	if cfg.PartSize < s3manager.MinUploadPartSize {
+		return fmt.Errorf("upload partSize must be at least %d bytes", s3manager.MinUploadPartSize)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = s3manager.DefaultUploadConcurrency
+	}
+	return nil
+}
+
+// Error of an upload body larger than maxUploadSize
+var errUploadTooLarge = errors.New("upload body too large")
+
+// Request body failing once more than the maximum upload size is read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (r *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// Check if an upload failed on a body larger than maxUploadSize, the S3 errors wrap the read error
+func isUploadTooLarge(err error) bool {
+	for err != nil {
+		if errors.Is(err, errUploadTooLarge) {
+			return true
+		}
+		awsError, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		err = awsError.OrigErr()
+	}
+	return false
+}
+
+// Refuse an upload whose Content-Length is over maxUploadSize, before its body is read,
+// and limit the read of the bodies of unknown length. Returns false if the upload is refused.
+func limitUploadSize(c *gin.Context) bool {
+	maxSize := configOf(c).MaxUploadSize
+	if maxSize <= 0 {
+		return true
+	}
+	if c.Request.ContentLength > maxSize {
+		writeUploadTooLarge(c)
+		return false
+	}
+	c.Request.Body = &limitedBody{ReadCloser: c.Request.Body, remaining: maxSize}
+	return true
+}
+
+// Write the error response of an upload larger than maxUploadSize
+func writeUploadTooLarge(c *gin.Context) {
+	c.Header("Connection", "close")
+	writeError(c, http.StatusRequestEntityTooLarge, "EntityTooLarge", fmt.Sprintf("Upload larger than %d bytes", configOf(c).MaxUploadSize), "")
+}
+
+// Uploader streaming the PUT bodies to S3, in parts for the large ones
+var uploader *s3manager.Uploader
+
+// Reader counting the bytes read
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// Stream an upload to S3. Only a few parts are held in memory, the part size grows
+// when the Content-Length is too large for the 10000 parts limit.
+func uploadObject(ctx context.Context, input *s3manager.UploadInput, contentLength int64, progress *uploadProgress, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if contentLength > uploader.PartSize*s3manager.MaxUploadParts {
+		partSize := (contentLength + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts
+		opts = append(opts, func(u *s3manager.Uploader) { u.PartSize = partSize })
+	}
+	if progress != nil {
+		opts = append(opts, s3manager.WithUploaderRequestOptions(func(r *request.Request) {
+			r.Handlers.Complete.PushBack(func(r *request.Request) {
+				if r.Operation.Name == "UploadPart" && r.Error == nil {
+					atomic.AddInt64(&progress.PartsCompleted, 1)
+				}
+			})
+		}))
+	}
+	// The parts of a failed upload are aborted below, even when the request context is canceled
+	opts = append(opts, func(u *s3manager.Uploader) { u.LeavePartsOnError = true })
+	out, err := uploader.UploadWithContext(ctx, input, opts...)
+	if failure, ok := err.(s3manager.MultiUploadFailure); ok && failure.UploadID() != "" {
+		abortUpload(input, failure.UploadID())
+	}
+	return out, err
+}
+
+// Abort a failed multipart upload, so that its parts are not billed
+func abortUpload(input *s3manager.UploadInput, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+	defer cancel()
+	_, err := s3Session.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		log.Warnf("Unable to abort the upload %s of %s: %v", uploadID, aws.StringValue(input.Key), err)
+		return
+	}
+	log.Debugf("Aborted the upload %s of %s", uploadID, aws.StringValue(input.Key))
+}
+
+// Store an object, encrypted if its key is under an encryption prefix, and drop its cached copies
+func storeObject(ctx context.Context, input *s3manager.UploadInput, contentLength int64, progress *uploadProgress, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	if rule := configFrom(ctx).encryptionFor(key); rule != nil {
+		// Encryption needs the whole body
+		b, err := ioutil.ReadAll(input.Body)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, metadata, err := rule.encrypt(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		input.Body = bytes.NewReader(ciphertext)
+		if input.Metadata == nil {
+			input.Metadata = map[string]*string{}
+		}
+		for k, v := range metadata {
+			input.Metadata[k] = v
+		}
+	}
+	resp, err := uploadObject(ctx, input, contentLength, progress, opts...)
+	if err == nil {
+		caches.invalidate(bucket, key)
+	}
+	return resp, err
+}
+
+// Check if an upload replaces an object, to answer a PUT with 200 rather than 201.
+// Only a missing object is new, a failed check counts as an existing object.
+func uploadReplaces(c *gin.Context, bucket, key string) bool {
+	input := &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	// The invalid customer keys are refused by the upload
+	applySSECustomer(c, &input.SSECustomerAlgorithm, &input.SSECustomerKey, &input.SSECustomerKeyMD5)
+	ctx, cancel := s3Context(c.Request.Context(), configOf(c).Timeouts.Head)
+	defer cancel()
+	_, err := s3Session.HeadObjectWithContext(ctx, input)
+	return !isNotFoundError(err)
+}
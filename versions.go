@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Query parameter selecting a version of an object
+const versionIDParam = "versionId"
+
+// Response headers of the version of an object
+const (
+	versionIDHeader    = "X-Amz-Version-Id"
+	deleteMarkerHeader = "X-Amz-Delete-Marker"
+)
+
+// Maximum number of versions of a listing page
+const maxVersionsPage = 1000
+
+// Get the version of an object asked by a request, nil for the current version
+func requestedVersion(c *gin.Context) *string {
+	if versionID := c.Query(versionIDParam); versionID != "" {
+		return aws.String(versionID)
+	}
+	return nil
+}
+
+// Set the version of an object on a response, S3 gives no version on unversioned buckets
+func setVersionHeader(header http.Header, versionID *string) {
+	if v := aws.StringValue(versionID); v != "" {
+		header.Set(versionIDHeader, v)
+	}
+}
+
+// Listed object version type
+type objectVersion struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId"`
+	IsLatest     bool      `json:"isLatest"`
+	DeleteMarker bool      `json:"deleteMarker,omitempty"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Listing of the object versions of a prefix type, the markers give the next page
+type versionsResult struct {
+	Prefix              string          `json:"prefix"`
+	Versions            []objectVersion `json:"versions"`
+	Truncated           bool            `json:"truncated"`
+	NextKeyMarker       string          `json:"nextKeyMarker,omitempty"`
+	NextVersionIDMarker string          `json:"nextVersionIdMarker,omitempty"`
+}
+
+// Serve a page of the versions and delete markers of the objects under a prefix, newest first for each key
+func serveVersions(c *gin.Context) {
+	config := configOf(c)
+	prefix := strings.TrimPrefix(c.Query("prefix"), "/")
+	if !checkKeyAccess(c, http.MethodGet, prefix) {
+		return
+	}
+	bucket, keyPrefix := config.resolveObject(c.Request.Host, prefix)
+	maxKeys := maxVersionsPage
+	if limit, err := strconv.Atoi(c.Query("maxKeys")); err == nil && limit > 0 && limit < maxKeys {
+		maxKeys = limit
+	}
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucket), Prefix: aws.String(keyPrefix), MaxKeys: aws.Int64(int64(maxKeys))}
+	if marker := strings.TrimPrefix(c.Query("keyMarker"), "/"); marker != "" {
+		_, key := config.resolveObject(c.Request.Host, marker)
+		input.KeyMarker = aws.String(key)
+		if versionMarker := c.Query("versionIdMarker"); versionMarker != "" {
+			input.VersionIdMarker = aws.String(versionMarker)
+		}
+	}
+	ctx, cancel := s3Context(c.Request.Context(), config.Timeouts.List)
+	defer cancel()
+	page, err := s3Session.ListObjectVersionsWithContext(ctx, input)
+	if handleHTTPException(c, prefix, err) != nil {
+		return
+	}
+	// Keys of the clients, without the key prefix
+	pathOf := func(key *string) string {
+		return prefix + strings.TrimPrefix(aws.StringValue(key), keyPrefix)
+	}
+	// The hidden keys and the keys denied by the OIDC permissions or the access control rules are left out
+	visible := func(key *string) bool {
+		p := pathOf(key)
+		return !config.isHiddenKey(p) && aclAllows(c, http.MethodGet, p)
+	}
+	result := versionsResult{Prefix: prefix, Versions: []objectVersion{}, Truncated: aws.BoolValue(page.IsTruncated)}
+	for _, v := range page.Versions {
+		if !visible(v.Key) {
+			continue
+		}
+		result.Versions = append(result.Versions, objectVersion{
+			Key:          pathOf(v.Key),
+			VersionID:    aws.StringValue(v.VersionId),
+			IsLatest:     aws.BoolValue(v.IsLatest),
+			Size:         aws.Int64Value(v.Size),
+			ETag:         aws.StringValue(v.ETag),
+			LastModified: aws.TimeValue(v.LastModified),
+		})
+	}
+	for _, m := range page.DeleteMarkers {
+		if !visible(m.Key) {
+			continue
+		}
+		result.Versions = append(result.Versions, objectVersion{
+			Key:          pathOf(m.Key),
+			VersionID:    aws.StringValue(m.VersionId),
+			IsLatest:     aws.BoolValue(m.IsLatest),
+			DeleteMarker: true,
+			LastModified: aws.TimeValue(m.LastModified),
+		})
+	}
+	sort.SliceStable(result.Versions, func(i, j int) bool {
+		a, b := result.Versions[i], result.Versions[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.LastModified.After(b.LastModified)
+	})
+	if result.Truncated {
+		result.NextKeyMarker = pathOf(page.NextKeyMarker)
+		result.NextVersionIDMarker = aws.StringValue(page.NextVersionIdMarker)
+	}
+	c.JSON(http.StatusOK, result)
+}
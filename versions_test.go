@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestVersionsAccess(t *testing.T) {
+	issuer := newTestIssuer(t)
+	router, fake := newTestServer(t, &webConfig{S3bucket: "bucket",
+		Auth: authConfig{OIDC: issuer.config(
+			oidcPermission{Prefix: "", Read: []string{"*"}},
+			oidcPermission{Prefix: "docs/secret/", Read: []string{"admin"}},
+		)},
+	})
+	for _, key := range []string{"docs/a.txt", "docs/secret/b.txt"} {
+		fake.put("bucket/"+key, testContent)
+	}
+	w := serveTestRequest(router, http.MethodGet, "/_api/versions?prefix=docs/", bearer(issuer.token(t, "staff")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var result versionsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("invalid versions %s: %v", w.Body.String(), err)
+	}
+	if len(result.Versions) != 1 || result.Versions[0].Key != "docs/a.txt" {
+		t.Errorf("versions = %v, want docs/a.txt only", result.Versions)
+	}
+
+	w = serveTestRequest(router, http.MethodGet, "/_api/versions?prefix=docs/secret/", bearer(issuer.token(t, "staff")))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status of a denied prefix = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+}
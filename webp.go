@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+)
+
+// Lossless WebP (VP8L) encoding of the transformed images, as Go has no WebP encoder.
+// The pixels are written as literals with a single set of prefix codes, without transforms
+// nor backward references: fast and simple, but the files are larger than the ones of libwebp.
+
+// Alphabet sizes of the VP8L prefix codes: green with the length codes, red, blue, alpha and distance
+var webpAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// Order of the code lengths of the code length code
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Writer of the VP8L bit stream, least significant bits first
+type webpBitWriter struct {
+	buf  []byte
+	bits uint64
+	n    uint
+}
+
+func (w *webpBitWriter) write(bits uint32, n uint) {
+	w.bits |= uint64(bits) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.n -= 8
+	}
+}
+
+func (w *webpBitWriter) bytes() []byte {
+	if w.n > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.n = 0, 0
+	}
+	return w.buf
+}
+
+// Compute the Huffman code lengths of the symbol counts, the unused symbols get 0
+func huffmanLengths(counts []int) []uint8 {
+	type node struct {
+		count       int
+		left, right int
+	}
+	lengths := make([]uint8, len(counts))
+	var nodes []node
+	var active []int
+	for symbol, count := range counts {
+		if count > 0 {
+			// Leaves have no left child, their right is the symbol
+			nodes = append(nodes, node{count, -1, symbol})
+			active = append(active, len(nodes)-1)
+		}
+	}
+	if len(active) == 1 {
+		lengths[nodes[0].right] = 1
+		return lengths
+	}
+	for len(active) > 1 {
+		sort.SliceStable(active, func(i, j int) bool { return nodes[active[i]].count < nodes[active[j]].count })
+		nodes = append(nodes, node{nodes[active[0]].count + nodes[active[1]].count, active[0], active[1]})
+		active = append(active[2:], len(nodes)-1)
+	}
+	var walk func(i int, depth uint8)
+	walk = func(i int, depth uint8) {
+		if nodes[i].left < 0 {
+			lengths[nodes[i].right] = depth
+			return
+		}
+		walk(nodes[i].left, depth+1)
+		walk(nodes[i].right, depth+1)
+	}
+	if len(active) == 1 {
+		walk(active[0], 0)
+	}
+	return lengths
+}
+
+// Compute the code lengths of a prefix code of at most maxBits, the counts are flattened until the code fits
+func limitedCodeLengths(counts []int, maxBits uint8) []uint8 {
+	counts = append([]int(nil), counts...)
+	for {
+		lengths := huffmanLengths(counts)
+		fits := true
+		for _, l := range lengths {
+			if l > maxBits {
+				fits = false
+			}
+		}
+		if fits {
+			return lengths
+		}
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = (count + 1) / 2
+			}
+		}
+	}
+}
+
+// Get the canonical codes of the code lengths, bit reversed to be written least significant bit first
+func canonicalCodes(lengths []uint8) []uint32 {
+	var lengthCounts [16]uint32
+	for _, l := range lengths {
+		lengthCounts[l]++
+	}
+	lengthCounts[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for bits := 1; bits < 16; bits++ {
+		code = (code + lengthCounts[bits-1]) << 1
+		next[bits] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		reversed := uint32(0)
+		for i := uint8(0); i < l; i++ {
+			reversed = reversed<<1 | (c>>i)&1
+		}
+		codes[symbol] = reversed
+	}
+	return codes
+}
+
+// Write the prefix code of the symbol counts, returns its code lengths and codes
+func (w *webpBitWriter) writePrefixCode(counts []int) ([]uint8, []uint32) {
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	lengths := make([]uint8, len(counts))
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		// Simple code, a single symbol takes no bits
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return lengths, canonicalCodes(lengths)
+	}
+
+	lengths = limitedCodeLengths(counts, 15)
+	codeLengthCounts := make([]int, 19)
+	distinct := 0
+	for _, l := range lengths {
+		if codeLengthCounts[l] == 0 {
+			distinct++
+		}
+		codeLengthCounts[l]++
+	}
+	if distinct == 1 {
+		// A code of a single length needs a second symbol, unused
+		codeLengthCounts[(lengths[0]+1)%16] = 1
+	}
+	codeLengthLengths := limitedCodeLengths(codeLengthCounts, 7)
+	codeLengthCodes := canonicalCodes(codeLengthLengths)
+	w.write(0, 1)
+	n := len(webpCodeLengthOrder)
+	for n > 4 && codeLengthLengths[webpCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	w.write(uint32(n-4), 4)
+	for _, symbol := range webpCodeLengthOrder[:n] {
+		w.write(uint32(codeLengthLengths[symbol]), 3)
+	}
+	// All the code lengths follow, without max_symbol
+	w.write(0, 1)
+	for _, l := range lengths {
+		w.write(codeLengthCodes[l], uint(codeLengthLengths[l]))
+	}
+	return lengths, canonicalCodes(lengths)
+}
+
+// Encode an image as a lossless WebP file, at most 16384 pixels wide and high
+func encodeWebP(out io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	pixels, ok := img.(*image.NRGBA)
+	if !ok || pixels.Rect.Min != (image.Point{}) || pixels.Stride != 4*bounds.Dx() {
+		pixels = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(pixels, pixels.Rect, img, bounds.Min, draw.Src)
+	}
+	var counts [5][]int
+	for i, size := range webpAlphabetSizes {
+		counts[i] = make([]int, size)
+	}
+	alpha := uint32(0)
+	for i := 0; i < len(pixels.Pix); i += 4 {
+		r, g, b, a := pixels.Pix[i], pixels.Pix[i+1], pixels.Pix[i+2], pixels.Pix[i+3]
+		counts[0][g]++
+		counts[1][r]++
+		counts[2][b]++
+		counts[3][a]++
+		if a != 0xff {
+			alpha = 1
+		}
+	}
+
+	w := &webpBitWriter{}
+	w.write(0x2f, 8)
+	w.write(uint32(bounds.Dx()-1), 14)
+	w.write(uint32(bounds.Dy()-1), 14)
+	w.write(alpha, 1)
+	w.write(0, 3)
+	// No transform, color cache nor meta prefix codes
+	w.write(0, 1)
+	w.write(0, 1)
+	w.write(0, 1)
+	var lengths [5][]uint8
+	var codes [5][]uint32
+	for i := range counts {
+		lengths[i], codes[i] = w.writePrefixCode(counts[i])
+	}
+	for i := 0; i < len(pixels.Pix); i += 4 {
+		// Green first, then red, blue and alpha
+		for code, channel := range [4]int{1, 0, 2, 3} {
+			v := pixels.Pix[i+channel]
+			w.write(codes[code][v], uint(lengths[code][v]))
+		}
+	}
+	data := w.bytes()
+
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+len(data)&1))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if len(data)&1 == 1 {
+		data = append(data, 0)
+	}
+	_, err := out.Write(data)
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Path prefix of the well-known URIs (RFC 8615)
+const wellKnownPrefix = ".well-known/"
+
+// Well-known mapping config type
+type wellKnownConfig struct {
+	// Bucket serving the well-known paths, default is the main bucket
+	Bucket string `json:"bucket" yaml:"bucket" toml:"bucket"`
+	// Key prefix replacing ".well-known/"
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+}
+
+// Normalize the prefix, ending with / so that it stays a directory of the keys
+func (w *wellKnownConfig) validate() error {
+	if w.Bucket != "" {
+		if err := checkBucketName(w.Bucket); err != nil {
+			return err
+		}
+	}
+	w.Prefix = strings.TrimPrefix(w.Prefix, "/")
+	if w.Prefix != "" && !strings.HasSuffix(w.Prefix, "/") {
+		w.Prefix += "/"
+	}
+	return nil
+}
+
+// Check if the well-known paths are mapped to a distinct location
+func (w wellKnownConfig) isMapped() bool {
+	return w.Bucket != "" || w.Prefix != ""
+}
+
+// Resolve the bucket and the key of a well-known path
+func (w wellKnownConfig) resolve(defaultBucket, path string) (bucket, key string) {
+	bucket = w.Bucket
+	if bucket == "" {
+		bucket = defaultBucket
+	}
+	if w.Prefix == "" {
+		return bucket, path
+	}
+	return bucket, w.Prefix + strings.TrimPrefix(path, wellKnownPrefix)
+}
+
+// Check if a path (without leading /) is a well-known URI.
+// Well-known URIs (ACME challenges, security.txt, ...) must stay readable without authentication.
+func isWellKnownPath(path string) bool {
+	return strings.HasPrefix(path, wellKnownPrefix)
+}
+
+// Check if a method only reads a well-known URI, the writes need authentication
+func isWellKnownRead(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}